@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/ripe"
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the optional mp configuration file, read from MPAT_CONFIG or
+// else from mpat/config.yaml in the user configuration directory.
+type fileConfig struct {
+	// ASNGroups are named ASN lists, selected with --asn-group.
+	ASNGroups map[string][]uint32 `yaml:"asn_groups"`
+}
+
+// loadConfig reads the configuration file. A missing default file is an
+// empty configuration; a missing MPAT_CONFIG file is an error.
+func loadConfig() (fileConfig, error) {
+	var cfg fileConfig
+	path := os.Getenv("MPAT_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return cfg, nil
+		}
+		path = filepath.Join(dir, "mpat", "config.yaml")
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// asnFlags holds the ASN selection flags. The ASNs of every flag set are
+// combined; at least one must be set.
+type asnFlags struct {
+	asns           string
+	files          []string
+	groups         []string
+	tier1          bool
+	expand         int
+	neighbourTypes []string
+}
+
+// register adds the selection flags to cmd, and the neighbour expansion
+// flags if expand is true.
+func (f *asnFlags) register(cmd *cobra.Command, expand bool) {
+	cmd.Flags().StringVar(&f.asns, "asns", "", "Comma-separated list of ASNs (e.g. 3356,1299,3257)")
+	cmd.Flags().StringSliceVar(&f.files, "asns-file", nil, "File of ASNs, one per line, # comments allowed, may be repeated")
+	cmd.Flags().StringSliceVar(&f.groups, "asn-group", nil, "Named ASN group of the config file, or tier1, may be repeated")
+	cmd.Flags().BoolVar(&f.tier1, "tier1", false, "Use the hardcoded list of tier-1 ASNs, same as --asn-group tier1")
+	if expand {
+		cmd.Flags().IntVar(&f.expand, "expand-neighbours", 0, "Add the ASNs up to this many neighbour hops away, from RIPE Stat asn-neighbours")
+		cmd.Flags().StringSliceVar(&f.neighbourTypes, "neighbour-types", nil, "Neighbour types followed by --expand-neighbours: left, right, uncertain (default: all)")
+	}
+}
+
+// resolve returns the selected ASNs in the order of --asns, --asns-file,
+// --asn-group and --tier1, once per source that selects them, followed by
+// their neighbours at the query time at if --expand-neighbours is set.
+func (f *asnFlags) resolve(ctx context.Context, client *ripe.RipeClient, at time.Time) ([]service.SelectedASN, error) {
+	var selected []service.SelectedASN
+	add := func(source string, asns []uint32) {
+		for _, asn := range asns {
+			selected = append(selected, service.SelectedASN{ASN: asn, Source: source})
+		}
+	}
+
+	if f.asns != "" {
+		asns, err := parseASNs(strings.Split(f.asns, ","))
+		if err != nil {
+			return nil, err
+		}
+		if len(asns) == 0 {
+			return nil, fmt.Errorf("--asns must contain at least one ASN")
+		}
+		add("asns", asns)
+	}
+	for _, path := range f.files {
+		entries, err := readListFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --asns-file: %w", err)
+		}
+		asns, err := parseASNs(entries)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		add("file:"+path, asns)
+	}
+	groups := f.groups
+	if f.tier1 && !slices.Contains(groups, "tier1") {
+		groups = append(groups, "tier1")
+	}
+	if len(groups) > 0 {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range groups {
+			asns, ok := cfg.ASNGroups[name]
+			if !ok && name == "tier1" {
+				asns, ok = ripe.Tier1ASNs, true
+			}
+			if !ok {
+				return nil, fmt.Errorf("unknown ASN group %q, define it under asn_groups in the config file", name)
+			}
+			add("group:"+name, asns)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no ASN selected, set --asns, --asns-file, --asn-group or --tier1")
+	}
+	if f.expand <= 0 {
+		return selected, nil
+	}
+
+	for _, t := range f.neighbourTypes {
+		if t != "left" && t != "right" && t != "uncertain" {
+			return nil, fmt.Errorf("invalid --neighbour-types %q, expected left, right or uncertain", t)
+		}
+	}
+	var seeds []uint32
+	for _, s := range selected {
+		if !slices.Contains(seeds, s.ASN) {
+			seeds = append(seeds, s.ASN)
+		}
+	}
+	query := client.NeighboursByASNs(seeds)
+	if !at.IsZero() {
+		query = query.AtTime(at)
+	}
+	slog.Default().InfoContext(ctx, "expanding ASN set with neighbours", "asns", len(seeds), "hops", f.expand, "query_time", at)
+	expanded, err := query.Expand(ctx, f.expand, f.neighbourTypes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand neighbours: %w", err)
+	}
+	for _, e := range expanded[len(seeds):] {
+		selected = append(selected, service.SelectedASN{ASN: e.ASN, Source: "neighbour", Hops: e.Hops, Via: e.Via})
+	}
+	slog.Default().InfoContext(ctx, "expanded ASN set", "asns", len(expanded), "added", len(expanded)-len(seeds))
+	return selected, nil
+}
+
+// uniqueASNs returns the distinct ASNs of selected, in order.
+func uniqueASNs(selected []service.SelectedASN) []uint32 {
+	var asns []uint32
+	seen := make(map[uint32]bool)
+	for _, s := range selected {
+		if !seen[s.ASN] {
+			seen[s.ASN] = true
+			asns = append(asns, s.ASN)
+		}
+	}
+	return asns
+}
+
+// parseASNs parses ASNs written as 3356 or AS3356, skipping blank entries.
+func parseASNs(entries []string) ([]uint32, error) {
+	var asns []uint32
+	for _, s := range entries {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ASN %q: %w", s, err)
+		}
+		asns = append(asns, uint32(n))
+	}
+	return asns, nil
+}
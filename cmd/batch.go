@@ -0,0 +1,492 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/batch"
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/ripe"
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func batchCmd() *cobra.Command {
+	batchCmd := &cobra.Command{
+		Use:   "batch",
+		Short: "Run fetch and compute jobs declared in a manifest",
+	}
+	batchCmd.AddCommand(batchRunCmd())
+	return batchCmd
+}
+
+func batchRunCmd() *cobra.Command {
+	var (
+		concurrency int
+		statePath   string
+		reportPath  string
+		dryRun      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "run <manifest.yaml>",
+		Short: "Run the jobs of a batch manifest",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBatch(
+				cmd.Context(),
+				args[0],
+				concurrency,
+				statePath,
+				reportPath,
+				dryRun,
+			)
+		},
+	}
+
+	cmd.Flags().IntVar(&concurrency, "concurrency", 0, "Maximum number of jobs running at the same time; overrides the manifest")
+	cmd.Flags().StringVar(&statePath, "state", "", "State file recording completed jobs (default <manifest>.state.json)")
+	cmd.Flags().StringVar(&reportPath, "report", "", "JSON run report output path (default <manifest>.report.json)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the expanded jobs without running them")
+
+	return cmd
+}
+
+func runBatch(ctx context.Context, manifestPath string, concurrency int, statePath, reportPath string, dryRun bool) error {
+	log := slog.Default()
+
+	manifest, err := batch.LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if concurrency > 0 {
+		manifest.Concurrency = concurrency
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(manifestPath, ".yaml"), ".yml")
+	if statePath == "" {
+		statePath = base + ".state.json"
+	}
+	if reportPath == "" {
+		reportPath = base + ".report.json"
+	}
+
+	// A dry run expands the manifest offline: it neither connects to
+	// ClickHouse nor logs in to Iris.
+	b := &batchBuilder{database: manifest.Database, offline: dryRun, units: make(map[string][]batch.Job)}
+	if !dryRun {
+		config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+		if err != nil {
+			return fmt.Errorf("failed to parse config from DSN: %w", err)
+		}
+		if b.store, err = store.NewStore(config); err != nil {
+			return fmt.Errorf("failed to create store: %w", err)
+		}
+		if b.database == "" {
+			b.database = config.Database
+		}
+	} else if b.database == "" {
+		b.database = store.DefaultDatabase
+		if dsn := os.Getenv("MPAT_CLICKHOUSE"); dsn != "" {
+			config, err := store.ConfigFromDSN(dsn)
+			if err != nil {
+				return fmt.Errorf("failed to parse config from DSN: %w", err)
+			}
+			b.database = config.Database
+		}
+	}
+	defer b.close()
+
+	var jobs []batch.Job
+	for _, spec := range manifest.Jobs {
+		expanded, err := b.expand(spec)
+		if err != nil {
+			return fmt.Errorf("job %q: %w", spec.Name, err)
+		}
+		b.units[spec.Name] = expanded
+		jobs = append(jobs, expanded...)
+	}
+
+	state, err := batch.LoadState(statePath)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		for _, j := range jobs {
+			status := "pending"
+			switch {
+			case j.Vars["index"] == unresolvedIndex:
+				status = "unresolved"
+			case state.Done(j.ID):
+				status = string(batch.StatusCompleted)
+			}
+			fmt.Printf("%-10s %s\n", status, j.ID)
+		}
+		return nil
+	}
+
+	log.InfoContext(ctx, "starting batch",
+		"manifest", manifestPath,
+		"jobs", len(jobs),
+		"concurrency", manifest.Concurrency,
+		"state", statePath,
+	)
+
+	runner := batch.NewRunner(batch.RunnerConfig{
+		Concurrency: manifest.Concurrency,
+		State:       state,
+	})
+	report := runner.Run(ctx, manifestPath, jobs)
+	if err := report.Write(reportPath); err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "batch complete",
+		"succeeded", report.Counts[batch.StatusSucceeded],
+		"completed_previously", report.Counts[batch.StatusCompleted],
+		"failed", report.Counts[batch.StatusFailed],
+		"skipped", report.Counts[batch.StatusSkipped],
+		"canceled", report.Counts[batch.StatusCanceled],
+		"report", reportPath,
+		"elapsed", report.FinishedAt.Sub(report.StartedAt).Round(time.Second),
+	)
+
+	if n := report.Failed(); n > 0 {
+		return fmt.Errorf("%d of %d job(s) failed or were skipped, see %s", n, len(jobs), reportPath)
+	}
+	return nil
+}
+
+// unresolvedIndex is the index of the jobs an offline expansion lists for
+// the "all" index selector, which needs the measurements of Iris.
+const unresolvedIndex = "{index}"
+
+// batchBuilder expands manifest entries into runnable jobs. Clients are
+// created on first use and shared by all jobs.
+type batchBuilder struct {
+	store      *store.Store
+	database   string
+	offline    bool // expand without Iris, for a dry run
+	irisClient *iris.IrisClient
+	ripeClient *ripe.RipeClient
+
+	// units holds the expanded jobs of each entry, by entry name, so that
+	// compute entries can refer to the tables of a fetch entry.
+	units map[string][]batch.Job
+}
+
+func (b *batchBuilder) close() {
+	if b.irisClient != nil {
+		_ = b.irisClient.Logout()
+	}
+}
+
+func (b *batchBuilder) ensureIris() (*iris.IrisClient, error) {
+	if b.irisClient == nil {
+		c, err := newIrisClientFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		b.irisClient = c
+	}
+	return b.irisClient, nil
+}
+
+func (b *batchBuilder) ensureRipe() *ripe.RipeClient {
+	if b.ripeClient == nil {
+		b.ripeClient = ripe.NewRipeClient(ripe.RipeConfig{
+			Endpoint:  envOr("MPAT_RIPE_STAT_ENDPOINT", ripe.DefaultEndpoint),
+			SourceApp: envOr("MPAT_RIPE_SOURCEAPP", ripe.DefaultSourceApp),
+		})
+	}
+	return b.ripeClient
+}
+
+func (b *batchBuilder) qualified(table string) string {
+	return fmt.Sprintf("%s.%s", b.database, table)
+}
+
+func (b *batchBuilder) expand(spec batch.JobSpec) ([]batch.Job, error) {
+	switch spec.Type {
+	case batch.JobFetchIrisResults:
+		return b.expandFetchIris(spec)
+	case batch.JobFetchRipePrefixes:
+		return b.expandFetchRipe(spec)
+	case batch.JobComputeFIEs:
+		return b.expandComputeFIEs(spec)
+	default:
+		return nil, fmt.Errorf("unknown job type %q", spec.Type)
+	}
+}
+
+func (b *batchBuilder) expandFetchIris(spec batch.JobSpec) ([]batch.Job, error) {
+	dates, err := parseDateRange(spec.Dates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dates: %w", err)
+	}
+	kinds, err := parseKinds(strings.Join(spec.Kinds, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid kinds: %w", err)
+	}
+	sel, err := parseIndexSelector(spec.Indexes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid indexes: %w", err)
+	}
+	state := spec.State
+	if state == "" {
+		state = string(iris.StateFinished)
+	}
+	lite := spec.Lite == nil || *spec.Lite
+	filterSource := spec.FilterSource == nil || *spec.FilterSource
+	chunkSize := spec.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = service.DefaultFetchChunkSize
+	}
+	policy := store.PreparationPolicy(spec.Policy)
+	if policy == "" {
+		policy = service.DefaultFetchTablePreparationPolicy
+	}
+
+	var (
+		irisClient *iris.IrisClient
+		resolved   []dateKindJob
+	)
+	if b.offline {
+		resolved, err = offlineDateKindJobs(dates, kinds, sel, spec.Table, b.database, lite)
+	} else if irisClient, err = b.ensureIris(); err == nil {
+		resolved, err = resolveDateKindJobs(irisClient, dates, kinds, sel, state, spec.Table, b.database, lite)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]batch.Job, 0, len(resolved))
+	for _, j := range resolved {
+		var ipVersion uint8
+		if filterSource {
+			ipVersion = j.kind.ipVersion()
+		}
+		svc := service.NewFetchService(b.store, irisClient, service.FetchConfig{
+			ChunkSize:         chunkSize,
+			PreparationPolicy: policy,
+			Lite:              lite,
+			EWMAAlpha:         0.2,
+			IPVersion:         ipVersion,
+			Provenance:        spec.Provenance,
+		})
+		dest := b.qualified(j.dest.Table)
+		jobs = append(jobs, batch.Job{
+			ID:   fmt.Sprintf("%s:%s", spec.Type, dest),
+			Name: spec.Name,
+			Type: spec.Type,
+			Dest: dest,
+			Vars: j.vars,
+			Run: func(ctx context.Context) error {
+				if j.err != nil {
+					return j.err
+				}
+				return svc.Fetch(ctx, j.sources, j.dest)
+			},
+		})
+	}
+	return jobs, nil
+}
+
+// offlineDateKindJobs returns the jobs of the selected indexes without
+// resolving their measurements, which is enough to name their tables. The
+// "all" selector yields one job per date and kind, with an unresolved index.
+func offlineDateKindJobs(dates []time.Time, kinds []MeasurementKind, sel indexSelector, destTable, database string, lite bool) ([]dateKindJob, error) {
+	var jobs []dateKindJob
+	for _, k := range kinds {
+		for _, date := range dates {
+			indexes := []string{unresolvedIndex}
+			if !sel.all {
+				indexes = indexes[:0]
+				for _, index := range sel.indexes {
+					indexes = append(indexes, fmt.Sprintf("%d", index))
+				}
+			}
+			for _, index := range indexes {
+				vars := dateKindVars(date, k, index, lite)
+				table, err := renderTableName(destTable, vars)
+				if err != nil {
+					return nil, err
+				}
+				jobs = append(jobs, dateKindJob{
+					date: date,
+					kind: k,
+					dest: store.DatabaseTable{Database: database, Table: table},
+					vars: vars,
+				})
+			}
+		}
+	}
+	return jobs, nil
+}
+
+func (b *batchBuilder) expandFetchRipe(spec batch.JobSpec) ([]batch.Job, error) {
+	dates, err := parseDateRange(spec.Dates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dates: %w", err)
+	}
+	asns, source := spec.ASNs, "asns"
+	if spec.Tier1 {
+		asns, source = ripe.Tier1ASNs, "group:tier1"
+	}
+	asnSet := make([]service.SelectedASN, len(asns))
+	for i, asn := range asns {
+		asnSet[i] = service.SelectedASN{ASN: asn, Source: source}
+	}
+	policy := store.PreparationPolicy(spec.Policy)
+	if policy == "" {
+		policy = service.DefaultRipePrefixesPreparationPolicy
+	}
+	config := service.RipePrefixesConfig{
+		ASNs:              asns,
+		PreparationPolicy: policy,
+		ASNSet:            asnSet,
+	}
+	prepareSvc := service.NewRipePrefixesService(b.store, b.ensureRipe(), config)
+	// Snapshots may share a destination: it is prepared once with the
+	// policy by a job the snapshot jobs need, and they append to it.
+	config.PreparationPolicy = store.PreparationPolicyAppend
+	svc := service.NewRipePrefixesService(b.store, b.ensureRipe(), config)
+
+	var jobs []batch.Job
+	prepared := make(map[string]string) // qualified table -> ID of its prepare job
+	for _, date := range dates {
+		for _, snapshot := range spec.Snapshots {
+			tod := ripe.TimeOfDay(snapshot)
+			queryTime, err := tod.QueryTime(date)
+			if err != nil {
+				return nil, err
+			}
+			vars := map[string]string{
+				"date":     date.Format("20060102"),
+				"snapshot": snapshot,
+			}
+			table, err := renderTableName(spec.Table, vars)
+			if err != nil {
+				return nil, err
+			}
+			dest := store.DatabaseTable{Database: b.database, Table: table}
+			prepareID, ok := prepared[b.qualified(table)]
+			if !ok {
+				prepareID = fmt.Sprintf("%s:%s", spec.Type, b.qualified(table))
+				prepared[b.qualified(table)] = prepareID
+				jobs = append(jobs, batch.Job{
+					ID:   prepareID,
+					Name: spec.Name,
+					Type: spec.Type,
+					Dest: b.qualified(table),
+					Vars: vars,
+					Run: func(ctx context.Context) error {
+						return prepareSvc.Prepare(ctx, dest)
+					},
+				})
+			}
+			jobs = append(jobs, batch.Job{
+				ID:    fmt.Sprintf("%s:%s@%s", spec.Type, b.qualified(table), queryTime.Format("2006-01-02T15:04:05Z")),
+				Name:  spec.Name,
+				Type:  spec.Type,
+				Dest:  b.qualified(table),
+				Needs: []string{prepareID},
+				Vars:  vars,
+				Run: func(ctx context.Context) error {
+					return svc.FetchAt(ctx, dest, queryTime)
+				},
+			})
+		}
+	}
+	return jobs, nil
+}
+
+func (b *batchBuilder) expandComputeFIEs(spec batch.JobSpec) ([]batch.Job, error) {
+	config := service.DefaultFIEComputeConfig()
+	config.PreparationPolicy = store.PreparationPolicyAppend
+	if spec.Policy != "" {
+		config.PreparationPolicy = store.PreparationPolicy(spec.Policy)
+	}
+	if spec.Cardinality != "" {
+		config.Cardinality = service.CardinalityPolicy(spec.Cardinality)
+	}
+	if spec.Nullity != "" {
+		config.Nullity = service.NullityPolicy(spec.Nullity)
+	}
+	if spec.RTTResolution > 0 {
+		config.RTTResolution = spec.RTTResolution
+	}
+	if spec.ChunkSize > 0 {
+		config.ChunkSize = spec.ChunkSize
+	}
+	if err := service.ValidatePolicies(config.Cardinality, config.Nullity); err != nil {
+		return nil, err
+	}
+	svc := service.NewFIEComputeService(b.store, config)
+
+	// Each input is described by the template variables it was rendered with.
+	type input struct {
+		table string
+		vars  map[string]string
+	}
+	var inputs []input
+	switch {
+	case spec.From != "":
+		for _, u := range b.units[spec.From] {
+			table := strings.TrimPrefix(u.Dest, b.database+".")
+			inputs = append(inputs, input{table: table, vars: u.Vars})
+		}
+	case spec.Dates != "":
+		dates, err := parseDateRange(spec.Dates)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dates: %w", err)
+		}
+		for _, date := range dates {
+			vars := map[string]string{"date": date.Format("20060102")}
+			table, err := renderTableName(spec.Input, vars)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, input{table: table, vars: vars})
+		}
+	default:
+		table, err := renderTableName(spec.Input, nil)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input{table: table})
+	}
+
+	jobs := make([]batch.Job, 0, len(inputs))
+	for _, in := range inputs {
+		vars := maps.Clone(in.vars)
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		vars["input"] = in.table
+		table, err := renderTableName(spec.Table, vars)
+		if err != nil {
+			return nil, err
+		}
+		source := store.DatabaseTable{Database: b.database, Table: in.table}
+		dest := store.DatabaseTable{Database: b.database, Table: table}
+		jobs = append(jobs, batch.Job{
+			ID:     fmt.Sprintf("%s:%s->%s", spec.Type, b.qualified(in.table), b.qualified(table)),
+			Name:   spec.Name,
+			Type:   spec.Type,
+			Dest:   b.qualified(table),
+			Inputs: []string{b.qualified(in.table)},
+			Vars:   vars,
+			Run: func(ctx context.Context) error {
+				return svc.Compute(ctx, source, dest)
+			},
+		})
+	}
+	return jobs, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testManifest = `database: db
+jobs:
+  - name: zeph
+    type: fetch-iris-results
+    dates: 2026-06-01
+    kinds: [zeph]
+    indexes: 0,2
+    table: iris_{kind}_{index}_{date}
+  - name: ipv6
+    type: fetch-iris-results
+    dates: 2026-06-01
+    kinds: [ipv6]
+    indexes: all
+    table: iris_{kind}_{index}_{date}
+  - name: fies
+    type: compute-fies
+    from: zeph
+    table: fies_{kind}_{index}_{date}
+`
+
+// TestBatchDryRunOffline lists the jobs of a manifest without ClickHouse and
+// without logging in to Iris.
+func TestBatchDryRunOffline(t *testing.T) {
+	srv := newTestIris(t)
+	t.Setenv("MPAT_CLICKHOUSE", "")
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "batch.yaml")
+	if err := os.WriteFile(manifest, []byte(testManifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = runBatch(context.Background(), manifest, 0, "", "", true)
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("runBatch: %v", err)
+	}
+	out, _ := io.ReadAll(r)
+
+	want := []string{
+		"pending    fetch-iris-results:db.iris_zeph_0_20260601",
+		"pending    fetch-iris-results:db.iris_zeph_2_20260601",
+		"unresolved fetch-iris-results:db.iris_ipv6_{index}_20260601",
+		"pending    compute-fies:db.iris_zeph_0_20260601->db.fies_zeph_0_20260601",
+		"pending    compute-fies:db.iris_zeph_2_20260601->db.fies_zeph_2_20260601",
+	}
+	if got := strings.Split(strings.TrimSpace(string(out)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("dry run listed\n%s\nwant\n%s", out, strings.Join(want, "\n"))
+	}
+	if stats := srv.Stats(); stats.Logins != 0 {
+		t.Errorf("dry run logged in to Iris %d time(s)", stats.Logins)
+	}
+}
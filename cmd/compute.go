@@ -10,5 +10,8 @@ func computeCmd() *cobra.Command {
 		Short: "Compute derived tables from source data",
 	}
 	computeCmd.AddCommand(computeResultsFiesCmd())
+	computeCmd.AddCommand(computeAnnotateFiesCmd())
+	computeCmd.AddCommand(computePrefixChangesCmd())
+	computeCmd.AddCommand(computePrefixAnalysisCmd())
 	return computeCmd
 }
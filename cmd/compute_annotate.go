@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func computeAnnotateFiesCmd() *cobra.Command {
+	var (
+		policy      string
+		chunkSize   int
+		dict        string
+		prefixes    string
+		queryTime   string
+		closest     bool
+		resumeAfter string
+	)
+	cmd := &cobra.Command{
+		Use:   "annotate-fies <fies-table> <output-table>",
+		Short: "Annotate FIEs with the origin ASNs of their near and far hops and destination",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAnnotateFies(cmd.Context(), args[0], args[1], policy, chunkSize, dict, prefixes, queryTime, closest, resumeAfter)
+		},
+	}
+	cmd.Flags().StringVar(&policy, "policy", string(service.DefaultFIEAnnotatePreparationPolicy), "Write policy: replace, truncate, fail, append")
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", service.DefaultFIEAnnotateChunkSize, "Number of near reply addresses per chunk")
+	cmd.Flags().StringVar(&dict, "dict", "", "IP_TRIE dictionary created by mp dict create")
+	cmd.Flags().StringVar(&prefixes, "prefixes", "", "ripeprefixes table, alternative to --dict")
+	cmd.Flags().StringVar(&queryTime, "query-time", "", "RFC3339 query_time of the --prefixes snapshot (default: latest)")
+	cmd.Flags().BoolVar(&closest, "closest", false, "Use the --prefixes snapshot closest to each FIE's near_sent_timestamp")
+	cmd.Flags().StringVar(&resumeAfter, "resume-after", "", "Resume an interrupted annotation after this near_reply_address, prefixed with its pass and a slash with --closest")
+	return cmd
+}
+
+func runAnnotateFies(ctx context.Context, inputTable, outputTable, policy string, chunkSize int, dict, prefixes, queryTimeStr string, closest bool, resumeAfter string) error {
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+
+	cfg := service.FIEAnnotateConfig{
+		ChunkSize:         chunkSize,
+		PreparationPolicy: store.PreparationPolicy(policy),
+		Closest:           closest,
+		ResumeAfter:       resumeAfter,
+	}
+	if dict != "" {
+		cfg.Dict = &store.DatabaseTable{Database: config.Database, Table: dict}
+	}
+	if prefixes != "" {
+		cfg.Prefixes = &store.DatabaseTable{Database: config.Database, Table: prefixes}
+	}
+	if queryTimeStr != "" {
+		t, err := time.Parse(time.RFC3339, queryTimeStr)
+		if err != nil {
+			return fmt.Errorf("invalid --query-time %q: %w", queryTimeStr, err)
+		}
+		cfg.QueryTime = t
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	source := store.DatabaseTable{Database: config.Database, Table: inputTable}
+	dest := store.DatabaseTable{Database: config.Database, Table: outputTable}
+	if err := service.NewFIEAnnotateService(s, cfg).Annotate(ctx, source, dest); err != nil {
+		var interrupted *service.InterruptedError
+		if errors.As(err, &interrupted) {
+			return fmt.Errorf("%w; resume by re-running the command with --policy append --resume-after %s", err, interrupted.Resume)
+		}
+		return fmt.Errorf("failed to annotate fies: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
@@ -17,6 +18,7 @@ func computeResultsFiesCmd() *cobra.Command {
 		rttResolution float64
 		cardinality   string
 		nullity       string
+		resumeAfter   string
 	)
 	cmd := &cobra.Command{
 		Use:   "fies <input-table> <output-table>",
@@ -32,6 +34,7 @@ func computeResultsFiesCmd() *cobra.Command {
 				rttResolution,
 				cardinality,
 				nullity,
+				resumeAfter,
 			)
 		},
 	}
@@ -40,10 +43,11 @@ func computeResultsFiesCmd() *cobra.Command {
 	cmd.Flags().Float64Var(&rttResolution, "rtt-resolution", service.DefaultFIERTTResolution, "RTT resolution in milliseconds")
 	cmd.Flags().StringVar(&cardinality, "cardinality", string(service.CardinalityOneToOne), "Cardinality policy: one_to_one, many_to_one, one_to_many, all")
 	cmd.Flags().StringVar(&nullity, "nullity", string(service.NullityBothSome), "Nullity policy: both_some, far_none, any")
+	cmd.Flags().StringVar(&resumeAfter, "resume-after", "", "Resume an interrupted computation after this probe_dst_prefix")
 	return cmd
 }
 
-func runResultsFies(ctx context.Context, inputTable, outputTable, policy string, chunkSize int, rttResolution float64, cardinality, nullity string) error {
+func runResultsFies(ctx context.Context, inputTable, outputTable, policy string, chunkSize int, rttResolution float64, cardinality, nullity, resumeAfter string) error {
 	log := slog.Default()
 
 	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
@@ -71,6 +75,7 @@ func runResultsFies(ctx context.Context, inputTable, outputTable, policy string,
 		PreparationPolicy: store.PreparationPolicy(policy),
 		Cardinality:       service.CardinalityPolicy(cardinality),
 		Nullity:           service.NullityPolicy(nullity),
+		ResumeAfter:       resumeAfter,
 	})
 
 	log.InfoContext(ctx, "starting fie computation",
@@ -84,6 +89,10 @@ func runResultsFies(ctx context.Context, inputTable, outputTable, policy string,
 	)
 
 	if err := svc.Compute(ctx, source, dest); err != nil {
+		var interrupted *service.InterruptedError
+		if errors.As(err, &interrupted) {
+			return fmt.Errorf("%w; resume by re-running the command with --policy append --resume-after %s", err, interrupted.Resume)
+		}
 		return fmt.Errorf("failed to compute fies: %w", err)
 	}
 
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func computePrefixChangesCmd() *cobra.Command {
+	var policy string
+	cmd := &cobra.Command{
+		Use:   "prefix-changes <ripeprefixes-table> <output-table>",
+		Short: "Compute prefix announcements, withdrawals and origin changes between consecutive snapshots",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPrefixChanges(cmd.Context(), args[0], args[1], policy)
+		},
+	}
+	cmd.Flags().StringVar(&policy, "policy", string(service.DefaultPrefixChangesPreparationPolicy), "Write policy: replace, truncate, fail, append")
+	return cmd
+}
+
+func runPrefixChanges(ctx context.Context, inputTable, outputTable, policy string) error {
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	source := store.DatabaseTable{Database: config.Database, Table: inputTable}
+	dest := store.DatabaseTable{Database: config.Database, Table: outputTable}
+	svc := service.NewPrefixChangesService(s, service.PrefixChangesConfig{
+		PreparationPolicy: store.PreparationPolicy(policy),
+	})
+	if err := svc.Compute(ctx, source, dest); err != nil {
+		var interrupted *service.InterruptedError
+		if errors.As(err, &interrupted) {
+			return fmt.Errorf("%w; resume by re-running the command with --policy append", err)
+		}
+		return fmt.Errorf("failed to compute prefix changes: %w", err)
+	}
+	return nil
+}
+
+func computePrefixAnalysisCmd() *cobra.Command {
+	var (
+		policy     string
+		moas       string
+		hierarchy  string
+		queryTimes []string
+		all        bool
+	)
+	cmd := &cobra.Command{
+		Use:   "prefix-analysis <ripeprefixes-table>",
+		Short: "Compute MOAS prefixes and the prefix hierarchy of RIPE prefix snapshots",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if moas == "" && hierarchy == "" {
+				return fmt.Errorf("at least one of --moas and --hierarchy is required")
+			}
+			if len(queryTimes) > 0 && all {
+				return fmt.Errorf("--query-time and --all are mutually exclusive")
+			}
+			var times []time.Time
+			for _, s := range queryTimes {
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					return fmt.Errorf("invalid --query-time %q: %w", s, err)
+				}
+				times = append(times, t)
+			}
+			return runPrefixAnalysis(cmd.Context(), args[0], moas, hierarchy, policy, times, all)
+		},
+	}
+	cmd.Flags().StringVar(&moas, "moas", "", "Output table of MOAS prefixes and their origin sets")
+	cmd.Flags().StringVar(&hierarchy, "hierarchy", "", "Output table of prefixes with their parent prefix and depth")
+	cmd.Flags().StringSliceVar(&queryTimes, "query-time", nil, "RFC3339 query_time of a snapshot to analyze, may be repeated (default: latest)")
+	cmd.Flags().BoolVar(&all, "all", false, "Analyze all snapshots of the input table")
+	cmd.Flags().StringVar(&policy, "policy", string(service.DefaultPrefixAnalysisPreparationPolicy), "Write policy: replace, truncate, fail, append")
+	return cmd
+}
+
+func runPrefixAnalysis(ctx context.Context, inputTable, moasTable, hierarchyTable, policy string, queryTimes []time.Time, all bool) error {
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	cfg := service.PrefixAnalysisConfig{
+		PreparationPolicy: store.PreparationPolicy(policy),
+		QueryTimes:        queryTimes,
+		AllSnapshots:      all,
+	}
+	if moasTable != "" {
+		cfg.MOASTable = store.DatabaseTable{Database: config.Database, Table: moasTable}
+	}
+	if hierarchyTable != "" {
+		cfg.HierarchyTable = store.DatabaseTable{Database: config.Database, Table: hierarchyTable}
+	}
+	source := store.DatabaseTable{Database: config.Database, Table: inputTable}
+	if err := service.NewPrefixAnalysisService(s, cfg).Compute(ctx, source); err != nil {
+		var interrupted *service.InterruptedError
+		if errors.As(err, &interrupted) {
+			return fmt.Errorf("%w; resume by re-running the command with --policy append", err)
+		}
+		return fmt.Errorf("failed to compute prefix analysis: %w", err)
+	}
+	return nil
+}
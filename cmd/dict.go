@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func dictCmd() *cobra.Command {
+	var database string
+
+	dictCmd := &cobra.Command{
+		Use:   "dict",
+		Short: "Manage prefix-to-ASN IP_TRIE dictionaries",
+	}
+	dictCmd.PersistentFlags().StringVar(&database, "database", envOr("MPAT_DATABASE", store.DefaultDatabase), "ClickHouse database name")
+
+	dictCmd.AddCommand(dictCreateCmd(&database))
+	dictCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the dictionaries created by mp dict create",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDictList(cmd.Context(), database)
+		},
+	})
+	dictCmd.AddCommand(&cobra.Command{
+		Use:   "drop <name>",
+		Short: "Drop a dictionary",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			svc, err := newDictService()
+			if err != nil {
+				return err
+			}
+			return svc.Drop(cmd.Context(), store.DatabaseTable{Database: database, Table: args[0]})
+		},
+	})
+	dictCmd.AddCommand(&cobra.Command{
+		Use:   "reload <name>",
+		Short: "Reload a dictionary from its source table",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			svc, err := newDictService()
+			if err != nil {
+				return err
+			}
+			info, err := svc.Reload(cmd.Context(), store.DatabaseTable{Database: database, Table: args[0]})
+			if err != nil {
+				return err
+			}
+			printDictInfos([]service.DictInfo{info})
+			return nil
+		},
+	})
+	return dictCmd
+}
+
+func dictCreateCmd(database *string) *cobra.Command {
+	var (
+		from      string
+		queryTime string
+		replace   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: "Create an IP_TRIE dictionary mapping prefixes to origin ASNs from a ripeprefixes table",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := service.DictConfig{
+				Source:  store.DatabaseTable{Database: *database, Table: from},
+				Replace: replace,
+			}
+			if queryTime != "" {
+				t, err := time.Parse(time.RFC3339, queryTime)
+				if err != nil {
+					return fmt.Errorf("invalid --query-time %q: %w", queryTime, err)
+				}
+				cfg.QueryTime = t
+			}
+			svc, err := newDictService()
+			if err != nil {
+				return err
+			}
+			info, err := svc.Create(cmd.Context(), store.DatabaseTable{Database: *database, Table: args[0]}, cfg)
+			if err != nil {
+				return err
+			}
+			printDictInfos([]service.DictInfo{info})
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "Source ripeprefixes table (required)")
+	cmd.Flags().StringVar(&queryTime, "query-time", "", "RFC3339 query_time of the snapshot to load (default: latest snapshot at each load)")
+	cmd.Flags().BoolVar(&replace, "replace", false, "Replace an existing dictionary of the same name")
+	_ = cmd.MarkFlagRequired("from")
+
+	return cmd
+}
+
+func runDictList(ctx context.Context, database string) error {
+	svc, err := newDictService()
+	if err != nil {
+		return err
+	}
+	infos, err := svc.List(ctx, database)
+	if err != nil {
+		return err
+	}
+	printDictInfos(infos)
+	return nil
+}
+
+func newDictService() (*service.DictService, error) {
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+	return service.NewDictService(s), nil
+}
+
+func printDictInfos(infos []service.DictInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tSOURCE\tPREFIXES\tMEMORY\tLOADED\tERROR")
+	for _, d := range infos {
+		loaded := "-"
+		if !d.LastUpdate.IsZero() && d.LastUpdate.Unix() > 0 {
+			loaded = d.LastUpdate.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.Name, d.Status, d.Source, formatCount(int64(d.Elements)), formatBytes(d.Bytes), loaded, d.LastFailure)
+	}
+	_ = w.Flush()
+}
@@ -11,6 +11,9 @@ func fetchCmd() *cobra.Command {
 	}
 	fetchCmd.AddCommand(fetchIrisResultsCmd())
 	fetchCmd.AddCommand(fetchRipePrefixesCmd())
+	fetchCmd.AddCommand(fetchRipeOverviewCmd())
+	fetchCmd.AddCommand(fetchRipeNetworkInfoCmd())
+	fetchCmd.AddCommand(fetchRipeNeighboursCmd())
 	fetchCmd.AddCommand(fetchRetinaFIEsCmd())
 	return fetchCmd
 }
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"sort"
 	"time"
 
@@ -40,21 +43,27 @@ func (k MeasurementKind) ipVersion() uint8 {
 
 func fetchIrisResultsCmd() *cobra.Command {
 	var (
-		policy       string
-		tableFlag    string
-		measurement  string
-		from         string
-		to           string
-		date         string
-		kind         string
-		index        int
-		state        string
-		tag          string
-		chunkSize    int
-		ewmaAlpha    float64
-		lite         bool
-		database     string
-		filterSource bool
+		policy          string
+		tableFlag       string
+		measurement     string
+		from            string
+		to              string
+		date            string
+		kind            string
+		index           string
+		state           string
+		tag             string
+		chunkSize       int
+		ewmaAlpha       float64
+		lite            bool
+		database        string
+		filterSource    bool
+		provenance      bool
+		continueOnError bool
+		follow          bool
+		followInterval  time.Duration
+		computeFIEs     string
+		skipChunks      int64
 	)
 
 	cmd := &cobra.Command{
@@ -62,6 +71,11 @@ func fetchIrisResultsCmd() *cobra.Command {
 		Short: "Fetch iris results into a destination table",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// Finished measurements do not grow, so following them by
+			// default would only fetch them once.
+			if follow && !cmd.Flags().Changed("state") {
+				state = ""
+			}
 			return runFetchIrisResults(
 				cmd.Context(),
 				args[0],
@@ -80,6 +94,12 @@ func fetchIrisResultsCmd() *cobra.Command {
 				ewmaAlpha,
 				lite,
 				filterSource,
+				provenance,
+				continueOnError,
+				follow,
+				followInterval,
+				computeFIEs,
+				skipChunks,
 			)
 		},
 	}
@@ -90,20 +110,26 @@ func fetchIrisResultsCmd() *cobra.Command {
 	cmd.Flags().StringVar(&measurement, "measurement", "", "Measurement UUID (mode 2)")
 	cmd.Flags().StringVar(&from, "from", "", "Start date, RFC3339 (mode 3)")
 	cmd.Flags().StringVar(&to, "to", "", "End date, RFC3339 (mode 3)")
-	cmd.Flags().StringVar(&date, "date", "", "Date, YYYY-MM-DD (mode 4)")
-	cmd.Flags().StringVar(&kind, "kind", "", "Measurement kind: zeph, ipv6 (mode 4, required)")
-	cmd.Flags().IntVar(&index, "index", -1, "Index of the measurement to fetch, ordered by creation time (mode 4, required, 0-based)")
-	cmd.Flags().StringVar(&state, "state", "finished", "Measurement state filter (mode 3 and 4)")
+	cmd.Flags().StringVar(&date, "date", "", "Date YYYY-MM-DD or inclusive range YYYY-MM-DD..YYYY-MM-DD (mode 4)")
+	cmd.Flags().StringVar(&kind, "kind", "", "Comma-separated measurement kinds: zeph, ipv6 (mode 4, required)")
+	cmd.Flags().StringVar(&index, "index", "", "Indexes of the measurements to fetch, ordered by creation time among those in --state: 0, 0,2, 0-3 or all (mode 4, required, 0-based; matches {index} of mp watch with the default --state)")
+	cmd.Flags().StringVar(&state, "state", "finished", "Measurement state filter (mode 3 and 4, any state by default with --follow)")
 	cmd.Flags().StringVar(&tag, "tag", "", "Tag regex filter (mode 3)")
 	cmd.Flags().IntVar(&chunkSize, "chunk-size", service.DefaultFetchChunkSize, "Streaming chunk size")
 	cmd.Flags().Float64Var(&ewmaAlpha, "ewma-alpha", 0.2, "Alpha parameter for ETA estimation")
 	cmd.Flags().BoolVar(&lite, "lite", true, "Use ResultsLiteSchema instead of ResultsSchema")
 	cmd.Flags().BoolVar(&filterSource, "filter-source", true, "Exclude rows whose IP version does not match the kind (zeph → IPv4, ipv6 → IPv6).")
+	cmd.Flags().BoolVar(&provenance, "provenance", false, "Add measurement_uuid and agent_uuid columns identifying the source of each row")
+	cmd.Flags().BoolVar(&continueOnError, "continue-on-error", true, "In mode 4 with a table name template, keep fetching the remaining measurements after a failure; --continue-on-error=false stops at the first one")
+	cmd.Flags().BoolVar(&follow, "follow", false, "Poll ongoing measurements and append new rows until they finish (modes 2 to 4, implies --provenance)")
+	cmd.Flags().DurationVar(&followInterval, "follow-interval", service.DefaultFollowInterval, "Polling interval for --follow")
+	cmd.Flags().StringVar(&computeFIEs, "compute-fies", "", "With --follow, compute the FIEs of the prefixes that received rows into this table once all measurements finished")
+	cmd.Flags().Int64Var(&skipChunks, "skip-chunks", 0, "Skip the first chunks, e.g. those committed by an interrupted fetch (single destination only)")
 
 	return cmd
 }
 
-func runFetchIrisResults(ctx context.Context, destTable, database, policy, tableFlag, measurement, fromStr, toStr, dateStr, kindStr string, index int, stateStr, tagPattern string, chunkSize int, ewmaAlpha float64, lite bool, filterSource bool) error {
+func runFetchIrisResults(ctx context.Context, destTable, database, policy, tableFlag, measurement, fromStr, toStr, dateStr, kindStr, indexStr, stateStr, tagPattern string, chunkSize int, ewmaAlpha float64, lite bool, filterSource bool, provenance bool, continueOnError bool, follow bool, followInterval time.Duration, computeFIEs string, skipChunks int64) error {
 	modes := 0
 	if tableFlag != "" {
 		modes++
@@ -123,62 +149,91 @@ func runFetchIrisResults(ctx context.Context, destTable, database, policy, table
 	if (fromStr == "") != (toStr == "") {
 		return fmt.Errorf("--from and --to must be set together")
 	}
+	if dateStr == "" && isTableTemplate(destTable) {
+		return fmt.Errorf("table name templates are only supported with --date")
+	}
+	if follow {
+		if tableFlag != "" {
+			return fmt.Errorf("--follow is not supported with --table")
+		}
+		if isTableTemplate(destTable) {
+			return fmt.Errorf("--follow is not supported with table name templates")
+		}
+		if state := iris.MeasurementAgentState(stateStr); state.IsTerminal() {
+			return fmt.Errorf("--follow is not supported with --state %s: such measurements no longer receive results", state)
+		}
+		// Watermarks are tracked per source using the provenance columns.
+		provenance = true
+	} else if computeFIEs != "" {
+		return fmt.Errorf("--compute-fies requires --follow")
+	}
+	if skipChunks > 0 && (follow || isTableTemplate(destTable)) {
+		return fmt.Errorf("--skip-chunks requires a single destination table and is not supported with --follow")
+	}
 
 	// Mode 4 requires --kind and --index.
+	var (
+		dates []time.Time
+		kinds []MeasurementKind
+		sel   indexSelector
+	)
 	if dateStr != "" {
 		if kindStr == "" {
 			return fmt.Errorf("--kind is required when --date is set")
 		}
-		if index < 0 {
+		if indexStr == "" {
 			return fmt.Errorf("--index is required when --date is set")
 		}
-		k := MeasurementKind(kindStr)
-		if !k.isValid() {
-			return fmt.Errorf("invalid --kind value %q: must be one of zeph, ipv6", kindStr)
+		var err error
+		if dates, err = parseDateRange(dateStr); err != nil {
+			return fmt.Errorf("invalid --date value: %w", err)
+		}
+		if kinds, err = parseKinds(kindStr); err != nil {
+			return fmt.Errorf("invalid --kind value: %w", err)
+		}
+		if sel, err = parseIndexSelector(indexStr); err != nil {
+			return fmt.Errorf("invalid --index value: %w", err)
+		}
+		single := len(dates) == 1 && len(kinds) == 1 && !sel.all && len(sel.indexes) == 1
+		if !single && !isTableTemplate(destTable) {
+			return fmt.Errorf("--date, --kind and --index select several measurements; the destination must be a table name template such as %q", "iris_{kind}_{index}__{schema}__{date}")
 		}
 	}
 
-	irisClient, err := iris.NewIrisClient(iris.Config{
-		Username: mustEnv("IRIS_USERNAME"),
-		Password: mustEnv("IRIS_PASSWORD"),
-		Endpoint: envOr("IRIS_ENDPOINT", iris.DefaultEndpoint),
-	})
+	irisClient, err := newIrisClientFromEnv()
 	if err != nil {
-		return fmt.Errorf("failed to create iris client: %w", err)
+		return err
 	}
 	defer func() { _ = irisClient.Logout() }()
 
-	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
-	if err != nil {
-		return fmt.Errorf("failed to parse config from DSN: %w", err)
-	}
-
-	s, err := store.NewStore(config)
-	if err != nil {
-		return fmt.Errorf("failed to create store: %w", err)
-	}
-
-	var sourceNames []string
+	var (
+		sources      []iris.IrisTable
+		measurements []string      // UUIDs, used by --follow
+		jobs         []dateKindJob // mode 4
+	)
 	switch {
 	case tableFlag != "":
-		sourceNames = []string{tableFlag}
+		t, err := iris.ParseTableName(tableFlag)
+		if err != nil {
+			if provenance {
+				return fmt.Errorf("cannot derive provenance from --table: %w", err)
+			}
+			t = iris.IrisTable{Kind: iris.TableKindResults, TableName: tableFlag}
+		}
+		sources = []iris.IrisTable{t}
 
 	case measurement != "":
-		measurements, err := irisClient.Measurements().Fetch()
+		m, err := irisClient.Measurement(measurement)
 		if err != nil {
-			return fmt.Errorf("failed to fetch measurements: %w", err)
+			return err
 		}
-		for _, m := range measurements {
-			if m.UUID == measurement {
-				for _, g := range iris.TableGroupsForMeasurement(m) {
-					sourceNames = append(sourceNames, g.Results.TableName)
-				}
-				break
-			}
+		for _, g := range m.TableGroups() {
+			sources = append(sources, g.Results)
 		}
-		if len(sourceNames) == 0 {
+		if len(sources) == 0 {
 			return fmt.Errorf("no results tables found for measurement %s", measurement)
 		}
+		measurements = []string{measurement}
 
 	case fromStr != "":
 		from, err := time.Parse(time.RFC3339, fromStr)
@@ -196,67 +251,298 @@ func runFetchIrisResults(ctx context.Context, destTable, database, policy, table
 		if tagPattern != "" {
 			q = q.TagContains(tagPattern)
 		}
-		measurements, err := q.Fetch()
+		found, err := q.Fetch()
 		if err != nil {
 			return fmt.Errorf("failed to fetch measurements: %w", err)
 		}
-		for _, m := range measurements {
+		for _, m := range found {
 			for _, g := range iris.TableGroupsForMeasurement(m) {
-				sourceNames = append(sourceNames, g.Results.TableName)
+				sources = append(sources, g.Results)
 			}
+			measurements = append(measurements, m.UUID)
 		}
-		if len(sourceNames) == 0 {
+		if len(sources) == 0 {
 			return fmt.Errorf("no results tables found in range %s to %s", fromStr, toStr)
 		}
 
 	case dateStr != "":
-		k := MeasurementKind(kindStr)
-		date, err := time.Parse("2006-01-02", dateStr)
-		if err != nil {
-			return fmt.Errorf("invalid --date value %q: must be YYYY-MM-DD", dateStr)
+		if jobs, err = resolveDateKindJobs(irisClient, dates, kinds, sel, stateStr, destTable, database, lite); err != nil {
+			return err
+		}
+		// A single selection that cannot be resolved, e.g. an index out of
+		// range, fails before connecting to ClickHouse.
+		if !isTableTemplate(destTable) && jobs[0].err != nil {
+			return jobs[0].err
 		}
-		start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
-		end := time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 59, 0, time.UTC)
+	}
+
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	newService := func(ipVersion uint8) *service.FetchService {
+		return service.NewFetchService(s, irisClient, service.FetchConfig{
+			ChunkSize:         chunkSize,
+			PreparationPolicy: store.PreparationPolicy(policy),
+			Lite:              lite,
+			EWMAAlpha:         ewmaAlpha,
+			IPVersion:         ipVersion,
+			Provenance:        provenance,
+			SkipChunks:        skipChunks,
+		})
+	}
+
+	if dateStr != "" {
+		if follow {
+			var ipVersion uint8
+			if filterSource {
+				ipVersion = jobs[0].kind.ipVersion()
+			}
+			return runFollow(ctx, s, newService(ipVersion), []string{jobs[0].measurementUUID}, jobs[0].dest, followInterval, computeFIEs)
+		}
+		return runDateKindJobs(ctx, jobs, isTableTemplate(destTable), continueOnError, func(j dateKindJob) error {
+			var ipVersion uint8
+			if filterSource {
+				ipVersion = j.kind.ipVersion()
+			}
+			err := newService(ipVersion).Fetch(ctx, j.sources, j.dest)
+			if !isTableTemplate(destTable) {
+				return withSkipChunksHint(err, "the command")
+			}
+			// --skip-chunks is rejected with a template, so the interrupted
+			// job is resumed on its own, into its concrete table.
+			return withSkipChunksHint(err, fmt.Sprintf("mp fetch iris-results %s --date %s --kind %s --index %d, with the other flags unchanged,",
+				j.dest.Table, j.date.Format(dateLayout), j.kind, j.index))
+		})
+	}
+
+	dest := store.DatabaseTable{
+		Database: database,
+		Table:    destTable,
+	}
+
+	if follow {
+		return runFollow(ctx, s, newService(0), measurements, dest, followInterval, computeFIEs)
+	}
+	return withSkipChunksHint(newService(0).Fetch(ctx, sources, dest), "the command")
+}
+
+// withSkipChunksHint adds to an interrupted fetch error how to resume it by
+// re-running command. Chunks are counted in rows of the IP version fetched,
+// so skipping them only resumes at the right row with the same chunk size
+// and source filter.
+func withSkipChunksHint(err error, command string) error {
+	var interrupted *service.InterruptedError
+	if !errors.As(err, &interrupted) {
+		return err
+	}
+	return fmt.Errorf("%w; resume by re-running %s with --policy append --skip-chunks %s (only valid with the same --chunk-size and --filter-source)",
+		err, command, interrupted.Resume)
+}
+
+// runFollow follows the measurements until they finish and, if computeFIEs
+// is set, computes the FIEs of the prefixes that received rows meanwhile.
+func runFollow(ctx context.Context, s *store.Store, svc *service.FetchService, measurements []string, dest store.DatabaseTable, interval time.Duration, computeFIEs string) error {
+	result, err := svc.Follow(ctx, measurements, dest, interval)
+	if err != nil {
+		return err
+	}
+	if computeFIEs == "" || result.Rows == 0 {
+		return nil
+	}
+
+	config := service.DefaultFIEComputeConfig()
+	config.PreparationPolicy = store.PreparationPolicyAppend
+	config.PrefixFilter = result.NewPrefixes
+	fieDest := store.DatabaseTable{Database: dest.Database, Table: computeFIEs}
+	if err := service.NewFIEComputeService(s, config).Compute(ctx, dest, fieDest); err != nil {
+		return fmt.Errorf("failed to compute fies: %w", err)
+	}
+	return nil
+}
+
+// dateKindJob is a single (date, kind, index) selection of mode 4.
+type dateKindJob struct {
+	date            time.Time
+	kind            MeasurementKind
+	index           int
+	measurementUUID string
+	sources         []iris.IrisTable
+	dest            store.DatabaseTable
+	vars            map[string]string // table name template variables
+	err             error             // set when the selection cannot be resolved, e.g. index out of range
+}
+
+// dateKindVars returns the table name template variables of a (date, kind,
+// index) selection.
+func dateKindVars(date time.Time, k MeasurementKind, index string, lite bool) map[string]string {
+	schemaName := "results"
+	if lite {
+		schemaName = "resultslite"
+	}
+	return map[string]string{
+		"kind":   string(k),
+		"index":  index,
+		"schema": schemaName,
+		"date":   date.Format("20060102"),
+	}
+}
+
+// resolveDateKindJobs lists the measurements of each kind over the date range,
+// orders each day's measurements by creation time and resolves the index
+// selector against them. One job is returned per (date, kind, index).
+func resolveDateKindJobs(irisClient *iris.IrisClient, dates []time.Time, kinds []MeasurementKind, sel indexSelector, stateStr, destTable, database string, lite bool) ([]dateKindJob, error) {
+	first, last := dates[0], dates[len(dates)-1]
+	start := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(last.Year(), last.Month(), last.Day(), 23, 59, 59, 0, time.UTC)
+
+	var jobs []dateKindJob
+	for _, k := range kinds {
 		q := irisClient.Measurements().Between(start, end)
 		if stateStr != "" {
 			q = q.State(iris.MeasurementAgentState(stateStr))
 		}
-		q = q.TagContains(k.tag())
+		q = q.Tag(k.tag())
 		measurements, err := q.Fetch()
 		if err != nil {
-			return fmt.Errorf("failed to fetch measurements: %w", err)
+			return nil, fmt.Errorf("failed to fetch measurements: %w", err)
 		}
-		sort.Slice(measurements, func(i, j int) bool {
-			return measurements[i].CreationTime.Before(measurements[j].CreationTime.Time)
-		})
-		if index >= len(measurements) {
-			return fmt.Errorf("--index %d is out of range: only %d measurement(s) found for date %s and kind %s", index, len(measurements), dateStr, kindStr)
-		}
-		for _, g := range iris.TableGroupsForMeasurement(measurements[index]) {
-			sourceNames = append(sourceNames, g.Results.TableName)
+		byDay := dayCandidates(measurements, stateStr)
+
+		for _, date := range dates {
+			day := date.Format(dateLayout)
+			candidates := byDay[day]
+			for _, index := range sel.resolve(len(candidates)) {
+				vars := dateKindVars(date, k, fmt.Sprintf("%d", index), lite)
+				table, err := renderTableName(destTable, vars)
+				if err != nil {
+					return nil, err
+				}
+				job := dateKindJob{
+					date:  date,
+					kind:  k,
+					index: index,
+					dest:  store.DatabaseTable{Database: database, Table: table},
+					vars:  vars,
+				}
+				switch {
+				case index >= len(candidates):
+					job.err = fmt.Errorf("--index %d is out of range: only %d measurement(s) found for date %s and kind %s", index, len(candidates), day, k)
+				default:
+					job.measurementUUID = candidates[index].UUID
+					// Indexes depend on which measurements are counted, so the
+					// selected measurement is logged before anything is fetched.
+					slog.Info("resolved measurement",
+						"date", day,
+						"kind", k,
+						"index", index,
+						"measurement", job.measurementUUID,
+						"dest", fmt.Sprintf("%s.%s", job.dest.Database, job.dest.Table),
+					)
+					for _, g := range iris.TableGroupsForMeasurement(candidates[index]) {
+						job.sources = append(job.sources, g.Results)
+					}
+					if len(job.sources) == 0 {
+						job.err = fmt.Errorf("no results tables found for date %s, kind %s, index %d", day, k, index)
+					}
+				}
+				jobs = append(jobs, job)
+			}
 		}
-		if len(sourceNames) == 0 {
-			return fmt.Errorf("no results tables found for date %s, kind %s, index %d", dateStr, kindStr, index)
+	}
+
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no measurements found for --date %s..%s", first.Format(dateLayout), last.Format(dateLayout))
+	}
+	return jobs, nil
+}
+
+// dayCandidates groups measurements by UTC creation day, keeping those in
+// stateStr (any state if empty), and orders each day by creation time. The
+// position of a measurement in its day is its index: --index of mode 4 and
+// mp batch run, and {index} of mp watch.
+func dayCandidates(measurements []iris.MeasurementRead, stateStr string) map[string][]iris.MeasurementRead {
+	sorted := slices.Clone(measurements)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreationTime.Before(sorted[j].CreationTime.Time)
+	})
+	byDay := make(map[string][]iris.MeasurementRead)
+	for _, m := range sorted {
+		if stateStr != "" && m.State != iris.MeasurementAgentState(stateStr) {
+			continue
 		}
+		day := m.CreationTime.UTC().Format(dateLayout)
+		byDay[day] = append(byDay[day], m)
 	}
+	return byDay
+}
 
-	dest := store.DatabaseTable{
-		Database: database,
-		Table:    destTable,
+// runDateKindJobs runs fetch for every job. Without fan-out there is a single
+// job and its error is returned as-is. With fan-out, failures are logged and,
+// if continueOnError is set, the remaining jobs still run; a summary is logged
+// at the end and an error is returned if any job failed.
+func runDateKindJobs(ctx context.Context, jobs []dateKindJob, fanOut, continueOnError bool, fetch func(dateKindJob) error) error {
+	if !fanOut {
+		if jobs[0].err != nil {
+			return jobs[0].err
+		}
+		return fetch(jobs[0])
 	}
 
-	var ipVersion uint8
-	if filterSource && dateStr != "" {
-		ipVersion = MeasurementKind(kindStr).ipVersion()
+	log := slog.Default()
+	start := time.Now()
+	var succeeded, failed, skipped []string
+
+	for i, j := range jobs {
+		qualified := fmt.Sprintf("%s.%s", j.dest.Database, j.dest.Table)
+		log.InfoContext(ctx, "fetching measurement",
+			"progress", fmt.Sprintf("%d/%d", i+1, len(jobs)),
+			"date", j.date.Format(dateLayout),
+			"kind", j.kind,
+			"index", j.index,
+			"dest", qualified,
+		)
+
+		err := j.err
+		if err == nil {
+			err = fetch(j)
+		}
+		if err != nil {
+			log.ErrorContext(ctx, "fetch failed", "dest", qualified, "error", err)
+			failed = append(failed, qualified)
+			if !continueOnError || ctx.Err() != nil {
+				for _, rest := range jobs[i+1:] {
+					skipped = append(skipped, fmt.Sprintf("%s.%s", rest.dest.Database, rest.dest.Table))
+				}
+				break
+			}
+			continue
+		}
+		succeeded = append(succeeded, qualified)
 	}
 
-	svc := service.NewFetchService(s, irisClient, service.FetchConfig{
-		ChunkSize:         chunkSize,
-		PreparationPolicy: store.PreparationPolicy(policy),
-		Lite:              lite,
-		EWMAAlpha:         ewmaAlpha,
-		IPVersion:         ipVersion,
-	})
+	log.InfoContext(ctx, "fetch summary",
+		"jobs", len(jobs),
+		"succeeded", len(succeeded),
+		"failed", len(failed),
+		"skipped", len(skipped),
+		"elapsed", time.Since(start).Round(time.Second),
+	)
+	for _, t := range failed {
+		log.InfoContext(ctx, "failed", "dest", t)
+	}
+	for _, t := range skipped {
+		log.InfoContext(ctx, "skipped", "dest", t)
+	}
 
-	return svc.Fetch(ctx, sourceNames, dest)
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d fetch(es) failed", len(failed), len(jobs))
+	}
+	return nil
 }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/iris/iristest"
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/dioptra-io/ufuk-research/internal/store/storetest"
+)
+
+const testAgent = "ffffffff-0000-0000-0000-000000000001"
+
+func testMeasurementUUID(i int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+}
+
+// newTestIris starts an Iris stand-in with, on 2026-06-01, three zeph
+// measurements created at 08:00 (finished), 09:00 (ongoing) and 10:00
+// (finished), one ipv6 measurement and one zeph-test measurement, and
+// points the IRIS_* environment variables at it. MPAT_CLICKHOUSE points at
+// a closed port, so that commands fail rather than exit if they connect.
+func newTestIris(t *testing.T) *iristest.Server {
+	t.Helper()
+	srv := iristest.NewServer()
+	t.Cleanup(srv.Close)
+	day := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, m := range []struct {
+		hour  int
+		state iris.MeasurementAgentState
+		tag   string
+	}{
+		{10, iris.StateFinished, "zeph"},
+		{8, iris.StateFinished, "zeph"},
+		{9, iris.StateOngoing, "zeph"},
+		{11, iris.StateFinished, "ipv6"},
+		{7, iris.StateFinished, "zeph-test"},
+	} {
+		srv.AddMeasurement(iristest.Measurement(testMeasurementUUID(i), day.Add(time.Duration(m.hour)*time.Hour), m.state, []string{m.tag}, testAgent))
+	}
+
+	cfg := srv.Config()
+	t.Setenv("IRIS_USERNAME", cfg.Username)
+	t.Setenv("IRIS_PASSWORD", cfg.Password)
+	t.Setenv("IRIS_ENDPOINT", cfg.Endpoint)
+	t.Setenv("IRIS_CACHE_TTL", "")
+	t.Setenv("MPAT_CLICKHOUSE", "clickhouse://127.0.0.1:1/default")
+	return srv
+}
+
+// runFetchIris runs fetch iris-results with args.
+func runFetchIris(args ...string) error {
+	cmd := fetchIrisResultsCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	return cmd.ExecuteContext(context.Background())
+}
+
+func TestFetchIrisResultsFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no mode", []string{"dest"}, "exactly one of"},
+		{"two modes", []string{"dest", "--table", "t", "--measurement", "m"}, "exactly one of"},
+		{"from without to", []string{"dest", "--from", "2026-06-01T00:00:00Z"}, "must be set together"},
+		{"template without date", []string{"iris_{kind}", "--measurement", "m"}, "only supported with --date"},
+		{"date without kind", []string{"dest", "--date", "2026-06-01", "--index", "0"}, "--kind is required"},
+		{"date without index", []string{"dest", "--date", "2026-06-01", "--kind", "zeph"}, "--index is required"},
+		{"several without template", []string{"dest", "--date", "2026-06-01", "--kind", "zeph", "--index", "0,1"}, "table name template"},
+		{"follow table", []string{"dest", "--table", "t", "--follow"}, "not supported with --table"},
+		{"follow finished", []string{"dest", "--measurement", "m", "--follow", "--state", "finished"}, "not supported with --state finished"},
+		{"compute-fies without follow", []string{"dest", "--measurement", "m", "--compute-fies", "fies"}, "requires --follow"},
+		{"skip-chunks template", []string{"iris_{index}", "--date", "2026-06-01", "--kind", "zeph", "--index", "all", "--skip-chunks", "2"}, "--skip-chunks requires a single destination"},
+		{"skip-chunks follow", []string{"dest", "--measurement", "m", "--follow", "--skip-chunks", "2"}, "--skip-chunks requires a single destination"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runFetchIris(tt.args...); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchIrisResultsIndexOutOfRange(t *testing.T) {
+	newTestIris(t)
+
+	// Two zeph measurements finished on that day: index 2 does not exist.
+	err := runFetchIris("dest", "--date", "2026-06-01", "--kind", "zeph", "--index", "2")
+	if err == nil || !strings.Contains(err.Error(), "--index 2 is out of range: only 2 measurement(s) found") {
+		t.Errorf("error = %v, want an out of range index", err)
+	}
+
+	err = runFetchIris("dest", "--date", "2026-06-02", "--kind", "zeph", "--index", "0")
+	if err == nil || !strings.Contains(err.Error(), "out of range: only 0 measurement(s)") {
+		t.Errorf("error = %v, want an out of range index on a day without measurements", err)
+	}
+}
+
+func TestResolveDateKindJobs(t *testing.T) {
+	srv := newTestIris(t)
+	client, err := iris.NewIrisClient(srv.Config())
+	if err != nil {
+		t.Fatalf("NewIrisClient: %v", err)
+	}
+	dates, err := parseDateRange("2026-06-01..2026-06-02")
+	if err != nil {
+		t.Fatalf("parseDateRange: %v", err)
+	}
+	all, _ := parseIndexSelector("all")
+	first3, _ := parseIndexSelector("0-2")
+
+	type job struct {
+		table string
+		uuid  string
+		err   string
+	}
+	tests := []struct {
+		name  string
+		kinds []MeasurementKind
+		sel   indexSelector
+		state string
+		want  []job
+	}{
+		{"finished by creation time", []MeasurementKind{KindZeph}, all, "finished", []job{
+			{"iris_zeph_0_20260601", testMeasurementUUID(1), ""},
+			{"iris_zeph_1_20260601", testMeasurementUUID(0), ""},
+		}},
+		{"any state", []MeasurementKind{KindZeph}, all, "", []job{
+			{"iris_zeph_0_20260601", testMeasurementUUID(1), ""},
+			{"iris_zeph_1_20260601", testMeasurementUUID(2), ""},
+			{"iris_zeph_2_20260601", testMeasurementUUID(0), ""},
+		}},
+		{"out of range", []MeasurementKind{KindZeph, KindIPv6}, first3, "finished", []job{
+			{"iris_zeph_0_20260601", testMeasurementUUID(1), ""},
+			{"iris_zeph_1_20260601", testMeasurementUUID(0), ""},
+			{"iris_zeph_2_20260601", "", "--index 2 is out of range: only 2 measurement(s) found for date 2026-06-01 and kind zeph"},
+			{"iris_zeph_0_20260602", "", "--index 0 is out of range: only 0 measurement(s) found for date 2026-06-02 and kind zeph"},
+			{"iris_zeph_1_20260602", "", "--index 1 is out of range"},
+			{"iris_zeph_2_20260602", "", "--index 2 is out of range"},
+			{"iris_ipv6_0_20260601", testMeasurementUUID(3), ""},
+			{"iris_ipv6_1_20260601", "", "--index 1 is out of range: only 1 measurement(s) found for date 2026-06-01 and kind ipv6"},
+			{"iris_ipv6_2_20260601", "", "--index 2 is out of range"},
+			{"iris_ipv6_0_20260602", "", "--index 0 is out of range"},
+			{"iris_ipv6_1_20260602", "", "--index 1 is out of range"},
+			{"iris_ipv6_2_20260602", "", "--index 2 is out of range"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := resolveDateKindJobs(client, dates, tt.kinds, tt.sel, tt.state, "iris_{kind}_{index}_{date}", "db", true)
+			if err != nil {
+				t.Fatalf("resolveDateKindJobs: %v", err)
+			}
+			if len(jobs) != len(tt.want) {
+				t.Fatalf("got %d jobs, want %d", len(jobs), len(tt.want))
+			}
+			for i, j := range jobs {
+				want := tt.want[i]
+				if j.dest.Database != "db" || j.dest.Table != want.table || j.measurementUUID != want.uuid {
+					t.Errorf("job %d = %s.%s for %q, want db.%s for %q", i, j.dest.Database, j.dest.Table, j.measurementUUID, want.table, want.uuid)
+				}
+				if want.err == "" {
+					if j.err != nil || len(j.sources) != 1 || j.sources[0].AgentUUID != testAgent {
+						t.Errorf("job %d: err = %v, sources = %v; want the results table of agent %s", i, j.err, j.sources, testAgent)
+					}
+				} else if j.err == nil || !strings.Contains(j.err.Error(), want.err) {
+					t.Errorf("job %d: err = %v, want %q", i, j.err, want.err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunDateKindJobs(t *testing.T) {
+	jobs := []dateKindJob{
+		{dest: store.DatabaseTable{Database: "db", Table: "a"}},
+		{dest: store.DatabaseTable{Database: "db", Table: "b"}, err: errors.New("out of range")},
+		{dest: store.DatabaseTable{Database: "db", Table: "c"}},
+	}
+	errFetch := errors.New("fetch failed")
+
+	tests := []struct {
+		name            string
+		jobs            []dateKindJob
+		fanOut          bool
+		continueOnError bool
+		failOn          string
+		wantFetched     []string
+		wantErr         string
+	}{
+		{"single", jobs[:1], false, false, "", []string{"a"}, ""},
+		{"single error", jobs[:1], false, false, "a", []string{"a"}, errFetch.Error()},
+		{"single unresolved", jobs[1:2], false, false, "", nil, "out of range"},
+		{"stop on unresolved", jobs, true, false, "", []string{"a"}, "1 of 3 fetch(es) failed"},
+		{"continue on unresolved", jobs, true, true, "", []string{"a", "c"}, "1 of 3 fetch(es) failed"},
+		{"continue on error", jobs, true, true, "a", []string{"a", "c"}, "2 of 3 fetch(es) failed"},
+		{"all succeed", []dateKindJob{jobs[0], jobs[2]}, true, false, "", []string{"a", "c"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched []string
+			err := runDateKindJobs(context.Background(), tt.jobs, tt.fanOut, tt.continueOnError, func(j dateKindJob) error {
+				fetched = append(fetched, j.dest.Table)
+				if j.dest.Table == tt.failOn {
+					return errFetch
+				}
+				return nil
+			})
+			if strings.Join(fetched, ",") != strings.Join(tt.wantFetched, ",") {
+				t.Errorf("fetched %v, want %v", fetched, tt.wantFetched)
+			}
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithSkipChunksHint(t *testing.T) {
+	if err := withSkipChunksHint(nil, "the command"); err != nil {
+		t.Errorf("withSkipChunksHint(nil) = %v, want nil", err)
+	}
+	other := errors.New("other")
+	if err := withSkipChunksHint(other, "the command"); err != other {
+		t.Errorf("withSkipChunksHint(other) = %v, want it unchanged", err)
+	}
+
+	interrupted := &service.InterruptedError{Op: "fetch", Chunks: 3, Resume: "3", Err: context.Canceled}
+	err := withSkipChunksHint(fmt.Errorf("wrapped: %w", interrupted), "the command")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v does not wrap the interruption", err)
+	}
+	for _, want := range []string{"re-running the command with --policy append --skip-chunks 3", "--chunk-size", "--filter-source"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
+
+// TestFetchIrisResultsModes fetches into the storetest ClickHouse server.
+func TestFetchIrisResultsModes(t *testing.T) {
+	s := storetest.Open(t)
+	srv := newTestIris(t)
+	t.Setenv("MPAT_CLICKHOUSE", storetest.DSN(t))
+	// Each zeph measurement has 3 IPv4 rows and 1 IPv6 row.
+	for i := range 3 {
+		srv.AddResults(testMeasurementUUID(i), testAgent, iristest.ResultRows(3, "::ffff:192.0.2.1")...)
+		srv.AddResults(testMeasurementUUID(i), testAgent, iristest.ResultRows(1, "2001:db8::1")...)
+	}
+	table := iris.NewIrisTableGroup(testMeasurementUUID(0), testAgent, iris.IrisTime{}).Results.TableName
+
+	tests := []struct {
+		name string
+		args []string
+		want uint64
+	}{
+		{"table", []string{"--table", table}, 4},
+		{"measurement", []string{"--measurement", testMeasurementUUID(0)}, 4},
+		{"range", []string{"--from", "2026-06-01T00:00:00Z", "--to", "2026-06-02T00:00:00Z", "--tag", "^zeph$"}, 8},
+		{"range any state", []string{"--from", "2026-06-01T00:00:00Z", "--to", "2026-06-02T00:00:00Z", "--tag", "^zeph$", "--state", ""}, 12},
+		{"date", []string{"--date", "2026-06-01", "--kind", "zeph", "--index", "1"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := storetest.Table(t, s)
+			args := append([]string{dest.Table, "--database", dest.Database, "--chunk-size", "2"}, tt.args...)
+			if err := runFetchIris(args...); err != nil {
+				t.Fatalf("fetch iris-results: %v", err)
+			}
+			if n := storetest.RowCount(t, s, dest, ""); n != tt.want {
+				t.Errorf("fetched %d rows, want %d", n, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	api "github.com/dioptra-io/retina-commons/api/v1"
 	"github.com/dioptra-io/ufuk-research/internal/retina"
 	"github.com/dioptra-io/ufuk-research/internal/service"
 	"github.com/dioptra-io/ufuk-research/internal/store"
@@ -13,10 +23,16 @@ import (
 
 func fetchRetinaFIEsCmd() *cobra.Command {
 	var (
-		policy    string
-		timeout   time.Duration
-		endpoint  string
-		batchSize int
+		policy       string
+		timeout      time.Duration
+		resume       bool
+		dedup        bool
+		queueSize    int
+		clientConfig retina.Config
+		filterFlags  retinaFilterFlags
+		record       string
+		fromFile     string
+		replaySpeed  float64
 	)
 
 	cmd := &cobra.Command{
@@ -24,33 +40,146 @@ func fetchRetinaFIEsCmd() *cobra.Command {
 		Short: "Fetch Retina live stream FIEs into a destination table",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := filterFlags.filter()
+			if err != nil {
+				return err
+			}
 			return runFetchRetinaFIEs(
 				cmd.Context(),
 				args[0],
-				policy,
 				timeout,
-				endpoint,
-				batchSize,
+				clientConfig,
+				service.RetinaConfig{
+					PreparationPolicy: store.PreparationPolicy(policy),
+					Resume:            resume,
+					Deduplicate:       dedup,
+					QueueSize:         queueSize,
+					Filter:            filter,
+				},
+				filterFlags.destinationsTable,
+				record,
+				fromFile,
+				replaySpeed,
 			)
 		},
 	}
 
 	cmd.Flags().StringVar(&policy, "policy", string(store.PreparationPolicyFail), "Write policy: replace, truncate, fail, append")
 	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Stream timeout; 0 means no timeout")
-	cmd.Flags().StringVar(&endpoint, "endpoint", retina.DefaultEndpoint, "Retina stream endpoint URL")
-	cmd.Flags().IntVar(&batchSize, "batch-size", retina.DefaultBatchSize, "Number of FIEs to accumulate per insert batch")
+	cmd.Flags().StringVar(&clientConfig.Endpoint, "endpoint", retina.DefaultEndpoint, "Retina stream endpoint URL")
+	cmd.Flags().IntVar(&clientConfig.BatchSize, "batch-size", retina.DefaultBatchSize, "Number of FIEs to accumulate per insert batch")
+	cmd.Flags().DurationVar(&clientConfig.FlushInterval, "flush-interval", retina.DefaultFlushInterval, "Insert a partial batch this long after its first FIE (negative flushes only full batches)")
+	cmd.Flags().IntVar(&queueSize, "queue-size", service.DefaultRetinaQueueSize, "Number of batches buffered between the stream and the inserts")
+	cmd.Flags().IntVar(&clientConfig.MaxReconnects, "max-reconnects", retina.DefaultMaxReconnects, "Consecutive reconnection attempts before giving up (negative disables reconnection)")
+	cmd.Flags().DurationVar(&clientConfig.ReconnectDelay, "reconnect-delay", retina.DefaultReconnectDelay, "Initial delay between reconnection attempts, doubled on each attempt")
+	cmd.Flags().DurationVar(&clientConfig.MaxReconnectDelay, "max-reconnect-delay", retina.DefaultMaxReconnectDelay, "Maximum delay between reconnection attempts")
+	cmd.Flags().DurationVar(&clientConfig.IdleTimeout, "idle-timeout", retina.DefaultIdleTimeout, "Reconnect when no data is received for this long (negative disables it)")
+	cmd.Flags().StringVar(&clientConfig.ResumeParam, "resume-param", retina.DefaultResumeParam, "Query parameter carrying the next sequence number when resuming")
+	cmd.Flags().BoolVar(&resume, "resume", true, "With --policy append, resume after the highest sequence_number already stored")
+	cmd.Flags().BoolVar(&dedup, "dedup", false, "Create a ReplacingMergeTree table keyed on sequence_number and always skip the FIEs already stored, so re-running never duplicates rows")
+
+	filterFlags.register(cmd)
+	cmd.Flags().StringVar(&record, "record", "", "Also append the raw NDJSON stream to this file, gzip-compressed if it ends in .gz")
+	cmd.Flags().StringVar(&fromFile, "from-file", "", "Replay a stream recorded with --record, optionally gzip-compressed, instead of connecting to Retina")
+	cmd.Flags().Float64Var(&replaySpeed, "replay-speed", 0, "With --from-file, pace FIEs by production_timestamp at this speed, e.g. 1 for real time (default: as fast as possible)")
 
 	return cmd
 }
 
+// retinaFilterFlags holds the FIE filter flags of retina-fies.
+type retinaFilterFlags struct {
+	agents            []string
+	ipVersion         uint8
+	protocols         []string
+	destinationsFile  string
+	destinationsTable string
+	minTTL            uint8
+	maxTTL            uint8
+}
+
+func (f *retinaFilterFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.agents, "agent", nil, "Keep the FIEs of these agent IDs, may be repeated")
+	cmd.Flags().Uint8Var(&f.ipVersion, "ip-version", 0, "Keep the FIEs of this IP version: 4 or 6 (default: both)")
+	cmd.Flags().StringSliceVar(&f.protocols, "protocol", nil, "Keep the FIEs of these protocols: icmp, udp, icmpv6 or a protocol number, may be repeated")
+	cmd.Flags().StringVar(&f.destinationsFile, "destinations-file", "", "Keep the FIEs whose destination is within a prefix of this file, one per line, # comments allowed")
+	cmd.Flags().StringVar(&f.destinationsTable, "destinations-table", "", "Keep the FIEs whose destination is within a prefix of this ripeprefixes table")
+	cmd.Flags().Uint8Var(&f.minTTL, "min-ttl", 0, "Keep the FIEs whose near probe TTL is at least this")
+	cmd.Flags().Uint8Var(&f.maxTTL, "max-ttl", 0, "Keep the FIEs whose near probe TTL is at most this (default: no limit)")
+}
+
+// filter returns the filter set by the flags, nil if none is set. The
+// prefixes of --destinations-table are loaded by the service.
+func (f *retinaFilterFlags) filter() (*retina.Filter, error) {
+	if f.ipVersion != 0 && f.ipVersion != 4 && f.ipVersion != 6 {
+		return nil, fmt.Errorf("invalid --ip-version %d, expected 4 or 6", f.ipVersion)
+	}
+	if f.maxTTL != 0 && f.maxTTL < f.minTTL {
+		return nil, fmt.Errorf("--max-ttl %d is lower than --min-ttl %d", f.maxTTL, f.minTTL)
+	}
+	filter := &retina.Filter{
+		AgentIDs:  f.agents,
+		IPVersion: api.IPVersion(f.ipVersion),
+		MinTTL:    f.minTTL,
+		MaxTTL:    f.maxTTL,
+	}
+	for _, name := range f.protocols {
+		p, err := parseProtocol(name)
+		if err != nil {
+			return nil, err
+		}
+		filter.Protocols = append(filter.Protocols, p)
+	}
+	if f.destinationsFile != "" {
+		entries, err := readListFile(f.destinationsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --destinations-file: %w", err)
+		}
+		for _, e := range entries {
+			p, err := netip.ParsePrefix(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid prefix %q in %s: %w", e, f.destinationsFile, err)
+			}
+			filter.Destinations = append(filter.Destinations, p.Masked())
+		}
+		if len(filter.Destinations) == 0 {
+			return nil, fmt.Errorf("--destinations-file %s contains no prefix", f.destinationsFile)
+		}
+	}
+	if len(filter.AgentIDs) == 0 && filter.IPVersion == 0 && len(filter.Protocols) == 0 &&
+		len(filter.Destinations) == 0 && filter.MinTTL == 0 && filter.MaxTTL == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// parseProtocol parses a protocol name or number.
+func parseProtocol(s string) (api.Protocol, error) {
+	switch strings.ToLower(s) {
+	case "icmp":
+		return api.ICMP, nil
+	case "udp":
+		return api.UDP, nil
+	case "icmpv6":
+		return api.ICMPv6, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --protocol %q, expected icmp, udp, icmpv6 or a protocol number", s)
+	}
+	return api.Protocol(n), nil
+}
+
 func runFetchRetinaFIEs(
 	ctx context.Context,
 	destinationTable string,
-	policy string,
 	timeout time.Duration,
-	endpoint string,
-	batchSize int,
-) error {
+	clientConfig retina.Config,
+	serviceConfig service.RetinaConfig,
+	destinationsTable string,
+	record string,
+	fromFile string,
+	replaySpeed float64,
+) (err error) {
 	// Apply timeout if set.
 	if timeout > 0 {
 		var cancel context.CancelFunc
@@ -68,16 +197,111 @@ func runFetchRetinaFIEs(
 		return fmt.Errorf("failed to create store: %w", err)
 	}
 
+	if destinationsTable != "" {
+		serviceConfig.DestinationPrefixes = store.DatabaseTable{Database: config.Database, Table: destinationsTable}
+	}
+
+	// Open the replayed and recorded streams.
+	if fromFile != "" {
+		f, err := os.Open(fromFile)
+		if err != nil {
+			return fmt.Errorf("failed to open --from-file: %w", err)
+		}
+		defer f.Close()
+		serviceConfig.Replay = f
+		serviceConfig.ReplaySpeed = replaySpeed
+	}
+	if record != "" {
+		w, err := openRecording(record)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := w.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("failed to close --record file: %w", cerr)
+			}
+		}()
+		serviceConfig.Record = w
+	}
+
 	// Create retina client.
-	retinaClient := retina.NewRetinaClient(retina.Config{
-		Endpoint:  endpoint,
-		BatchSize: batchSize,
-	})
+	retinaClient := retina.NewRetinaClient(clientConfig)
 
 	// Create and run service.
-	svc := service.NewRetinaService(s, retinaClient, service.RetinaConfig{
-		PreparationPolicy: store.PreparationPolicy(policy),
-	})
+	svc := service.NewRetinaService(s, retinaClient, serviceConfig)
 
 	return svc.Stream(ctx, store.DatabaseTable{Database: config.Database, Table: destinationTable})
 }
+
+// recording is a --record file, buffered and gzip-compressed if its name
+// ends in .gz.
+type recording struct {
+	file *os.File
+	buf  *bufio.Writer
+	gz   *gzip.Writer
+}
+
+// openRecording opens path for appending, creating it if needed, so that
+// recording again to the same file extends it instead of truncating it. A
+// .gz file gets a new gzip member per run, which replays as a single
+// stream.
+func openRecording(path string) (*recording, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open --record file: %w", err)
+	}
+	r := &recording{file: f, buf: bufio.NewWriterSize(f, 1<<16)}
+	if strings.HasSuffix(path, ".gz") {
+		r.gz = gzip.NewWriter(r.buf)
+		return r, nil
+	}
+	if err := trimPartialLine(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to repair --record file: %w", err)
+	}
+	return r, nil
+}
+
+// trimPartialLine truncates f after its last newline, dropping the line
+// left partial by a killed run: replaying it would fail to decode.
+func trimPartialLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end, keep := info.Size(), int64(0)
+	buf := make([]byte, 1<<16)
+	for off := end; off > 0; {
+		n := min(off, int64(len(buf)))
+		off -= n
+		if _, err := f.ReadAt(buf[:n], off); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			keep = off + int64(i) + 1
+			break
+		}
+	}
+	if keep == end {
+		return nil
+	}
+	slog.Warn("dropping the partial last line of --record file", "file", f.Name(), "bytes", end-keep)
+	return f.Truncate(keep)
+}
+
+func (r *recording) Write(p []byte) (int, error) {
+	if r.gz != nil {
+		return r.gz.Write(p)
+	}
+	return r.buf.Write(p)
+}
+
+// Close flushes and closes the file.
+func (r *recording) Close() error {
+	var errs []error
+	if r.gz != nil {
+		errs = append(errs, r.gz.Close())
+	}
+	errs = append(errs, r.buf.Flush(), r.file.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dioptra-io/ufuk-research/internal/retina"
+)
+
+// TestRecordingAppends records several runs to the same file, one of them
+// killed in the middle of a line for plain files, and replays them.
+func TestRecordingAppends(t *testing.T) {
+	for _, name := range []string{"stream.ndjson", "stream.ndjson.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			record := func(lines string) {
+				t.Helper()
+				w, err := openRecording(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := fmt.Fprint(w, lines); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			record("{\"sequence_number\": 1}\n{\"sequence_number\": 2}\n")
+			if name == "stream.ndjson" {
+				record(`{"sequence_num`)
+			}
+			record("{\"sequence_number\": 3}\n")
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var seqs []uint64
+			for r := range retina.NewRetinaClient(retina.Config{BatchSize: 1}).Replay(context.Background(), f, 0, retina.StreamOptions{}) {
+				for _, fie := range r.Batch {
+					seqs = append(seqs, fie.SequenceNumber)
+				}
+			}
+			if fmt.Sprint(seqs) != "[1 2 3]" {
+				t.Errorf("replayed %v, want [1 2 3]", seqs)
+			}
+		})
+	}
+}
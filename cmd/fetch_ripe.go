@@ -1,10 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
+	"os"
+	"slices"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/dioptra-io/ufuk-research/internal/ripe"
@@ -15,8 +19,7 @@ import (
 
 func fetchRipePrefixesCmd() *cobra.Command {
 	var (
-		asnsFlag   string
-		tier1      bool
+		asnSel     asnFlags
 		date       string
 		snapshot   string
 		timestamp  string
@@ -24,6 +27,7 @@ func fetchRipePrefixesCmd() *cobra.Command {
 		database   string
 		maxRetries int
 		retryDelay time.Duration
+		opts       ripeFetchOptions
 	)
 
 	cmd := &cobra.Command{
@@ -36,65 +40,77 @@ func fetchRipePrefixesCmd() *cobra.Command {
 				args[0],
 				database,
 				policy,
-				asnsFlag,
-				tier1,
+				asnSel,
 				date,
 				snapshot,
 				timestamp,
 				maxRetries,
 				retryDelay,
+				opts,
 			)
 		},
 	}
 
-	cmd.Flags().StringVar(&asnsFlag, "asns", "", "Comma-separated list of ASNs (e.g. 3356,1299,3257)")
-	cmd.Flags().BoolVar(&tier1, "tier1", false, "Use the hardcoded list of tier-1 ASNs")
+	asnSel.register(cmd, true)
 	cmd.Flags().StringVar(&date, "date", "", "Date for the snapshot (e.g. 2026-06-01), used with --snapshot")
 	cmd.Flags().StringVar(&snapshot, "snapshot", "dawn", "Time of day for the snapshot: dawn, day, night")
 	cmd.Flags().StringVar(&timestamp, "timestamp", "", "Raw RFC3339 timestamp (e.g. 2026-06-01T08:00:00Z), alternative to --date + --snapshot")
+	cmd.Flags().StringVar(&opts.from, "from", "", "First date of a date range (e.g. 2026-05-01), used with --to and --snapshots")
+	cmd.Flags().StringVar(&opts.to, "to", "", "Last date of a date range, inclusive")
+	cmd.Flags().StringVar(&opts.snapshots, "snapshots", "", "Comma-separated times of day fetched for each date of the range (default: --snapshot)")
 	cmd.Flags().StringVar(&policy, "policy", "fail", "Write policy: replace, truncate, fail, append")
 	cmd.Flags().StringVar(&database, "database", envOr("MPAT_DATABASE", store.DefaultDatabase), "ClickHouse database name")
 	cmd.Flags().IntVar(&maxRetries, "max-retries", ripe.DefaultMaxRetries, "Maximum number of retry attempts on failure.")
-	cmd.Flags().DurationVar(&retryDelay, "retry-delay", ripe.DefaultRetryDelay, "Duration to wait between retry attempts.")
+	cmd.Flags().DurationVar(&retryDelay, "retry-delay", ripe.DefaultRetryDelay, "Initial delay between retry attempts, doubled on each retry.")
+	cmd.Flags().DurationVar(&opts.maxRetryDelay, "max-retry-delay", ripe.DefaultMaxRetryDelay, "Maximum delay between retry attempts, unless the server asks for longer with Retry-After")
+	cmd.Flags().IntVar(&opts.concurrency, "concurrency", ripe.DefaultConcurrency, "Number of ASNs fetched in parallel")
+	cmd.Flags().Float64Var(&opts.rateLimit, "rate-limit", ripe.DefaultRateLimit, "Maximum RIPE Stat requests per second, retries included (negative disables the limit)")
+	cmd.Flags().BoolVar(&opts.continueOnError, "continue-on-error", false, "Insert the prefixes of the ASNs that succeeded and report the failed ones")
+	cmd.Flags().StringVar(&opts.statusTable, "status-table", "", "Append the per-ASN fetch outcome to this table")
 
 	return cmd
 }
 
-func runFetchRipePrefixes(ctx context.Context, destTable, database, policy, asnsFlag string, tier1 bool, dateStr, snapshotStr, timestampStr string, maxRetries int, retryDelay time.Duration) error {
-	// Validate ASN flags — exactly one of --asns or --tier1 must be set.
-	if asnsFlag == "" && !tier1 {
-		return fmt.Errorf("exactly one of --asns or --tier1 must be set")
+// ripeFetchOptions holds the date range, concurrency and error handling
+// flags of mp fetch ripe-prefixes.
+type ripeFetchOptions struct {
+	from            string
+	to              string
+	snapshots       string
+	maxRetryDelay   time.Duration
+	concurrency     int
+	rateLimit       float64
+	continueOnError bool
+	statusTable     string
+}
+
+func runFetchRipePrefixes(ctx context.Context, destTable, database, policy string, asnSel asnFlags, dateStr, snapshotStr, timestampStr string, maxRetries int, retryDelay time.Duration, opts ripeFetchOptions) error {
+	// Validate time flags — exactly one of --timestamp, --date or --from/--to must be set.
+	isRange := opts.from != "" || opts.to != ""
+	modes := 0
+	for _, set := range []bool{timestampStr != "", dateStr != "", isRange} {
+		if set {
+			modes++
+		}
 	}
-	if asnsFlag != "" && tier1 {
-		return fmt.Errorf("--asns and --tier1 cannot be set at the same time")
+	if modes != 1 {
+		return fmt.Errorf("exactly one of --timestamp, --date or --from/--to must be set")
 	}
-
-	// Validate time flags — exactly one of --timestamp or --date must be set.
-	if timestampStr == "" && dateStr == "" {
-		return fmt.Errorf("exactly one of --timestamp or --date must be set")
+	if isRange && (opts.from == "" || opts.to == "") {
+		return fmt.Errorf("--from and --to must be set together")
+	}
+	if opts.snapshots != "" && !isRange {
+		return fmt.Errorf("--snapshots requires --from and --to")
 	}
-	if timestampStr != "" && dateStr != "" {
-		return fmt.Errorf("--timestamp and --date cannot be set at the same time")
+	if !isRange && isTableTemplate(destTable) {
+		return fmt.Errorf("table name templates are only supported with --from and --to")
 	}
 
-	// Resolve ASNs.
-	var asns []uint32
-	if tier1 {
-		asns = ripe.Tier1ASNs
-	} else {
-		for s := range strings.SplitSeq(asnsFlag, ",") {
-			s = strings.TrimSpace(s)
-			if s == "" {
-				continue
-			}
-			n, err := strconv.ParseUint(s, 10, 32)
-			if err != nil {
-				return fmt.Errorf("invalid ASN %q: %w", s, err)
-			}
-			asns = append(asns, uint32(n))
-		}
-		if len(asns) == 0 {
-			return fmt.Errorf("--asns must contain at least one ASN")
+	var snapshots []ripeSnapshotSpec
+	if isRange {
+		var err error
+		if snapshots, err = ripeSnapshotSpecs(opts.from, opts.to, cmp.Or(opts.snapshots, snapshotStr)); err != nil {
+			return err
 		}
 	}
 
@@ -108,33 +124,149 @@ func runFetchRipePrefixes(ctx context.Context, destTable, database, policy, asns
 	}
 
 	ripeClient := ripe.NewRipeClient(ripe.RipeConfig{
-		Endpoint:   envOr("MPAT_RIPE_STAT_ENDPOINT", ripe.DefaultEndpoint),
-		MaxRetries: maxRetries,
-		RetryDelay: retryDelay,
+		Endpoint:      envOr("MPAT_RIPE_STAT_ENDPOINT", ripe.DefaultEndpoint),
+		SourceApp:     envOr("MPAT_RIPE_SOURCEAPP", ripe.DefaultSourceApp),
+		MaxRetries:    maxRetries,
+		RetryDelay:    retryDelay,
+		MaxRetryDelay: opts.maxRetryDelay,
+		Concurrency:   opts.concurrency,
+		RateLimit:     opts.rateLimit,
 	})
 
+	// Resolve the query time, at which neighbours are expanded too; a date
+	// range is expanded once, at its first snapshot.
+	var at time.Time
+	switch {
+	case isRange:
+		at = snapshots[0].queryTime
+	case timestampStr != "":
+		if at, err = time.Parse(time.RFC3339, timestampStr); err != nil {
+			return fmt.Errorf("invalid --timestamp %q: %w", timestampStr, err)
+		}
+	default:
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			return fmt.Errorf("invalid --date %q, expected format 2006-01-02: %w", dateStr, err)
+		}
+		if at, err = ripe.TimeOfDay(snapshotStr).QueryTime(date); err != nil {
+			return err
+		}
+	}
+
+	selected, err := asnSel.resolve(ctx, ripeClient, at)
+	if err != nil {
+		return err
+	}
+
+	cfg := service.RipePrefixesConfig{
+		ASNs:              uniqueASNs(selected),
+		PreparationPolicy: store.PreparationPolicy(policy),
+		ContinueOnError:   opts.continueOnError,
+		ASNSet:            selected,
+	}
+	if opts.statusTable != "" {
+		cfg.StatusTable = &store.DatabaseTable{Database: database, Table: opts.statusTable}
+	}
+	svc := service.NewRipePrefixesService(s, ripeClient, cfg)
+
+	if isRange {
+		return runFetchRipeSnapshots(ctx, svc, destTable, database, snapshots)
+	}
 	dest := store.DatabaseTable{
 		Database: database,
 		Table:    destTable,
 	}
+	return reportRipeFailures(svc.FetchAt(ctx, dest, at))
+}
 
-	svc := service.NewRipePrefixesService(s, ripeClient, service.RipePrefixesConfig{
-		ASNs:              asns,
-		PreparationPolicy: store.PreparationPolicy(policy),
-	})
+// ripeSnapshotSpec is a date and time of day of a date range fetch.
+type ripeSnapshotSpec struct {
+	date      time.Time
+	snapshot  ripe.TimeOfDay
+	queryTime time.Time
+}
+
+// ripeSnapshotSpecs returns every snapshot of the inclusive date range, in
+// chronological order of date then in the order of snapshotsStr.
+func ripeSnapshotSpecs(fromStr, toStr, snapshotsStr string) ([]ripeSnapshotSpec, error) {
+	dates, err := parseDateRange(fromStr + ".." + toStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --from/--to: %w", err)
+	}
+	var tods []ripe.TimeOfDay
+	for part := range strings.SplitSeq(snapshotsStr, ",") {
+		tod := ripe.TimeOfDay(strings.TrimSpace(part))
+		if !slices.Contains(tods, tod) {
+			tods = append(tods, tod)
+		}
+	}
 
-	if timestampStr != "" {
-		t, err := time.Parse(time.RFC3339, timestampStr)
+	var specs []ripeSnapshotSpec
+	for _, date := range dates {
+		for _, tod := range tods {
+			t, err := tod.QueryTime(date)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --snapshots: %w", err)
+			}
+			specs = append(specs, ripeSnapshotSpec{date: date, snapshot: tod, queryTime: t})
+		}
+	}
+	return specs, nil
+}
+
+// runFetchRipeSnapshots fetches the snapshots of a date range into destTable,
+// which may be a template over {date} and {snapshot}, and prints a summary.
+// It returns an error if any snapshot failed.
+func runFetchRipeSnapshots(ctx context.Context, svc *service.RipePrefixesService, destTable, database string, specs []ripeSnapshotSpec) error {
+	snapshots := make([]service.RipeSnapshot, len(specs))
+	for i, spec := range specs {
+		table, err := renderTableName(destTable, map[string]string{
+			"date":     spec.date.Format("20060102"),
+			"snapshot": string(spec.snapshot),
+		})
 		if err != nil {
-			return fmt.Errorf("invalid --timestamp %q: %w", timestampStr, err)
+			return err
+		}
+		snapshots[i] = service.RipeSnapshot{
+			Dest:      store.DatabaseTable{Database: database, Table: table},
+			QueryTime: spec.queryTime,
 		}
-		return svc.FetchAt(ctx, dest, t)
 	}
 
-	date, err := time.Parse("2006-01-02", dateStr)
+	start := time.Now()
+	report, err := svc.FetchSnapshots(ctx, snapshots)
 	if err != nil {
-		return fmt.Errorf("invalid --date %q, expected format 2006-01-02: %w", dateStr, err)
+		return err
 	}
 
-	return svc.Fetch(ctx, dest, date, ripe.TimeOfDay(snapshotStr))
+	fmt.Printf("fetched %d snapshot(s), skipped %d already present, %d failed in %s\n",
+		len(report.Fetched), len(report.Skipped), len(report.Failed), time.Since(start).Round(time.Second))
+	if len(report.Failed) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "QUERY TIME\tTABLE\tERROR")
+	for _, f := range report.Failed {
+		fmt.Fprintf(w, "%s\t%s.%s\t%v\n", f.QueryTime.Format(time.RFC3339), f.Dest.Database, f.Dest.Table, f.Err)
+	}
+	_ = w.Flush()
+	return fmt.Errorf("%d of %d snapshot(s) failed; re-run with --policy append to fetch only the missing ones", len(report.Failed), len(snapshots))
+}
+
+// reportRipeFailures prints a table of the failed resources if err is a
+// *service.RipeFetchError, and returns err unchanged so the command still
+// exits non-zero.
+func reportRipeFailures(err error) error {
+	var fetchErr *service.RipeFetchError
+	if !errors.As(err, &fetchErr) {
+		return err
+	}
+	fmt.Printf("fetched %d resource(s), %d failed:\n", fetchErr.Succeeded, len(fetchErr.Failed))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tERROR")
+	for _, f := range fetchErr.Failed {
+		fmt.Fprintf(w, "%s\t%v\n", f.Resource, f.Err)
+	}
+	_ = w.Flush()
+	return err
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/ripe"
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// ripeStatFlags holds the flags shared by the RIPE Stat dataset commands.
+type ripeStatFlags struct {
+	database        string
+	policy          string
+	maxRetries      int
+	retryDelay      time.Duration
+	maxRetryDelay   time.Duration
+	concurrency     int
+	rateLimit       float64
+	continueOnError bool
+}
+
+func (f *ripeStatFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.policy, "policy", string(service.DefaultRipeStatPreparationPolicy), "Write policy: replace, truncate, fail, append")
+	cmd.Flags().StringVar(&f.database, "database", envOr("MPAT_DATABASE", store.DefaultDatabase), "ClickHouse database name")
+	cmd.Flags().IntVar(&f.maxRetries, "max-retries", ripe.DefaultMaxRetries, "Maximum number of retry attempts on failure.")
+	cmd.Flags().DurationVar(&f.retryDelay, "retry-delay", ripe.DefaultRetryDelay, "Initial delay between retry attempts, doubled on each retry.")
+	cmd.Flags().DurationVar(&f.maxRetryDelay, "max-retry-delay", ripe.DefaultMaxRetryDelay, "Maximum delay between retry attempts, unless the server asks for longer with Retry-After")
+	cmd.Flags().IntVar(&f.concurrency, "concurrency", ripe.DefaultConcurrency, "Number of resources fetched in parallel")
+	cmd.Flags().Float64Var(&f.rateLimit, "rate-limit", ripe.DefaultRateLimit, "Maximum RIPE Stat requests per second, retries included (negative disables the limit)")
+	cmd.Flags().BoolVar(&f.continueOnError, "continue-on-error", false, "Insert the rows of the resources that succeeded and report the failed ones")
+}
+
+// service connects to ClickHouse and returns a RipeStatService configured
+// from the flags.
+func (f *ripeStatFlags) service() (*service.RipeStatService, error) {
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store: %w", err)
+	}
+	ripeClient := ripe.NewRipeClient(ripe.RipeConfig{
+		Endpoint:      envOr("MPAT_RIPE_STAT_ENDPOINT", ripe.DefaultEndpoint),
+		SourceApp:     envOr("MPAT_RIPE_SOURCEAPP", ripe.DefaultSourceApp),
+		MaxRetries:    f.maxRetries,
+		RetryDelay:    f.retryDelay,
+		MaxRetryDelay: f.maxRetryDelay,
+		Concurrency:   f.concurrency,
+		RateLimit:     f.rateLimit,
+	})
+	return service.NewRipeStatService(s, ripeClient, service.RipeStatConfig{
+		PreparationPolicy: store.PreparationPolicy(f.policy),
+		ContinueOnError:   f.continueOnError,
+	}), nil
+}
+
+func (f *ripeStatFlags) dest(table string) store.DatabaseTable {
+	return store.DatabaseTable{Database: f.database, Table: table}
+}
+
+func fetchRipeOverviewCmd() *cobra.Command {
+	var (
+		file      string
+		timestamp string
+		flags     ripeStatFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "ripe-overview <dest-table> [prefix-or-address...]",
+		Short: "Fetch the RIPE Stat prefix overview (origins, visibility, block) of prefixes and addresses",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resources, err := resourceList(args[1:], file)
+			if err != nil {
+				return err
+			}
+			var t time.Time
+			if timestamp != "" {
+				if t, err = time.Parse(time.RFC3339, timestamp); err != nil {
+					return fmt.Errorf("invalid --timestamp %q: %w", timestamp, err)
+				}
+			}
+			svc, err := flags.service()
+			if err != nil {
+				return err
+			}
+			return reportRipeFailures(svc.FetchOverviews(cmd.Context(), flags.dest(args[0]), resources, t))
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "File of prefixes or addresses, one per line, # comments allowed")
+	cmd.Flags().StringVar(&timestamp, "timestamp", "", "RFC3339 query time (default: latest data)")
+	flags.register(cmd)
+	return cmd
+}
+
+func fetchRipeNetworkInfoCmd() *cobra.Command {
+	var (
+		file  string
+		flags ripeStatFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "ripe-network-info <dest-table> [address...]",
+		Short: "Look up the longest announced prefix and origins of addresses on RIPE Stat",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := resourceList(args[1:], file)
+			if err != nil {
+				return err
+			}
+			addrs := make([]netip.Addr, len(entries))
+			for i, e := range entries {
+				if addrs[i], err = netip.ParseAddr(e); err != nil {
+					return fmt.Errorf("invalid address %q: %w", e, err)
+				}
+			}
+			svc, err := flags.service()
+			if err != nil {
+				return err
+			}
+			return reportRipeFailures(svc.FetchNetworkInfo(cmd.Context(), flags.dest(args[0]), addrs))
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "File of addresses, one per line, # comments allowed")
+	flags.register(cmd)
+	return cmd
+}
+
+func fetchRipeNeighboursCmd() *cobra.Command {
+	var (
+		asnSel    asnFlags
+		timestamp string
+		flags     ripeStatFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "ripe-neighbours <dest-table>",
+		Short: "Fetch the AS neighbours of ASNs from RIPE Stat",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				t   time.Time
+				err error
+			)
+			if timestamp != "" {
+				if t, err = time.Parse(time.RFC3339, timestamp); err != nil {
+					return fmt.Errorf("invalid --timestamp %q: %w", timestamp, err)
+				}
+			}
+			selected, err := asnSel.resolve(cmd.Context(), nil, t)
+			if err != nil {
+				return err
+			}
+			svc, err := flags.service()
+			if err != nil {
+				return err
+			}
+			return reportRipeFailures(svc.FetchNeighbours(cmd.Context(), flags.dest(args[0]), uniqueASNs(selected), t))
+		},
+	}
+	asnSel.register(cmd, false)
+	cmd.Flags().StringVar(&timestamp, "timestamp", "", "RFC3339 query time (default: latest data)")
+	flags.register(cmd)
+	return cmd
+}
+
+// resourceList returns the resources given as arguments followed by those
+// of file, if set. At least one is required.
+func resourceList(args []string, file string) ([]string, error) {
+	resources := args
+	if file != "" {
+		entries, err := readListFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --file: %w", err)
+		}
+		resources = append(resources, entries...)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no resource given, pass them as arguments or with --file")
+	}
+	return resources, nil
+}
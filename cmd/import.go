@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func importCmd() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import data from local files",
+	}
+	importCmd.AddCommand(importMRTCmd())
+	return importCmd
+}
+
+func importMRTCmd() *cobra.Command {
+	var (
+		database  string
+		policy    string
+		minPeers  int
+		batchSize int
+		timestamp string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mrt <file> <dest-table>",
+		Short: "Import prefix origins from an MRT TABLE_DUMP_V2 RIB dump into a ripeprefixes table",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := service.MRTImportConfig{
+				PreparationPolicy: store.PreparationPolicy(policy),
+				MinPeers:          minPeers,
+				BatchSize:         batchSize,
+			}
+			return runImportMRT(cmd.Context(), args[0], args[1], database, timestamp, cfg)
+		},
+	}
+
+	cmd.Flags().StringVar(&database, "database", envOr("MPAT_DATABASE", store.DefaultDatabase), "ClickHouse database name")
+	cmd.Flags().StringVar(&policy, "policy", string(service.DefaultMRTImportPreparationPolicy), "Write policy: replace, truncate, fail, append")
+	cmd.Flags().IntVar(&minPeers, "min-peers", service.DefaultMRTImportMinPeers, "Minimum number of collector peers that must see a prefix with an origin")
+	cmd.Flags().IntVar(&batchSize, "batch-size", service.DefaultMRTImportBatchSize, "Number of rows per insert")
+	cmd.Flags().StringVar(&timestamp, "timestamp", "", "RFC3339 query_time of the rows (default: timestamp of the dump)")
+
+	return cmd
+}
+
+func runImportMRT(ctx context.Context, path, destTable, database, timestampStr string, cfg service.MRTImportConfig) error {
+	if timestampStr != "" {
+		t, err := time.Parse(time.RFC3339, timestampStr)
+		if err != nil {
+			return fmt.Errorf("invalid --timestamp %q: %w", timestampStr, err)
+		}
+		cfg.QueryTime = t
+	}
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open MRT file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	dest := store.DatabaseTable{Database: database, Table: destTable}
+	stats, err := service.NewMRTImportService(s, cfg).Import(ctx, r, dest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %s prefix origin(s) of %s prefix(es) at %s into %s.%s\n",
+		formatCount(int64(stats.Rows)),
+		formatCount(int64(stats.RIBs)),
+		stats.QueryTime.Format(time.RFC3339),
+		dest.Database,
+		dest.Table,
+	)
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/spf13/cobra"
+)
+
+func irisCmd() *cobra.Command {
+	irisCmd := &cobra.Command{
+		Use:   "iris",
+		Short: "Manage Iris measurements",
+	}
+	measureCmd := &cobra.Command{
+		Use:   "measure",
+		Short: "Create and cancel Iris measurements",
+	}
+	measureCmd.AddCommand(irisMeasureCreateCmd())
+	measureCmd.AddCommand(irisMeasureCancelCmd())
+	irisCmd.AddCommand(measureCmd)
+	return irisCmd
+}
+
+func irisMeasureCreateCmd() *cobra.Command {
+	var (
+		tool        string
+		targetFile  string
+		targetName  string
+		agentTags   []string
+		agentUUIDs  []string
+		tags        []string
+		probingRate int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Upload a target file and create a measurement, printing its UUID",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runIrisMeasureCreate(
+				cmd.Context(),
+				iris.Tool(tool),
+				targetFile,
+				targetName,
+				agentTags,
+				agentUUIDs,
+				tags,
+				probingRate,
+			)
+		},
+	}
+
+	cmd.Flags().StringVar(&tool, "tool", string(iris.ToolDiamondMiner), "Measurement tool: diamond-miner, yarrp, ping, probes")
+	cmd.Flags().StringVar(&targetFile, "target-file", "", "Target list to upload, one prefix,protocol,min_ttl,max_ttl,n_initial_flows per line (required)")
+	cmd.Flags().StringVar(&targetName, "target-name", "", "Name of the target file on Iris (default: base name of --target-file)")
+	cmd.Flags().StringArrayVar(&agentTags, "agent-tag", nil, "Schedule on all agents with this tag (repeatable)")
+	cmd.Flags().StringArrayVar(&agentUUIDs, "agent", nil, "Schedule on the agent with this UUID (repeatable)")
+	cmd.Flags().StringArrayVar(&tags, "tag", nil, "Measurement tag, e.g. zeph or ipv6 (repeatable)")
+	cmd.Flags().IntVar(&probingRate, "probing-rate", 0, "Probing rate in packets per second (default: agent default)")
+	_ = cmd.MarkFlagRequired("target-file")
+
+	return cmd
+}
+
+func runIrisMeasureCreate(ctx context.Context, tool iris.Tool, targetFile, targetName string, agentTags, agentUUIDs, tags []string, probingRate int) error {
+	log := slog.Default()
+
+	if len(agentTags) == 0 && len(agentUUIDs) == 0 {
+		return fmt.Errorf("at least one --agent-tag or --agent is required")
+	}
+	if targetName == "" {
+		targetName = filepath.Base(targetFile)
+	}
+
+	f, err := os.Open(targetFile)
+	if err != nil {
+		return fmt.Errorf("failed to open target file: %w", err)
+	}
+	defer f.Close()
+
+	irisClient, err := newIrisClientFromEnv()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = irisClient.Logout() }()
+
+	key, err := irisClient.UploadTargetFile(targetName, f)
+	if err != nil {
+		return err
+	}
+	log.InfoContext(ctx, "uploaded target file", "key", key)
+
+	var rate *int
+	if probingRate > 0 {
+		rate = &probingRate
+	}
+	req := iris.MeasurementCreate{Tool: tool, Tags: tags}
+	for _, tag := range agentTags {
+		req.Agents = append(req.Agents, iris.MeasurementAgentCreate{Tag: tag, TargetFile: key, ProbingRate: rate})
+	}
+	for _, uuid := range agentUUIDs {
+		req.Agents = append(req.Agents, iris.MeasurementAgentCreate{UUID: uuid, TargetFile: key, ProbingRate: rate})
+	}
+
+	m, err := irisClient.CreateMeasurement(req)
+	if err != nil {
+		return err
+	}
+	log.InfoContext(ctx, "created measurement",
+		"uuid", m.UUID,
+		"tool", m.Tool,
+		"tags", m.Tags,
+		"agents", len(m.Agents),
+	)
+	// The UUID alone goes to stdout so that scripts can capture it.
+	fmt.Println(m.UUID)
+	return nil
+}
+
+func irisMeasureCancelCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel <measurement-uuid>",
+		Short: "Cancel a measurement on all its agents",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			irisClient, err := newIrisClientFromEnv()
+			if err != nil {
+				return err
+			}
+			defer func() { _ = irisClient.Logout() }()
+
+			if err := irisClient.CancelMeasurement(args[0]); err != nil {
+				return err
+			}
+			slog.Default().InfoContext(cmd.Context(), "canceled measurement", "uuid", args[0])
+			return nil
+		},
+	}
+}
+
+// newIrisClientFromEnv creates an Iris client from IRIS_USERNAME,
+// IRIS_PASSWORD, IRIS_ENDPOINT and the optional IRIS_CACHE_TTL and
+// IRIS_CACHE_DIR.
+func newIrisClientFromEnv() (*iris.IrisClient, error) {
+	var cacheTTL time.Duration
+	if v := os.Getenv("IRIS_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IRIS_CACHE_TTL %q: %w", v, err)
+		}
+		cacheTTL = d
+	}
+	irisClient, err := iris.NewIrisClient(iris.Config{
+		Username: mustEnv("IRIS_USERNAME"),
+		Password: mustEnv("IRIS_PASSWORD"),
+		Endpoint: envOr("IRIS_ENDPOINT", iris.DefaultEndpoint),
+		CacheTTL: cacheTTL,
+		CacheDir: os.Getenv("IRIS_CACHE_DIR"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iris client: %w", err)
+	}
+	return irisClient, nil
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -15,14 +19,47 @@ func main() {
 	}
 
 	rootCmd.AddCommand(fetchCmd())
+	rootCmd.AddCommand(importCmd())
 	rootCmd.AddCommand(computeCmd())
+	rootCmd.AddCommand(batchCmd())
+	rootCmd.AddCommand(watchCmd())
+	rootCmd.AddCommand(irisCmd())
+	rootCmd.AddCommand(targetsCmd())
+	rootCmd.AddCommand(dictCmd())
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, received := notifyContext()
+	err := rootCmd.ExecuteContext(ctx)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
+	}
+	// An interrupted command exits with 128 + the signal number, as if it
+	// had been killed, even if it stopped cleanly.
+	if sig, ok := received.Load().(syscall.Signal); ok {
+		os.Exit(128 + int(sig))
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
 
+// notifyContext returns a context canceled on the first SIGINT or SIGTERM,
+// and the signal received once it is. Commands then finish or flush their
+// current chunk or batch and return; a second signal kills the process.
+func notifyContext() (context.Context, *atomic.Value) {
+	ctx, cancel := context.WithCancel(context.Background())
+	received := new(atomic.Value)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		received.Store(sig)
+		fmt.Fprintf(os.Stderr, "received %s, stopping after the current chunk (repeat to force exit)\n", sig)
+		cancel()
+	}()
+	return ctx, received
+}
+
 func mustEnv(key string) string {
 	v := os.Getenv(key)
 	if v == "" {
@@ -50,3 +87,17 @@ func formatCount(n int64) string {
 	}
 	return string(out)
 }
+
+// formatBytes formats n bytes with a binary unit, e.g. "12.3 MiB".
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// indexSelector selects measurements by their 0-based position in a day,
+// ordered by creation time. It is either "all" or an explicit list of indexes.
+type indexSelector struct {
+	all     bool
+	indexes []int
+}
+
+// parseIndexSelector parses "all", a single index ("2"), a comma-separated
+// list ("0,2") or an inclusive range ("0-3"), or any combination of lists and
+// ranges ("0,2-3").
+func parseIndexSelector(s string) (indexSelector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return indexSelector{}, fmt.Errorf("index selector is empty")
+	}
+	if s == "all" {
+		return indexSelector{all: true}, nil
+	}
+
+	var indexes []int
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil || first < 0 {
+			return indexSelector{}, fmt.Errorf("invalid index %q in %q", lo, s)
+		}
+		last := first
+		if isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(hi))
+			if err != nil || last < first {
+				return indexSelector{}, fmt.Errorf("invalid index range %q in %q", part, s)
+			}
+		}
+		for i := first; i <= last; i++ {
+			if !slices.Contains(indexes, i) {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	slices.Sort(indexes)
+	return indexSelector{indexes: indexes}, nil
+}
+
+// resolve returns the selected indexes given n available measurements.
+// Explicit indexes are returned as-is, even when out of range, so that the
+// caller can report them.
+func (sel indexSelector) resolve(n int) []int {
+	if !sel.all {
+		return sel.indexes
+	}
+	out := make([]int, n)
+	for i := range out {
+		out[i] = i
+	}
+	return out
+}
+
+// parseDateRange parses a single date ("2026-05-27") or an inclusive date
+// range ("2026-05-27..2026-06-09") into the list of days it covers.
+func parseDateRange(s string) ([]time.Time, error) {
+	fromStr, toStr, isRange := strings.Cut(strings.TrimSpace(s), "..")
+	if !isRange {
+		toStr = fromStr
+	}
+	from, err := time.Parse(dateLayout, fromStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q: must be YYYY-MM-DD", fromStr)
+	}
+	to, err := time.Parse(dateLayout, toStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q: must be YYYY-MM-DD", toStr)
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid date range %q: end is before start", s)
+	}
+
+	var dates []time.Time
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d)
+	}
+	return dates, nil
+}
+
+// parseKinds parses a comma-separated list of measurement kinds.
+func parseKinds(s string) ([]MeasurementKind, error) {
+	var kinds []MeasurementKind
+	for part := range strings.SplitSeq(s, ",") {
+		k := MeasurementKind(strings.TrimSpace(part))
+		if !k.isValid() {
+			return nil, fmt.Errorf("invalid kind %q: must be one of zeph, ipv6", k)
+		}
+		if !slices.Contains(kinds, k) {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds, nil
+}
+
+var tableTemplateVar = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// isTableTemplate reports whether name contains {placeholder} variables.
+func isTableTemplate(name string) bool {
+	return tableTemplateVar.MatchString(name)
+}
+
+// renderTableName substitutes the {placeholder} variables of tmpl, e.g.
+// "iris_{kind}_{index}__{schema}__{date}". Unknown placeholders are an error.
+func renderTableName(tmpl string, vars map[string]string) (string, error) {
+	var unknown []string
+	out := tableTemplateVar.ReplaceAllStringFunc(tmpl, func(m string) string {
+		key := m[1 : len(m)-1]
+		v, ok := vars[key]
+		if !ok {
+			unknown = append(unknown, m)
+			return m
+		}
+		return v
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder(s) %v in table name template %q", unknown, tmpl)
+	}
+	return out, nil
+}
+
+// readListFile reads a file of one entry per line. Blank lines and comments,
+// starting with #, are ignored, including trailing comments.
+func readListFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for line := range strings.Lines(string(b)) {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func targetsCmd() *cobra.Command {
+	targetsCmd := &cobra.Command{
+		Use:   "targets",
+		Short: "Generate Iris target lists",
+	}
+	targetsCmd.AddCommand(targetsGenerateCmd())
+	return targetsCmd
+}
+
+func targetsGenerateCmd() *cobra.Command {
+	var (
+		database      string
+		timestamp     string
+		ipVersion     uint8
+		prefixLenV4   int
+		prefixLenV6   int
+		maxPerPrefix  int
+		protocol      string
+		minTTL        int
+		maxTTL        int
+		initialFlows  int
+		excludeBogons bool
+		excludeFile   string
+		excludeProbed []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "generate <ripeprefixes-table> <output-file>",
+		Short: "Generate an Iris target file from a ripeprefixes snapshot",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := service.TargetsConfig{
+				IPVersion:     ipVersion,
+				PrefixLenV4:   prefixLenV4,
+				PrefixLenV6:   prefixLenV6,
+				MaxPerPrefix:  maxPerPrefix,
+				Protocol:      protocol,
+				MinTTL:        minTTL,
+				MaxTTL:        maxTTL,
+				InitialFlows:  initialFlows,
+				ExcludeBogons: excludeBogons,
+			}
+			return runTargetsGenerate(cmd.Context(), args[0], args[1], database, timestamp, excludeFile, excludeProbed, cfg)
+		},
+	}
+
+	cmd.Flags().StringVar(&database, "database", envOr("MPAT_DATABASE", store.DefaultDatabase), "ClickHouse database name")
+	cmd.Flags().StringVar(&timestamp, "timestamp", "", "query_time of the snapshot, RFC3339 (default: latest snapshot of the table)")
+	cmd.Flags().Uint8Var(&ipVersion, "ip-version", 0, "Only generate targets of this IP version: 4 or 6 (default: both)")
+	cmd.Flags().IntVar(&prefixLenV4, "prefix-len-v4", service.DefaultTargetPrefixLenV4, "Prefix length of IPv4 targets")
+	cmd.Flags().IntVar(&prefixLenV6, "prefix-len-v6", service.DefaultTargetPrefixLenV6, "Prefix length of IPv6 targets")
+	cmd.Flags().IntVar(&maxPerPrefix, "max-per-prefix", service.DefaultTargetMaxPerPrefix, "Skip announced prefixes that split into more targets than this")
+	cmd.Flags().StringVar(&protocol, "protocol", service.DefaultTargetProtocol, "Probing protocol: icmp (icmp6 for IPv6 targets) or udp")
+	cmd.Flags().IntVar(&minTTL, "min-ttl", service.DefaultTargetMinTTL, "Minimum TTL")
+	cmd.Flags().IntVar(&maxTTL, "max-ttl", service.DefaultTargetMaxTTL, "Maximum TTL")
+	cmd.Flags().IntVar(&initialFlows, "initial-flows", service.DefaultTargetInitialFlows, "Number of initial flows per target")
+	cmd.Flags().BoolVar(&excludeBogons, "exclude-bogons", true, "Exclude special-purpose and reserved address blocks")
+	cmd.Flags().StringVar(&excludeFile, "exclude", "", "File of prefixes to exclude, one per line, # comments allowed")
+	cmd.Flags().StringArrayVar(&excludeProbed, "exclude-probed", nil, "Results table whose probed prefixes, at the target prefix lengths, are excluded (repeatable)")
+
+	return cmd
+}
+
+func runTargetsGenerate(ctx context.Context, sourceTable, outputPath, database, timestampStr, excludeFile string, excludeProbed []string, cfg service.TargetsConfig) error {
+	if timestampStr != "" {
+		t, err := time.Parse(time.RFC3339, timestampStr)
+		if err != nil {
+			return fmt.Errorf("invalid --timestamp %q: %w", timestampStr, err)
+		}
+		cfg.QueryTime = t
+	}
+	if excludeFile != "" {
+		entries, err := readListFile(excludeFile)
+		if err != nil {
+			return fmt.Errorf("failed to read --exclude file: %w", err)
+		}
+		for _, e := range entries {
+			p, err := netip.ParsePrefix(e)
+			if err != nil {
+				return fmt.Errorf("invalid prefix %q in %s: %w", e, excludeFile, err)
+			}
+			cfg.Exclude = append(cfg.Exclude, p.Masked())
+		}
+	}
+	for _, table := range excludeProbed {
+		cfg.ExcludeProbed = append(cfg.ExcludeProbed, store.DatabaseTable{Database: database, Table: table})
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	// Write to a temporary file so that a failure never leaves a truncated
+	// target list behind.
+	tmp := outputPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	source := store.DatabaseTable{Database: database, Table: sourceTable}
+	stats, err := service.NewTargetsService(s, cfg).Generate(ctx, source, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, outputPath); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	fmt.Printf("wrote %s IPv4 and %s IPv6 target(s) from snapshot %s to %s\n",
+		formatCount(int64(stats.TargetsV4)),
+		formatCount(int64(stats.TargetsV6)),
+		stats.QueryTime.Format(time.RFC3339),
+		outputPath,
+	)
+	return nil
+}
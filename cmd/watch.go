@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/batch"
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/service"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultWatchInterval = 10 * time.Minute
+	defaultWatchLookback = 72 * time.Hour
+	defaultWatchState    = "mp-watch.state.json"
+)
+
+func watchCmd() *cobra.Command {
+	var (
+		database      string
+		kindStr       string
+		tagPattern    string
+		resultsTable  string
+		fiesTable     string
+		policy        string
+		fiesPolicy    string
+		statePath     string
+		interval      time.Duration
+		lookback      time.Duration
+		once          bool
+		chunkSize     int
+		lite          bool
+		filterSource  bool
+		provenance    bool
+		cardinality   string
+		nullity       string
+		rttResolution float64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Fetch and compute the FIEs of Iris measurements as they finish",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kinds, err := parseKinds(kindStr)
+			if err != nil {
+				return fmt.Errorf("invalid --kind value: %w", err)
+			}
+			var tag *regexp.Regexp
+			if tagPattern != "" {
+				if tag, err = regexp.Compile(tagPattern); err != nil {
+					return fmt.Errorf("invalid --tag pattern: %w", err)
+				}
+			}
+			if err := service.ValidatePolicies(service.CardinalityPolicy(cardinality), service.NullityPolicy(nullity)); err != nil {
+				return err
+			}
+			return runWatch(cmd.Context(), watchConfig{
+				database:     database,
+				kinds:        kinds,
+				tag:          tag,
+				resultsTable: resultsTable,
+				fiesTable:    fiesTable,
+				statePath:    statePath,
+				interval:     interval,
+				lookback:     lookback,
+				once:         once,
+				filterSource: filterSource,
+				fetch: service.FetchConfig{
+					ChunkSize:         chunkSize,
+					PreparationPolicy: store.PreparationPolicy(policy),
+					Lite:              lite,
+					EWMAAlpha:         0.2,
+					Provenance:        provenance,
+				},
+				fies: service.FIEComputeConfig{
+					ChunkSize:         service.DefaultFIEChunkSize,
+					RTTResolution:     rttResolution,
+					PreparationPolicy: store.PreparationPolicy(fiesPolicy),
+					Cardinality:       service.CardinalityPolicy(cardinality),
+					Nullity:           service.NullityPolicy(nullity),
+				},
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&database, "database", envOr("MPAT_DATABASE", store.DefaultDatabase), "ClickHouse database name")
+	cmd.Flags().StringVar(&kindStr, "kind", "", "Comma-separated measurement kinds to watch: zeph, ipv6 (required)")
+	cmd.Flags().StringVar(&tagPattern, "tag", "", "Additional tag regex the measurements must match")
+	cmd.Flags().StringVar(&resultsTable, "results-table", "", "Results table name template, e.g. iris_{kind}_{index}__{schema}__{date} (required); {index} numbers the finished measurements of the day, like fetch iris-results --index")
+	cmd.Flags().StringVar(&fiesTable, "fies-table", "", "FIE table name template, e.g. fies_{kind}_{index}__{date}; FIEs are not computed if empty")
+	cmd.Flags().StringVar(&policy, "policy", "fail", "Write policy of the results tables: replace, truncate, fail, append")
+	cmd.Flags().StringVar(&fiesPolicy, "fies-policy", "fail", "Write policy of the FIE tables: replace, truncate, fail, append")
+	cmd.Flags().StringVar(&statePath, "state", defaultWatchState, "State file recording the processed measurements")
+	cmd.Flags().DurationVar(&interval, "interval", defaultWatchInterval, "Polling interval")
+	cmd.Flags().DurationVar(&lookback, "lookback", defaultWatchLookback, "Only consider measurements created within this duration")
+	cmd.Flags().BoolVar(&once, "once", false, "Poll once and exit instead of watching")
+	cmd.Flags().IntVar(&chunkSize, "chunk-size", service.DefaultFetchChunkSize, "Streaming chunk size")
+	cmd.Flags().BoolVar(&lite, "lite", true, "Use ResultsLiteSchema instead of ResultsSchema")
+	cmd.Flags().BoolVar(&filterSource, "filter-source", true, "Exclude rows whose IP version does not match the kind (zeph → IPv4, ipv6 → IPv6).")
+	cmd.Flags().BoolVar(&provenance, "provenance", false, "Add measurement_uuid and agent_uuid columns identifying the source of each row")
+	cmd.Flags().Float64Var(&rttResolution, "rtt-resolution", service.DefaultFIERTTResolution, "RTT resolution in milliseconds")
+	cmd.Flags().StringVar(&cardinality, "cardinality", string(service.CardinalityOneToOne), "Cardinality policy: one_to_one, many_to_one, one_to_many, all")
+	cmd.Flags().StringVar(&nullity, "nullity", string(service.NullityBothSome), "Nullity policy: both_some, far_none, any")
+	_ = cmd.MarkFlagRequired("kind")
+	_ = cmd.MarkFlagRequired("results-table")
+
+	return cmd
+}
+
+type watchConfig struct {
+	database     string
+	kinds        []MeasurementKind
+	tag          *regexp.Regexp
+	resultsTable string
+	fiesTable    string
+	statePath    string
+	interval     time.Duration
+	lookback     time.Duration
+	once         bool
+	filterSource bool
+	fetch        service.FetchConfig
+	fies         service.FIEComputeConfig
+}
+
+// finishedMeasurement is a finished measurement with the template variables
+// of its tables.
+type finishedMeasurement struct {
+	measurement iris.MeasurementRead
+	kind        MeasurementKind
+	vars        map[string]string
+}
+
+func runWatch(ctx context.Context, cfg watchConfig) error {
+	log := slog.Default()
+
+	irisClient, err := newIrisClientFromEnv()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = irisClient.Logout() }()
+
+	config, err := store.ConfigFromDSN(mustEnv("MPAT_CLICKHOUSE"))
+	if err != nil {
+		return fmt.Errorf("failed to parse config from DSN: %w", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		return fmt.Errorf("failed to create store: %w", err)
+	}
+
+	state, err := batch.LoadState(cfg.statePath)
+	if err != nil {
+		return err
+	}
+
+	log.InfoContext(ctx, "watching measurements",
+		"kinds", cfg.kinds,
+		"results_table", cfg.resultsTable,
+		"fies_table", cfg.fiesTable,
+		"interval", cfg.interval,
+		"state", cfg.statePath,
+	)
+
+	for {
+		found, err := finishedMeasurements(ctx, irisClient, cfg, time.Now().UTC())
+		if err != nil {
+			if cfg.once {
+				return err
+			}
+			// Iris being unreachable should not end the watch.
+			log.ErrorContext(ctx, "failed to poll measurements", "error", err)
+		}
+
+		processed, failed := 0, 0
+		for _, f := range found {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			did, err := processMeasurement(ctx, s, irisClient, state, cfg, f)
+			if err != nil {
+				// The measurement is not recorded, so it is retried on the next poll.
+				log.ErrorContext(ctx, "failed to process measurement",
+					"measurement", f.measurement.UUID,
+					"error", err,
+				)
+				failed++
+				continue
+			}
+			if did {
+				processed++
+			}
+		}
+		log.InfoContext(ctx, "poll complete",
+			"finished_measurements", len(found),
+			"processed", processed,
+			"failed", failed,
+		)
+
+		if cfg.once {
+			if failed > 0 {
+				return fmt.Errorf("%d measurement(s) failed to process", failed)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.interval):
+		}
+	}
+}
+
+// finishedMeasurements lists the finished measurements of the watched kinds
+// created within the lookback. The {index} of a measurement is its position
+// among the finished measurements of the same day and kind, ordered by
+// creation time, as --index of fetch iris-results and mp batch run with the
+// default --state. Since a measurement still running could finish and take
+// an earlier index, a measurement is only listed once all the measurements
+// created before it on that day have ended.
+func finishedMeasurements(ctx context.Context, irisClient *iris.IrisClient, cfg watchConfig, now time.Time) ([]finishedMeasurement, error) {
+	since := now.Add(-cfg.lookback)
+	// Start at midnight so that the indexes of the first day are complete.
+	start := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.UTC)
+
+	schemaName := "results"
+	if cfg.fetch.Lite {
+		schemaName = "resultslite"
+	}
+
+	var found []finishedMeasurement
+	for _, k := range cfg.kinds {
+		measurements, err := irisClient.Measurements().Fresh().Between(start, now).Tag(k.tag()).Fetch()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch measurements: %w", err)
+		}
+
+		// The creation time of the first measurement of each day still running.
+		running := make(map[string]time.Time)
+		for _, m := range measurements {
+			if m.State.IsTerminal() {
+				continue
+			}
+			day := m.CreationTime.UTC().Format(dateLayout)
+			if t, ok := running[day]; !ok || m.CreationTime.Before(t) {
+				running[day] = m.CreationTime.Time
+			}
+		}
+
+		byDay := dayCandidates(measurements, string(iris.StateFinished))
+		days := slices.Sorted(maps.Keys(byDay))
+		for _, day := range days {
+			for index, m := range byDay[day] {
+				if t, ok := running[day]; ok && t.Before(m.CreationTime.Time) {
+					slog.InfoContext(ctx, "waiting for earlier measurements to end",
+						"kind", k,
+						"date", day,
+						"measurements", len(byDay[day])-index,
+					)
+					break
+				}
+				if m.CreationTime.Before(since) {
+					continue
+				}
+				if cfg.tag != nil && !m.MatchesTag(cfg.tag) {
+					continue
+				}
+				found = append(found, finishedMeasurement{
+					measurement: m,
+					kind:        k,
+					vars: map[string]string{
+						"kind":        string(k),
+						"index":       fmt.Sprintf("%d", index),
+						"schema":      schemaName,
+						"date":        m.CreationTime.UTC().Format("20060102"),
+						"measurement": strings.ReplaceAll(m.UUID, "-", "_"),
+					},
+				})
+			}
+		}
+	}
+	return found, nil
+}
+
+// processMeasurement fetches a finished measurement and computes its FIEs.
+// Each step is recorded in state once it succeeds, so that a restart only
+// runs the steps that did not complete. It reports whether any step ran.
+func processMeasurement(ctx context.Context, s *store.Store, irisClient *iris.IrisClient, state *batch.State, cfg watchConfig, f finishedMeasurement) (bool, error) {
+	log := slog.Default()
+	uuid := f.measurement.UUID
+
+	table, err := renderTableName(cfg.resultsTable, f.vars)
+	if err != nil {
+		return false, err
+	}
+	results := store.DatabaseTable{Database: cfg.database, Table: table}
+
+	ran := false
+	fetchID := "fetch:" + uuid
+	if !state.Done(fetchID) {
+		var sources []iris.IrisTable
+		for _, g := range iris.TableGroupsForMeasurement(f.measurement) {
+			sources = append(sources, g.Results)
+		}
+		if len(sources) == 0 {
+			return false, fmt.Errorf("no results tables found for measurement %s", uuid)
+		}
+
+		fetchConfig := cfg.fetch
+		if cfg.filterSource {
+			fetchConfig.IPVersion = f.kind.ipVersion()
+		}
+		log.InfoContext(ctx, "fetching finished measurement",
+			"measurement", uuid,
+			"kind", f.kind,
+			"index", f.vars["index"],
+			"dest", fmt.Sprintf("%s.%s", results.Database, results.Table),
+		)
+		if err := service.NewFetchService(s, irisClient, fetchConfig).Fetch(ctx, sources, results); err != nil {
+			return false, fmt.Errorf("failed to fetch: %w", err)
+		}
+		if err := state.MarkDone(fetchID); err != nil {
+			return true, err
+		}
+		ran = true
+	}
+
+	if cfg.fiesTable == "" {
+		return ran, nil
+	}
+	fiesID := "fies:" + uuid
+	if state.Done(fiesID) {
+		return ran, nil
+	}
+	vars := maps.Clone(f.vars)
+	vars["input"] = results.Table
+	table, err = renderTableName(cfg.fiesTable, vars)
+	if err != nil {
+		return ran, err
+	}
+	fies := store.DatabaseTable{Database: cfg.database, Table: table}
+
+	log.InfoContext(ctx, "computing fies",
+		"measurement", uuid,
+		"source", fmt.Sprintf("%s.%s", results.Database, results.Table),
+		"dest", fmt.Sprintf("%s.%s", fies.Database, fies.Table),
+	)
+	if err := service.NewFIEComputeService(s, cfg.fies).Compute(ctx, results, fies); err != nil {
+		return ran, fmt.Errorf("failed to compute fies: %w", err)
+	}
+	if err := state.MarkDone(fiesID); err != nil {
+		return true, err
+	}
+	return true, nil
+}
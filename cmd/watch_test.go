@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/iris/iristest"
+	"github.com/dioptra-io/ufuk-research/internal/service"
+)
+
+func TestFinishedMeasurements(t *testing.T) {
+	srv := newTestIris(t)
+	client, err := iris.NewIrisClient(srv.Config())
+	if err != nil {
+		t.Fatalf("NewIrisClient: %v", err)
+	}
+	srv.AddMeasurement(iristest.Measurement(testMeasurementUUID(5), time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC), iris.StateFinished, []string{"zeph", "pilot"}, testAgent))
+	now := time.Date(2026, 6, 1, 23, 0, 0, 0, time.UTC)
+	cfg := watchConfig{kinds: []MeasurementKind{KindZeph}, lookback: 24 * time.Hour, fetch: service.FetchConfig{Lite: true}}
+
+	list := func(cfg watchConfig) map[string]string {
+		t.Helper()
+		found, err := finishedMeasurements(context.Background(), client, cfg, now)
+		if err != nil {
+			t.Fatalf("finishedMeasurements: %v", err)
+		}
+		indexes := make(map[string]string)
+		for _, f := range found {
+			indexes[f.measurement.UUID] = f.vars["index"]
+		}
+		return indexes
+	}
+
+	// The measurements of 10:00 and 12:00 wait for the one of 09:00, still
+	// running.
+	got := list(cfg)
+	if len(got) != 1 || got[testMeasurementUUID(1)] != "0" {
+		t.Errorf("indexes = %v, want only %s at 0", got, testMeasurementUUID(1))
+	}
+
+	srv.SetMeasurementState(testMeasurementUUID(2), iris.StateFinished)
+	got = list(cfg)
+	want := map[string]string{testMeasurementUUID(1): "0", testMeasurementUUID(2): "1", testMeasurementUUID(0): "2", testMeasurementUUID(5): "3"}
+	if len(got) != len(want) {
+		t.Fatalf("indexes = %v, want %v", got, want)
+	}
+	for uuid, index := range want {
+		if got[uuid] != index {
+			t.Errorf("index of %s = %q, want %q", uuid, got[uuid], index)
+		}
+	}
+
+	// fetch iris-results and mp batch run resolve the same indexes.
+	dates, _ := parseDateRange("2026-06-01")
+	all, _ := parseIndexSelector("all")
+	jobs, err := resolveDateKindJobs(client, dates, []MeasurementKind{KindZeph}, all, string(iris.StateFinished), "t_{index}", "db", true)
+	if err != nil {
+		t.Fatalf("resolveDateKindJobs: %v", err)
+	}
+	for _, j := range jobs {
+		if got[j.measurementUUID] != j.vars["index"] {
+			t.Errorf("watch numbers %s %q, fetch iris-results %q", j.measurementUUID, got[j.measurementUUID], j.vars["index"])
+		}
+	}
+
+	// --tag filters the measurements without renumbering them.
+	cfg.tag = regexp.MustCompile("^pilot$")
+	if got := list(cfg); len(got) != 1 || got[testMeasurementUUID(5)] != "3" {
+		t.Errorf("indexes with --tag = %v, want only %s at 3", got, testMeasurementUUID(5))
+	}
+}
@@ -0,0 +1,142 @@
+package batch
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultConcurrency = 1
+)
+
+// JobType identifies the command a manifest job runs.
+type JobType string
+
+const (
+	// JobFetchIrisResults runs the equivalent of `mp fetch iris-results` in
+	// date/kind/index mode, one job per (date, kind, index).
+	JobFetchIrisResults JobType = "fetch-iris-results"
+	// JobFetchRipePrefixes runs the equivalent of `mp fetch ripe-prefixes`,
+	// one job per (date, snapshot).
+	JobFetchRipePrefixes JobType = "fetch-ripe-prefixes"
+	// JobComputeFIEs runs the equivalent of `mp compute fies`.
+	JobComputeFIEs JobType = "compute-fies"
+)
+
+// Manifest declares a set of fetch and compute jobs to run together.
+type Manifest struct {
+	// Concurrency bounds the number of jobs running at the same time.
+	// Defaults to DefaultConcurrency.
+	Concurrency int `yaml:"concurrency"`
+
+	// Database is the destination ClickHouse database of every job.
+	// Defaults to the database of the ClickHouse DSN.
+	Database string `yaml:"database"`
+
+	Jobs []JobSpec `yaml:"jobs"`
+}
+
+// JobSpec declares one manifest entry. It expands into one or more jobs,
+// e.g. one per date and snapshot. Table names are templates whose
+// {placeholders} are filled per expanded job.
+type JobSpec struct {
+	// Name identifies the entry in logs, reports and `from` references.
+	Name string  `yaml:"name"`
+	Type JobType `yaml:"type"`
+
+	// Table is the destination table name template.
+	Table string `yaml:"table"`
+
+	// Policy is the write policy: replace, truncate, fail, append.
+	Policy string `yaml:"policy"`
+
+	// Dates is a single date (2026-05-27) or an inclusive range
+	// (2026-05-27..2026-06-09).
+	Dates string `yaml:"dates"`
+
+	// fetch-iris-results
+	Kinds        []string `yaml:"kinds"`
+	Indexes      string   `yaml:"indexes"` // 0, 0,2, 0-3 or all
+	State        string   `yaml:"state"`
+	Lite         *bool    `yaml:"lite"`
+	FilterSource *bool    `yaml:"filter_source"`
+	Provenance   bool     `yaml:"provenance"`
+	ChunkSize    int      `yaml:"chunk_size"`
+
+	// fetch-ripe-prefixes
+	ASNs      []uint32 `yaml:"asns"`
+	Tier1     bool     `yaml:"tier1"`
+	Snapshots []string `yaml:"snapshots"` // dawn, day, night
+
+	// compute-fies
+	From          string  `yaml:"from"`  // name of a fetch-iris-results entry whose tables are the inputs
+	Input         string  `yaml:"input"` // input table name template, alternative to From
+	Cardinality   string  `yaml:"cardinality"`
+	Nullity       string  `yaml:"nullity"`
+	RTTResolution float64 `yaml:"rtt_resolution"`
+}
+
+// LoadManifest reads and validates a YAML manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("batch: failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("batch: failed to parse manifest %s: %w", path, err)
+	}
+	if m.Concurrency <= 0 {
+		m.Concurrency = DefaultConcurrency
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Validate checks the structural constraints of the manifest. Values such as
+// dates and index selectors are validated when the jobs are expanded.
+func (m *Manifest) Validate() error {
+	if len(m.Jobs) == 0 {
+		return fmt.Errorf("batch: manifest declares no jobs")
+	}
+	seen := make(map[string]JobType)
+	for i, j := range m.Jobs {
+		if j.Name == "" {
+			return fmt.Errorf("batch: job %d has no name", i)
+		}
+		if _, ok := seen[j.Name]; ok {
+			return fmt.Errorf("batch: duplicate job name %q", j.Name)
+		}
+		if j.Table == "" {
+			return fmt.Errorf("batch: job %q has no table", j.Name)
+		}
+		switch j.Type {
+		case JobFetchIrisResults:
+			if j.Dates == "" || len(j.Kinds) == 0 || j.Indexes == "" {
+				return fmt.Errorf("batch: job %q requires dates, kinds and indexes", j.Name)
+			}
+		case JobFetchRipePrefixes:
+			if j.Dates == "" || len(j.Snapshots) == 0 {
+				return fmt.Errorf("batch: job %q requires dates and snapshots", j.Name)
+			}
+			if (len(j.ASNs) == 0) == !j.Tier1 {
+				return fmt.Errorf("batch: job %q requires exactly one of asns or tier1", j.Name)
+			}
+		case JobComputeFIEs:
+			if (j.From == "") == (j.Input == "") {
+				return fmt.Errorf("batch: job %q requires exactly one of from or input", j.Name)
+			}
+			if j.From != "" && seen[j.From] != JobFetchIrisResults {
+				return fmt.Errorf("batch: job %q: from must name an earlier %s job, got %q", j.Name, JobFetchIrisResults, j.From)
+			}
+		default:
+			return fmt.Errorf("batch: job %q has unknown type %q", j.Name, j.Type)
+		}
+		seen[j.Name] = j.Type
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// JobStatus is the outcome of a job in a run.
+type JobStatus string
+
+const (
+	// StatusSucceeded means the job ran and completed successfully.
+	StatusSucceeded JobStatus = "succeeded"
+	// StatusFailed means the job ran and returned an error.
+	StatusFailed JobStatus = "failed"
+	// StatusSkipped means the job did not run because a job it depends on failed.
+	StatusSkipped JobStatus = "skipped"
+	// StatusCompleted means the job completed in a previous run and was not run again.
+	StatusCompleted JobStatus = "completed"
+	// StatusCanceled means the run was canceled before the job started.
+	StatusCanceled JobStatus = "canceled"
+)
+
+// Job is a single unit of work expanded from a JobSpec.
+type Job struct {
+	// ID identifies the job across runs. It is derived from the job type and
+	// destination, so that a job is recognised as completed when re-run.
+	ID   string
+	Name string // name of the JobSpec this job was expanded from
+	Type JobType
+
+	// Dest is the qualified destination table. Jobs sharing a destination
+	// run one after the other, in manifest order.
+	Dest string
+	// Inputs are the qualified tables the job reads. A job waits for the
+	// earlier jobs writing its inputs and is skipped if any of them failed.
+	Inputs []string
+	// Needs are the IDs of earlier jobs the job depends on without reading
+	// their destination, e.g. a job preparing a table shared by several
+	// jobs. The job waits for them and is skipped if any of them failed.
+	Needs []string
+	// Vars are the template variables the destination was rendered with.
+	Vars map[string]string
+
+	Run func(ctx context.Context) error
+}
+
+// JobResult is the outcome of a Job, as written in the run report.
+type JobResult struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Type       JobType           `json:"type"`
+	Dest       string            `json:"dest"`
+	Vars       map[string]string `json:"vars,omitempty"`
+	Status     JobStatus         `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  *time.Time        `json:"started_at,omitempty"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Seconds    float64           `json:"seconds,omitempty"`
+}
+
+// Report summarises a run. It is written as JSON.
+type Report struct {
+	Manifest   string            `json:"manifest"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Counts     map[JobStatus]int `json:"counts"`
+	Jobs       []JobResult       `json:"jobs"`
+}
+
+// Failed returns the number of jobs that failed or were skipped.
+func (r *Report) Failed() int {
+	return r.Counts[StatusFailed] + r.Counts[StatusSkipped]
+}
+
+// Write writes the report as indented JSON to path.
+func (r *Report) Write(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("batch: failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return fmt.Errorf("batch: failed to write report: %w", err)
+	}
+	return nil
+}
+
+// RunnerConfig holds the configuration for a Runner.
+type RunnerConfig struct {
+	// Concurrency bounds the number of jobs running at the same time.
+	// Defaults to DefaultConcurrency.
+	Concurrency int
+	// State records completed jobs. If nil, no job is skipped and nothing is recorded.
+	State *State
+}
+
+// Runner runs jobs with bounded concurrency, respecting their dependencies.
+type Runner struct {
+	config RunnerConfig
+}
+
+// NewRunner creates a new Runner with the given config.
+func NewRunner(cfg RunnerConfig) *Runner {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	return &Runner{config: cfg}
+}
+
+// Run runs all jobs and returns the report. A failed job does not stop the
+// run; only the jobs depending on it are skipped.
+func (r *Runner) Run(ctx context.Context, manifest string, jobs []Job) *Report {
+	log := slog.Default()
+
+	report := &Report{
+		Manifest:  manifest,
+		StartedAt: time.Now().UTC(),
+		Counts:    make(map[JobStatus]int),
+		Jobs:      make([]JobResult, len(jobs)),
+	}
+
+	done := make([]chan struct{}, len(jobs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	inputs, serial := dependencies(jobs)
+	sem := make(chan struct{}, r.config.Concurrency)
+
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+
+			result := &report.Jobs[i]
+			*result = JobResult{ID: job.ID, Name: job.Name, Type: job.Type, Dest: job.Dest, Vars: job.Vars}
+
+			if r.config.State != nil && r.config.State.Done(job.ID) {
+				result.Status = StatusCompleted
+				return
+			}
+
+			// Wait for dependencies. Their results are final once done is closed.
+			for _, d := range serial[i] {
+				<-done[d]
+			}
+			for _, d := range inputs[i] {
+				<-done[d]
+				if s := report.Jobs[d].Status; s != StatusSucceeded && s != StatusCompleted {
+					result.Status = StatusSkipped
+					result.Error = fmt.Sprintf("dependency %s did not succeed (%s)", jobs[d].ID, s)
+					return
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				result.Status = StatusCanceled
+				return
+			}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				result.Status = StatusCanceled
+				return
+			}
+
+			started := time.Now().UTC()
+			result.StartedAt = &started
+			log.InfoContext(ctx, "job started", "id", job.ID, "name", job.Name)
+
+			err := job.Run(ctx)
+
+			finished := time.Now().UTC()
+			result.FinishedAt = &finished
+			result.Seconds = finished.Sub(started).Seconds()
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+				log.ErrorContext(ctx, "job failed", "id", job.ID, "error", err)
+				return
+			}
+			result.Status = StatusSucceeded
+			log.InfoContext(ctx, "job succeeded", "id", job.ID, "elapsed", finished.Sub(started).Round(time.Second))
+
+			if r.config.State != nil {
+				if err := r.config.State.MarkDone(job.ID); err != nil {
+					log.ErrorContext(ctx, "failed to record job completion", "id", job.ID, "error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, res := range report.Jobs {
+		report.Counts[res.Status]++
+	}
+	report.FinishedAt = time.Now().UTC()
+	return report
+}
+
+// dependencies returns, for each job, the indexes of the earlier jobs it must
+// wait for. inputs lists the jobs it needs or writing one of its inputs,
+// which must have succeeded; serial lists the jobs writing the same destination, which only
+// need to have finished.
+func dependencies(jobs []Job) (inputs, serial [][]int) {
+	inputs = make([][]int, len(jobs))
+	serial = make([][]int, len(jobs))
+	for i, job := range jobs {
+		for j := range i {
+			switch {
+			case slices.Contains(job.Needs, jobs[j].ID), slices.Contains(job.Inputs, jobs[j].Dest):
+				inputs[i] = append(inputs[i], j)
+			case jobs[j].Dest == job.Dest:
+				serial[i] = append(serial[i], j)
+			}
+		}
+	}
+	return inputs, serial
+}
@@ -0,0 +1,69 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// State records the jobs that completed successfully, so that re-running a
+// manifest skips them. It is persisted as JSON after every completion.
+type State struct {
+	path string
+
+	mu        sync.Mutex
+	Completed map[string]time.Time `json:"completed"`
+}
+
+// LoadState reads the state file at path. A missing file yields an empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Completed: make(map[string]time.Time)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("batch: failed to read state: %w", err)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("batch: failed to parse state %s: %w", path, err)
+	}
+	if s.Completed == nil {
+		s.Completed = make(map[string]time.Time)
+	}
+	return s, nil
+}
+
+// Done reports whether the job with the given ID completed in a previous run.
+func (s *State) Done(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Completed[id]
+	return ok
+}
+
+// MarkDone records the job as completed and writes the state file.
+func (s *State) MarkDone(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Completed[id] = time.Now().UTC()
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("batch: failed to encode state: %w", err)
+	}
+	// Write to a temporary file first so that a crash never leaves a
+	// truncated state behind.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("batch: failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("batch: failed to write state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package iris
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultCacheDir returns the default measurement list cache directory,
+// mpat/iris under the user cache directory.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "mpat", "iris")
+}
+
+// measurementCache stores measurement listings as JSON files, one per
+// listing, keyed on the endpoint, user and API filters.
+type measurementCache struct {
+	dir      string
+	ttl      time.Duration
+	endpoint string
+	username string
+}
+
+type cacheEntry struct {
+	FetchedAt    time.Time         `json:"fetched_at"`
+	Measurements []MeasurementRead `json:"measurements"`
+}
+
+// cache returns the measurement list cache, or nil if it is disabled.
+func (c *IrisClient) cache() *measurementCache {
+	if c.config.CacheTTL <= 0 {
+		return nil
+	}
+	dir := c.config.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir()
+	}
+	return &measurementCache{
+		dir:      dir,
+		ttl:      c.config.CacheTTL,
+		endpoint: c.config.endpoint(),
+		username: c.config.Username,
+	}
+}
+
+func (mc *measurementCache) path(lq listQuery) string {
+	cutoff := ""
+	if lq.cutoff != nil {
+		cutoff = lq.cutoff.UTC().Format(time.RFC3339)
+	}
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s", mc.endpoint, mc.username, lq.state, lq.tag, cutoff)
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(mc.dir, "measurements-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// load returns the cached listing if it exists and has not expired.
+func (mc *measurementCache) load(lq listQuery) ([]MeasurementRead, bool) {
+	b, err := os.ReadFile(mc.path(lq))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, false
+	}
+	if time.Since(entry.FetchedAt) > mc.ttl {
+		return nil, false
+	}
+	return entry.Measurements, true
+}
+
+// store writes a listing to the cache.
+func (mc *measurementCache) store(lq listQuery, measurements []MeasurementRead) error {
+	if err := os.MkdirAll(mc.dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cacheEntry{FetchedAt: time.Now().UTC(), Measurements: measurements})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that concurrent commands never read
+	// a partial listing.
+	path := mc.path(lq)
+	tmp, err := os.CreateTemp(mc.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
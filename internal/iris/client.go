@@ -1,6 +1,7 @@
 package iris
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,6 +23,13 @@ type Config struct {
 	Username string
 	Password string
 	Endpoint string // defaults to DefaultEndpoint
+
+	// CacheTTL enables the on-disk measurement list cache: listings younger
+	// than CacheTTL are read from CacheDir instead of the API. Zero disables
+	// the cache.
+	CacheTTL time.Duration
+	// CacheDir is the cache directory. Defaults to DefaultCacheDir.
+	CacheDir string
 }
 
 func (c *Config) endpoint() string {
@@ -30,9 +39,12 @@ func (c *Config) endpoint() string {
 	return strings.TrimRight(c.Endpoint, "/")
 }
 
+// IrisClient is safe for concurrent use.
 type IrisClient struct {
-	config   Config
-	http     *http.Client
+	config Config
+	http   *http.Client
+
+	mu       sync.Mutex // guards token and services
 	token    string
 	services *ExternalServices // cached ClickHouse/S3 credentials
 }
@@ -76,13 +88,23 @@ func (c *IrisClient) Login() error {
 		return fmt.Errorf("iris: failed to decode login response: %w", err)
 	}
 
+	c.mu.Lock()
 	c.token = bearer.AccessToken
+	c.mu.Unlock()
 	return nil
 }
 
+// currentToken returns the JWT token obtained by the last login.
+func (c *IrisClient) currentToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
 // Logout invalidates the JWT token on the server and clears it from memory.
 func (c *IrisClient) Logout() error {
-	if c.token == "" {
+	token := c.currentToken()
+	if token == "" {
 		return fmt.Errorf("iris: not logged in")
 	}
 
@@ -90,7 +112,7 @@ func (c *IrisClient) Logout() error {
 	if err != nil {
 		return fmt.Errorf("iris: failed to build logout request: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -98,16 +120,21 @@ func (c *IrisClient) Logout() error {
 	}
 	defer resp.Body.Close()
 
+	c.mu.Lock()
 	c.token = ""
 	c.services = nil
+	c.mu.Unlock()
 	return nil
 }
 
 // Services returns the external service credentials (ClickHouse, S3).
 // Results are cached and refreshed automatically when expired.
 func (c *IrisClient) Services() (ExternalServices, error) {
-	if c.services != nil && time.Now().Before(c.services.ClickHouseExpirationTime.Time) {
-		return *c.services, nil
+	c.mu.Lock()
+	cached := c.services
+	c.mu.Unlock()
+	if cached != nil && time.Now().Before(cached.ClickHouseExpirationTime.Time) {
+		return *cached, nil
 	}
 
 	var services ExternalServices
@@ -115,7 +142,9 @@ func (c *IrisClient) Services() (ExternalServices, error) {
 		return ExternalServices{}, fmt.Errorf("iris: failed to get services: %w", err)
 	}
 
+	c.mu.Lock()
 	c.services = &services
+	c.mu.Unlock()
 	return services, nil
 }
 
@@ -134,7 +163,11 @@ type MeasurementQueryBuilder struct {
 	state      *MeasurementAgentState
 	from       *time.Time
 	to         *time.Time
+	tag        string
+	tool       *Tool
 	tagPattern *regexp.Regexp
+	fresh      bool
+	err        error
 }
 
 // Measurements returns a new MeasurementQueryBuilder.
@@ -155,34 +188,83 @@ func (q *MeasurementQueryBuilder) Between(from, to time.Time) *MeasurementQueryB
 	return q
 }
 
+// Tag filters measurements having exactly the given tag. The filter is
+// applied by the API.
+func (q *MeasurementQueryBuilder) Tag(tag string) *MeasurementQueryBuilder {
+	q.tag = tag
+	return q
+}
+
+// Tool filters measurements by tool. The API does not support this filter,
+// so it is applied in memory.
+func (q *MeasurementQueryBuilder) Tool(t Tool) *MeasurementQueryBuilder {
+	q.tool = &t
+	return q
+}
+
 // TagContains filters measurements whose tags match the given regex pattern.
+// An invalid pattern is reported by Fetch.
 func (q *MeasurementQueryBuilder) TagContains(pattern string) *MeasurementQueryBuilder {
-	q.tagPattern = regexp.MustCompile(pattern)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		q.err = fmt.Errorf("iris: invalid tag pattern %q: %w", pattern, err)
+		return q
+	}
+	q.tagPattern = re
+	return q
+}
+
+// Fresh bypasses the measurement list cache, for callers that poll states.
+func (q *MeasurementQueryBuilder) Fresh() *MeasurementQueryBuilder {
+	q.fresh = true
 	return q
 }
 
 // Fetch executes the query and returns all matching measurements.
-// If no state is set, it fans out over all possible states.
+// If no state is set, it fans out over all possible states in parallel.
 // If a `from` date is set, pagination stops early once results go older than
 // that date, since the API returns measurements newest-first.
 func (q *MeasurementQueryBuilder) Fetch() ([]MeasurementRead, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
 	states := AllMeasurementStates
 	if q.state != nil {
 		states = []MeasurementAgentState{*q.state}
 	}
 
+	results := make([][]MeasurementRead, len(states))
+	errs := make([]error, len(states))
+	var wg sync.WaitGroup
+	for i, state := range states {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = q.client.listMeasurements(listQuery{state: state, tag: q.tag, cutoff: q.from}, q.fresh)
+		}()
+	}
+	wg.Wait()
+
 	var all []MeasurementRead
-	for _, state := range states {
-		results, err := q.client.fetchAllMeasurements(state, q.from)
-		if err != nil {
-			return nil, err
+	for i := range states {
+		if errs[i] != nil {
+			return nil, errs[i]
 		}
-		all = append(all, results...)
+		all = append(all, results[i]...)
 	}
 
 	return q.applyFilters(all), nil
 }
 
+// Measurement returns a single measurement, including the state of each agent.
+func (c *IrisClient) Measurement(uuid string) (MeasurementReadWithAgents, error) {
+	var m MeasurementReadWithAgents
+	if err := c.get("/measurements/"+url.PathEscape(uuid), nil, &m); err != nil {
+		return MeasurementReadWithAgents{}, fmt.Errorf("iris: failed to get measurement %s: %w", uuid, err)
+	}
+	return m, nil
+}
+
 // applyFilters applies in-memory filters on the fetched measurements.
 func (q *MeasurementQueryBuilder) applyFilters(measurements []MeasurementRead) []MeasurementRead {
 	result := make([]MeasurementRead, 0, len(measurements))
@@ -193,7 +275,10 @@ func (q *MeasurementQueryBuilder) applyFilters(measurements []MeasurementRead) [
 		if q.to != nil && m.CreationTime.Time.After(*q.to) {
 			continue
 		}
-		if q.tagPattern != nil && !matchesTagPattern(m.Tags, q.tagPattern) {
+		if q.tool != nil && m.Tool != *q.tool {
+			continue
+		}
+		if q.tagPattern != nil && !m.MatchesTag(q.tagPattern) {
 			continue
 		}
 		result = append(result, m)
@@ -201,8 +286,9 @@ func (q *MeasurementQueryBuilder) applyFilters(measurements []MeasurementRead) [
 	return result
 }
 
-func matchesTagPattern(tags []string, pattern *regexp.Regexp) bool {
-	for _, tag := range tags {
+// MatchesTag reports whether any tag of the measurement matches pattern.
+func (m MeasurementRead) MatchesTag(pattern *regexp.Regexp) bool {
+	for _, tag := range m.Tags {
 		if pattern.MatchString(tag) {
 			return true
 		}
@@ -210,27 +296,57 @@ func matchesTagPattern(tags []string, pattern *regexp.Regexp) bool {
 	return false
 }
 
-// fetchAllMeasurements paginates through measurements for a given state.
-// If cutoff is set, it stops as soon as a result's creation_time is before
+// listQuery holds the filters of a measurement listing applied by the API.
+type listQuery struct {
+	state  MeasurementAgentState
+	tag    string
+	cutoff *time.Time
+}
+
+// listMeasurements returns the measurements of a listing, from the cache if
+// it is enabled, fresh is not set and the cached listing has not expired.
+func (c *IrisClient) listMeasurements(lq listQuery, fresh bool) ([]MeasurementRead, error) {
+	cache := c.cache()
+	if cache != nil && !fresh {
+		if cached, ok := cache.load(lq); ok {
+			return cached, nil
+		}
+	}
+	measurements, err := c.fetchAllMeasurements(lq)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		// The cache only saves listing time; failing to write it is not fatal.
+		_ = cache.store(lq, measurements)
+	}
+	return measurements, nil
+}
+
+// fetchAllMeasurements paginates through the measurements of a listing.
+// If a cutoff is set, it stops as soon as a result's creation_time is before
 // the cutoff, exploiting the API's newest-first ordering.
-func (c *IrisClient) fetchAllMeasurements(state MeasurementAgentState, cutoff *time.Time) ([]MeasurementRead, error) {
+func (c *IrisClient) fetchAllMeasurements(lq listQuery) ([]MeasurementRead, error) {
 	var all []MeasurementRead
 	offset := 0
 
 	for {
 		params := url.Values{}
-		params.Set("state", string(state))
+		params.Set("state", string(lq.state))
 		params.Set("only_mine", "false")
 		params.Set("limit", fmt.Sprintf("%d", pageLimit))
 		params.Set("offset", fmt.Sprintf("%d", offset))
+		if lq.tag != "" {
+			params.Set("tag", lq.tag)
+		}
 
 		var page Paginated[MeasurementRead]
 		if err := c.get("/measurements/", params, &page); err != nil {
-			return nil, fmt.Errorf("iris: failed to fetch measurements (state=%s, offset=%d): %w", state, offset, err)
+			return nil, fmt.Errorf("iris: failed to fetch measurements (state=%s, offset=%d): %w", lq.state, offset, err)
 		}
 
 		for _, m := range page.Results {
-			if cutoff != nil && m.CreationTime.Time.Before(*cutoff) {
+			if lq.cutoff != nil && m.CreationTime.Time.Before(*lq.cutoff) {
 				// Everything from here on is older than our cutoff — stop.
 				return all, nil
 			}
@@ -342,21 +458,41 @@ func (q *SelectQuery) execute(format clickhouseFormat) (io.ReadCloser, error) {
 // get performs an authenticated GET request and decodes the JSON response.
 // On a 401 it re-logs in once and retries.
 func (c *IrisClient) get(path string, params url.Values, out any) error {
-	return c.getWithRetry(path, params, out, true)
+	return c.send(http.MethodGet, path, params, "", nil, out, true)
 }
 
-func (c *IrisClient) getWithRetry(path string, params url.Values, out any, retry bool) error {
+// postJSON performs an authenticated POST request with a JSON body and
+// decodes the JSON response. On a 401 it re-logs in once and retries.
+func (c *IrisClient) postJSON(path string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("iris: failed to encode request: %w", err)
+	}
+	return c.send(http.MethodPost, path, nil, "application/json", body, out, true)
+}
+
+// send performs an authenticated request and, if out is not nil, decodes the
+// JSON response. The body is kept as bytes so that it can be sent again after
+// a re-login.
+func (c *IrisClient) send(method, path string, params url.Values, contentType string, body []byte, out any, retry bool) error {
 	u := c.config.endpoint() + path
 	if len(params) > 0 {
 		u += "?" + params.Encode()
 	}
 
-	req, err := http.NewRequest(http.MethodGet, u, nil)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, reader)
 	if err != nil {
 		return fmt.Errorf("iris: failed to build request: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+c.currentToken())
 	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -368,16 +504,31 @@ func (c *IrisClient) getWithRetry(path string, params url.Values, out any, retry
 		if err := c.Login(); err != nil {
 			return fmt.Errorf("iris: re-login failed: %w", err)
 		}
-		return c.getWithRetry(path, params, out, false)
+		return c.send(method, path, params, contentType, body, out, false)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("iris: unexpected status %d for %s", resp.StatusCode, path)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("iris: unexpected status %d for %s %s%s", resp.StatusCode, method, path, errorDetail(resp.Body))
 	}
 
+	if out == nil {
+		return nil
+	}
 	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
 		return fmt.Errorf("iris: failed to decode response: %w", err)
 	}
 
 	return nil
 }
+
+// errorDetail extracts the FastAPI "detail" field of an error response, if any,
+// formatted for appending to an error message.
+func errorDetail(r io.Reader) string {
+	var body struct {
+		Detail any `json:"detail"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r, 64<<10)).Decode(&body); err != nil || body.Detail == nil {
+		return ""
+	}
+	return fmt.Sprintf(": %v", body.Detail)
+}
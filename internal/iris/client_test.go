@@ -0,0 +1,289 @@
+package iris_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/iris/iristest"
+)
+
+const agentUUID = "ffffffff-0000-0000-0000-000000000001"
+
+func measurementUUID(i int) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", i)
+}
+
+// newTestClient starts a stand-in and returns a client logged in to it.
+func newTestClient(t *testing.T) (*iris.IrisClient, *iristest.Server) {
+	t.Helper()
+	srv := iristest.NewServer()
+	t.Cleanup(srv.Close)
+	client, err := iris.NewIrisClient(srv.Config())
+	if err != nil {
+		t.Fatalf("NewIrisClient: %v", err)
+	}
+	return client, srv
+}
+
+func TestLogin(t *testing.T) {
+	client, srv := newTestClient(t)
+	if got := srv.Stats().Logins; got != 1 {
+		t.Errorf("logins = %d, want 1", got)
+	}
+
+	if err := client.Logout(); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if got := srv.Stats().Logouts; got != 1 {
+		t.Errorf("logouts = %d, want 1", got)
+	}
+	if err := client.Logout(); err == nil {
+		t.Error("second Logout succeeded, want an error")
+	}
+}
+
+func TestLoginBadCredentials(t *testing.T) {
+	srv := iristest.NewServer()
+	defer srv.Close()
+
+	cfg := srv.Config()
+	cfg.Password = "wrong"
+	if _, err := iris.NewIrisClient(cfg); err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("NewIrisClient error = %v, want a login failure with status 400", err)
+	}
+	if got := srv.Stats().FailedLogins; got != 1 {
+		t.Errorf("failed logins = %d, want 1", got)
+	}
+
+	cfg = srv.Config()
+	cfg.Username = ""
+	if _, err := iris.NewIrisClient(cfg); err == nil {
+		t.Error("NewIrisClient without username succeeded, want an error")
+	}
+}
+
+func TestReloginOnUnauthorized(t *testing.T) {
+	client, srv := newTestClient(t)
+	created := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	srv.AddMeasurement(iristest.Measurement(measurementUUID(1), created, iris.StateFinished, []string{"zeph"}, agentUUID))
+
+	// The token expired: the request is rejected once, the client logs in
+	// again and retries with the new token.
+	srv.RevokeTokens()
+	m, err := client.Measurement(measurementUUID(1))
+	if err != nil {
+		t.Fatalf("Measurement: %v", err)
+	}
+	if m.UUID != measurementUUID(1) || len(m.Agents) != 1 || m.Agents[0].AgentUUID != agentUUID {
+		t.Errorf("Measurement = %+v, want %s with agent %s", m, measurementUUID(1), agentUUID)
+	}
+	stats := srv.Stats()
+	if stats.Unauthorized != 1 || stats.Logins != 2 {
+		t.Errorf("unauthorized = %d, logins = %d; want 1 and 2", stats.Unauthorized, stats.Logins)
+	}
+}
+
+func TestMeasurementNotFound(t *testing.T) {
+	client, _ := newTestClient(t)
+	_, err := client.Measurement(measurementUUID(404))
+	if err == nil || !strings.Contains(err.Error(), "status 404") || !strings.Contains(err.Error(), "Measurement not found") {
+		t.Errorf("Measurement error = %v, want a 404 with the API detail", err)
+	}
+}
+
+func TestMeasurementsPaging(t *testing.T) {
+	client, srv := newTestClient(t)
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	const n = 450
+	for i := range n {
+		srv.AddMeasurement(iristest.Measurement(measurementUUID(i), start.Add(time.Duration(i)*time.Minute), iris.StateFinished, []string{"zeph"}))
+	}
+
+	found, err := client.Measurements().State(iris.StateFinished).Fetch()
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(found) != n {
+		t.Errorf("got %d measurements, want %d", len(found), n)
+	}
+	uuids := make(map[string]bool, len(found))
+	for _, m := range found {
+		uuids[m.UUID] = true
+	}
+	if len(uuids) != n {
+		t.Errorf("got %d distinct measurements, want %d", len(uuids), n)
+	}
+	// Pages of 200: 200, 200 and 50.
+	if got := srv.Stats().Listings; got != 3 {
+		t.Errorf("listings = %d, want 3 pages", got)
+	}
+}
+
+func TestMeasurementsByState(t *testing.T) {
+	client, srv := newTestClient(t)
+	created := time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, state := range iris.AllMeasurementStates {
+		srv.AddMeasurement(iristest.Measurement(measurementUUID(i), created.Add(time.Duration(i)*time.Hour), state, []string{"zeph"}))
+	}
+
+	// Without a state, every state is listed.
+	all, err := client.Measurements().Fetch()
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(all) != len(iris.AllMeasurementStates) {
+		t.Errorf("got %d measurements, want %d", len(all), len(iris.AllMeasurementStates))
+	}
+	if got := srv.Stats().Listings; got != len(iris.AllMeasurementStates) {
+		t.Errorf("listings = %d, want one per state", got)
+	}
+
+	for i, state := range iris.AllMeasurementStates {
+		found, err := client.Measurements().State(state).Fetch()
+		if err != nil {
+			t.Fatalf("Fetch(%s): %v", state, err)
+		}
+		if len(found) != 1 || found[0].UUID != measurementUUID(i) || found[0].State != state {
+			t.Errorf("Fetch(%s) = %v, want only %s", state, found, measurementUUID(i))
+		}
+	}
+}
+
+func TestMeasurementsFilters(t *testing.T) {
+	client, srv := newTestClient(t)
+	day := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	srv.AddMeasurement(iristest.Measurement(measurementUUID(1), day.Add(-time.Hour), iris.StateFinished, []string{"zeph"}))
+	srv.AddMeasurement(iristest.Measurement(measurementUUID(2), day.Add(time.Hour), iris.StateFinished, []string{"zeph"}))
+	srv.AddMeasurement(iristest.Measurement(measurementUUID(3), day.Add(2*time.Hour), iris.StateFinished, []string{"ipv6"}))
+	srv.AddMeasurement(iristest.Measurement(measurementUUID(4), day.Add(3*time.Hour), iris.StateFinished, []string{"zeph-test"}))
+	srv.AddMeasurement(iristest.Measurement(measurementUUID(5), day.Add(25*time.Hour), iris.StateFinished, []string{"zeph"}))
+
+	tests := []struct {
+		name  string
+		query func() *iris.MeasurementQueryBuilder
+		want  []int
+	}{
+		{"between", func() *iris.MeasurementQueryBuilder {
+			return client.Measurements().Between(day, day.Add(24*time.Hour))
+		}, []int{2, 3, 4}},
+		{"exact tag", func() *iris.MeasurementQueryBuilder {
+			return client.Measurements().Tag("zeph")
+		}, []int{1, 2, 5}},
+		{"tag pattern", func() *iris.MeasurementQueryBuilder {
+			return client.Measurements().TagContains("^zeph")
+		}, []int{1, 2, 4, 5}},
+		{"between and exact tag", func() *iris.MeasurementQueryBuilder {
+			return client.Measurements().Between(day, day.Add(24*time.Hour)).Tag("zeph")
+		}, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := tt.query().State(iris.StateFinished).Fetch()
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			var got []string
+			for _, m := range found {
+				got = append(got, m.UUID)
+			}
+			slices.Sort(got)
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, measurementUUID(i))
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := client.Measurements().TagContains("(").Fetch(); err == nil {
+		t.Error("Fetch with an invalid tag pattern succeeded, want an error")
+	}
+}
+
+// readRows decodes a JSONEachRow response, gzip-compressed or not.
+func readRows(t *testing.T, body io.ReadCloser) []map[string]any {
+	t.Helper()
+	defer body.Close()
+	r := bufio.NewReader(body)
+	var src io.Reader = r
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		src = gz
+	}
+	var rows []map[string]any
+	dec := json.NewDecoder(src)
+	for {
+		var row map[string]any
+		if err := dec.Decode(&row); err == io.EOF {
+			return rows
+		} else if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.AddRows("results", iristest.Row{"ttl": 3, "addr": "b"}, iristest.Row{"ttl": 1, "addr": "c"}, iristest.Row{"ttl": 2, "addr": "a"})
+
+	body, err := client.Query().Select("SELECT ttl, addr FROM results ORDER BY ttl DESC LIMIT 2 OFFSET 1").Json()
+	if err != nil {
+		t.Fatalf("Json: %v", err)
+	}
+	rows := readRows(t, body)
+	if len(rows) != 2 || rows[0]["addr"] != "a" || rows[1]["addr"] != "c" {
+		t.Errorf("rows = %v, want addr a then c", rows)
+	}
+	if got := srv.Queries(); len(got) != 1 || !strings.HasSuffix(got[0], " FORMAT JSONEachRow") {
+		t.Errorf("queries = %q, want one in JSONEachRow", got)
+	}
+
+	if _, err := client.Query().Select("SELECT ttl FROM missing").Json(); err == nil || !strings.Contains(err.Error(), "UNKNOWN_TABLE") {
+		t.Errorf("query of a missing table error = %v, want UNKNOWN_TABLE", err)
+	}
+}
+
+func TestQueryRefreshesCredentials(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.AddRows("results", iristest.Row{"ttl": 1})
+	srv.SetCredentialTTL(200 * time.Millisecond)
+
+	query := func() {
+		t.Helper()
+		body, err := client.Query().Select("SELECT count() AS count FROM results").Json()
+		if err != nil {
+			t.Fatalf("Json: %v", err)
+		}
+		if rows := readRows(t, body); len(rows) != 1 || rows[0]["count"] != float64(1) {
+			t.Errorf("rows = %v, want a count of 1", rows)
+		}
+	}
+
+	// Credentials are cached until they expire.
+	query()
+	query()
+	if got := srv.Stats().ServiceLookups; got != 1 {
+		t.Errorf("service lookups = %d, want 1 while the credentials are valid", got)
+	}
+
+	// Expired credentials are fetched again before the next query.
+	time.Sleep(250 * time.Millisecond)
+	query()
+	if got := srv.Stats().ServiceLookups; got != 2 {
+		t.Errorf("service lookups = %d, want 2 after the credentials expired", got)
+	}
+}
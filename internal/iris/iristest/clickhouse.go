@@ -0,0 +1,455 @@
+package iristest
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+)
+
+// Row is a canned result row, by column name. Values are encoded as JSON.
+type Row map[string]any
+
+// AddRows appends rows to a table served by the query endpoint, creating the
+// table if needed. Rows are returned in insertion order.
+func (s *Server) AddRows(table string, rows ...Row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[table] = append(s.tables[table], rows...)
+}
+
+// AddResults appends rows to the results table of a measurement agent.
+func (s *Server) AddResults(measurementUUID, agentUUID string, rows ...Row) {
+	t := iris.NewIrisTableGroup(measurementUUID, agentUUID, iris.IrisTime{}).Results
+	s.AddRows(t.TableName, rows...)
+}
+
+// ResultRows returns n rows of the lite results schema probed from src to a
+// single destination, with probe TTLs n down to 1: reading them in the
+// order of the results table sorting key differs from insertion order.
+func ResultRows(n int, src string) []Row {
+	rows := make([]Row, 0, n)
+	for ttl := n; ttl >= 1; ttl-- {
+		rows = append(rows, Row{
+			"capture_timestamp": "2026-06-01 10:00:00",
+			"probe_protocol":    1,
+			"probe_src_addr":    src,
+			"probe_dst_prefix":  "::ffff:198.51.100.0",
+			"probe_dst_addr":    "::ffff:198.51.100.1",
+			"probe_src_port":    24000,
+			"probe_dst_port":    0,
+			"probe_ttl":         ttl,
+			"reply_src_addr":    fmt.Sprintf("::ffff:203.0.113.%d", ttl),
+			"rtt":               10 * ttl,
+		})
+	}
+	return rows
+}
+
+// The query endpoint understands the SQL subset the services send to Iris:
+//
+//	SELECT <expr> [AS <alias>], ... FROM [<database>.]<table>
+//	[WHERE <cond> AND ...] [ORDER BY <column> [ASC|DESC], ...]
+//	[LIMIT <n> [OFFSET <m>]] [FORMAT JSONEachRow]
+//
+// where an expression is a column name, *, count(), a string literal or
+// toUUID('<literal>'), and a condition is [NOT] startsWith(toString(<column>),
+// '<prefix>'). ORDER BY compares numbers numerically and other values as
+// strings, so IP addresses are not in ClickHouse order, but the order is
+// stable across queries as it is in ClickHouse. Anything else is rejected with a ClickHouse-like error, so that
+// a test fails loudly instead of silently reading the wrong rows.
+var (
+	selectPattern = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s+([\w.]+)(?:\s+WHERE\s+(.+?))?(?:\s+ORDER\s+BY\s+(.+?))?(?:\s+LIMIT\s+(\d+)(?:\s+OFFSET\s+(\d+))?)?\s*$`)
+	formatPattern = regexp.MustCompile(`(?is)\s+FORMAT\s+(\w+)\s*;?\s*$`)
+	aliasPattern  = regexp.MustCompile(`(?is)^(.+?)\s+AS\s+(\w+)$`)
+	identPattern  = regexp.MustCompile(`^\w+$`)
+	literalExpr   = regexp.MustCompile(`^(?:toUUID\()?'([^']*)'\)?$`)
+	orderPattern  = regexp.MustCompile(`(?i)^(\w+)(?:\s+(ASC|DESC))?$`)
+	condPattern   = regexp.MustCompile(`(?i)^(NOT\s+)?startsWith\(toString\((\w+)\),\s*'([^']*)'\)$`)
+	andPattern    = regexp.MustCompile(`(?i)\s+AND\s+`)
+)
+
+// queryError is an error reported like ClickHouse does, with a code and name.
+type queryError struct {
+	status int
+	code   int
+	name   string
+	msg    string
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("Code: %d. DB::Exception: %s. (%s)", e.code, e.msg, e.name)
+}
+
+func unsupported(format string, args ...any) *queryError {
+	return &queryError{
+		status: http.StatusBadRequest,
+		code:   48,
+		name:   "NOT_IMPLEMENTED",
+		msg:    "iristest: " + fmt.Sprintf(format, args...),
+	}
+}
+
+// column is a parsed select expression.
+type column struct {
+	name     string // output name
+	source   string // source column, if the expression is a column
+	constant *string
+	count    bool
+}
+
+// condition is a parsed WHERE condition.
+type condition struct {
+	negate bool
+	column string
+	prefix string
+}
+
+func (c condition) match(row Row) bool {
+	return strings.HasPrefix(fmt.Sprint(row[c.column]), c.prefix) != c.negate
+}
+
+// orderKey is a parsed ORDER BY element.
+type orderKey struct {
+	column     string
+	descending bool
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	sql := r.URL.Query().Get("query")
+	username, password, _ := r.BasicAuth()
+
+	s.mu.Lock()
+	s.stats.Queries++
+	s.queries = append(s.queries, sql)
+	cred, ok := s.credentials[username]
+	onQuery := s.onQuery
+	s.mu.Unlock()
+
+	if onQuery != nil {
+		onQuery(sql)
+	}
+
+	if !ok || cred.password != password || time.Now().After(cred.expiresAt) {
+		writeQueryError(w, &queryError{
+			status: http.StatusForbidden,
+			code:   516,
+			name:   "AUTHENTICATION_FAILED",
+			msg:    fmt.Sprintf("%s: Authentication failed: password is incorrect, or there is no user with such name", username),
+		})
+		return
+	}
+
+	format := "TabSeparated"
+	if m := formatPattern.FindStringSubmatch(sql); m != nil {
+		format = m[1]
+		sql = sql[:len(sql)-len(m[0])]
+	}
+	if format != "JSONEachRow" {
+		writeQueryError(w, unsupported("format %s is not supported", format))
+		return
+	}
+
+	m := selectPattern.FindStringSubmatch(sql)
+	if m == nil {
+		writeQueryError(w, unsupported("cannot parse query %q", sql))
+		return
+	}
+	columns, err := parseColumns(m[1])
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	conds, err := parseConditions(m[3])
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	order, err := parseOrder(m[4])
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	limit, offset := -1, 0
+	if m[5] != "" {
+		limit, _ = strconv.Atoi(m[5])
+	}
+	if m[6] != "" {
+		offset, _ = strconv.Atoi(m[6])
+	}
+
+	table := m[2]
+	if db, name, found := strings.Cut(table, "."); found {
+		if db != r.URL.Query().Get("database") && db != clickhouseDatabase {
+			writeQueryError(w, &queryError{status: http.StatusNotFound, code: 81, name: "UNKNOWN_DATABASE", msg: fmt.Sprintf("Database %s does not exist", db)})
+			return
+		}
+		table = name
+	}
+	var rows []Row
+	s.mu.Lock()
+	stored, exists := s.tables[table]
+	for _, row := range stored {
+		if matchAll(conds, row) {
+			rows = append(rows, row)
+		}
+	}
+	s.mu.Unlock()
+	if !exists {
+		writeQueryError(w, &queryError{status: http.StatusNotFound, code: 60, name: "UNKNOWN_TABLE", msg: fmt.Sprintf("Table %s.%s does not exist", clickhouseDatabase, table)})
+		return
+	}
+
+	if err := sortRows(rows, order); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	body, qerr := render(columns, rows, limit, offset)
+	if qerr != nil {
+		writeQueryError(w, qerr)
+		return
+	}
+
+	// ClickHouse compresses responses only when both sides ask for it.
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+	w.Header().Set("X-ClickHouse-Format", format)
+	if r.URL.Query().Get("enable_http_compression") == "1" && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write(body)
+		_ = gz.Close()
+		return
+	}
+	_, _ = w.Write(body)
+}
+
+func parseColumns(list string) ([]column, *queryError) {
+	var columns []column
+	for _, expr := range splitTopLevel(list) {
+		name := expr
+		if m := aliasPattern.FindStringSubmatch(expr); m != nil {
+			expr, name = strings.TrimSpace(m[1]), m[2]
+		}
+		switch {
+		case expr == "*":
+			columns = append(columns, column{name: "*"})
+		case strings.EqualFold(expr, "count()"):
+			columns = append(columns, column{name: name, count: true})
+		case identPattern.MatchString(expr):
+			columns = append(columns, column{name: name, source: expr})
+		default:
+			lit := literalExpr.FindStringSubmatch(expr)
+			if lit == nil {
+				return nil, unsupported("expression %q is not supported", expr)
+			}
+			columns = append(columns, column{name: name, constant: &lit[1]})
+		}
+	}
+	hasCount := slices.ContainsFunc(columns, func(c column) bool { return c.count })
+	if hasCount && len(columns) > 1 {
+		return nil, unsupported("count() must be the only selected expression")
+	}
+	return columns, nil
+}
+
+func parseConditions(where string) ([]condition, *queryError) {
+	if where == "" {
+		return nil, nil
+	}
+	var conds []condition
+	for _, part := range andPattern.Split(strings.TrimSpace(where), -1) {
+		m := condPattern.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, unsupported("condition %q is not supported", part)
+		}
+		conds = append(conds, condition{negate: m[1] != "", column: m[2], prefix: m[3]})
+	}
+	return conds, nil
+}
+
+func parseOrder(list string) ([]orderKey, *queryError) {
+	if list == "" {
+		return nil, nil
+	}
+	var order []orderKey
+	for _, part := range splitTopLevel(list) {
+		m := orderPattern.FindStringSubmatch(part)
+		if m == nil {
+			return nil, unsupported("ORDER BY expression %q is not supported", part)
+		}
+		order = append(order, orderKey{column: m[1], descending: strings.EqualFold(m[2], "DESC")})
+	}
+	return order, nil
+}
+
+// sortRows sorts rows in place by the order keys. Rows must have every
+// ordering column.
+func sortRows(rows []Row, order []orderKey) *queryError {
+	for _, k := range order {
+		for _, row := range rows {
+			if _, ok := row[k.column]; !ok {
+				return &queryError{status: http.StatusNotFound, code: 47, name: "UNKNOWN_IDENTIFIER", msg: fmt.Sprintf("Missing columns: '%s' while processing query", k.column)}
+			}
+		}
+	}
+	slices.SortStableFunc(rows, func(a, b Row) int {
+		for _, k := range order {
+			c := compareValues(a[k.column], b[k.column])
+			if k.descending {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return nil
+}
+
+// compareValues compares two column values, numerically if both are
+// numbers and by their string form otherwise.
+func compareValues(a, b any) int {
+	x, xok := number(a)
+	y, yok := number(b)
+	if xok && yok {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func matchAll(conds []condition, row Row) bool {
+	for _, c := range conds {
+		if !c.match(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// render encodes the selected columns of rows[offset:offset+limit] as
+// JSONEachRow, keeping the column order of the query.
+func render(columns []column, rows []Row, limit, offset int) ([]byte, *queryError) {
+	var buf bytes.Buffer
+	if len(columns) == 1 && columns[0].count {
+		fmt.Fprintf(&buf, "{%q:%d}\n", columns[0].name, len(rows))
+		return buf.Bytes(), nil
+	}
+
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+	if limit >= 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	for _, row := range rows {
+		buf.WriteByte('{')
+		first := true
+		write := func(name string, v any) *queryError {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return unsupported("cannot encode column %s: %v", name, err)
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			fmt.Fprintf(&buf, "%q:%s", name, b)
+			return nil
+		}
+		for _, c := range columns {
+			var err *queryError
+			switch {
+			case c.name == "*":
+				keys := make([]string, 0, len(row))
+				for k := range row {
+					keys = append(keys, k)
+				}
+				slices.Sort(keys)
+				for _, k := range keys {
+					if err = write(k, row[k]); err != nil {
+						break
+					}
+				}
+			case c.constant != nil:
+				err = write(c.name, *c.constant)
+			default:
+				v, ok := row[c.source]
+				if !ok {
+					return nil, &queryError{status: http.StatusNotFound, code: 47, name: "UNKNOWN_IDENTIFIER", msg: fmt.Sprintf("Missing columns: '%s' while processing query", c.source)}
+				}
+				err = write(c.name, v)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// splitTopLevel splits a select list on the commas outside parentheses and
+// string literals.
+func splitTopLevel(list string) []string {
+	var (
+		parts   []string
+		depth   int
+		quoted  bool
+		current strings.Builder
+	)
+	for _, c := range list {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(current.String()))
+			current.Reset()
+			continue
+		}
+		current.WriteRune(c)
+	}
+	return append(parts, strings.TrimSpace(current.String()))
+}
+
+func writeQueryError(w http.ResponseWriter, err *queryError) {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("X-ClickHouse-Exception-Code", strconv.Itoa(err.code))
+	w.WriteHeader(err.status)
+	_, _ = fmt.Fprintln(w, err.Error())
+}
@@ -0,0 +1,543 @@
+// Package iristest provides an in-memory stand-in for the Iris API and its
+// ClickHouse HTTP interface, for end-to-end testing of the iris client and of
+// the services and commands built on it.
+//
+// A Server implements the endpoints used by iris.IrisClient: JWT login and
+// logout, paginated measurement listing, single measurement lookup, target
+// file upload, measurement creation and cancellation, and the external
+// services endpoint issuing expiring ClickHouse credentials. Results
+// tables are served from canned rows by a minimal ClickHouse-like query
+// endpoint, see clickhouse.go for the supported SQL subset.
+package iristest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+)
+
+const (
+	DefaultUsername      = "iristest@example.org"
+	DefaultPassword      = "iristest"
+	DefaultCredentialTTL = time.Hour
+
+	// clickhouseDatabase is the database reported in the issued credentials.
+	clickhouseDatabase = "iris"
+)
+
+// Stats counts the requests served by a Server.
+type Stats struct {
+	Logins         int // successful logins
+	FailedLogins   int // logins with bad credentials
+	Logouts        int
+	Unauthorized   int // API requests rejected with 401
+	Listings       int // pages served by GET /measurements/
+	ServiceLookups int // calls to /users/me/services
+	Queries        int // ClickHouse queries, including rejected ones
+}
+
+// Server is a running Iris stand-in. Its methods are safe for concurrent use.
+type Server struct {
+	// URL is the base URL of the API, to be used as iris.Config.Endpoint.
+	URL string
+
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	username      string
+	password      string
+	tokens        map[string]bool
+	credentials   map[string]clickhouseCredential // by username
+	credentialTTL time.Duration
+	measurements  []iris.MeasurementReadWithAgents
+	agents        map[string][]string // tags by agent UUID
+	targets       map[string][]byte   // target files by key
+	tables        map[string][]Row
+	queries       []string
+	onQuery       func(sql string)
+	stats         Stats
+}
+
+type clickhouseCredential struct {
+	password  string
+	expiresAt time.Time
+}
+
+// NewServer starts a Server accepting DefaultUsername and DefaultPassword.
+// The caller must call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		username:      DefaultUsername,
+		password:      DefaultPassword,
+		tokens:        make(map[string]bool),
+		credentials:   make(map[string]clickhouseCredential),
+		credentialTTL: DefaultCredentialTTL,
+		agents:        make(map[string][]string),
+		targets:       make(map[string][]byte),
+		tables:        make(map[string][]Row),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /auth/jwt/login", s.handleLogin)
+	mux.HandleFunc("POST /auth/jwt/logout", s.handleLogout)
+	mux.HandleFunc("GET /measurements/", s.handleMeasurements)
+	mux.HandleFunc("POST /measurements/", s.handleCreateMeasurement)
+	mux.HandleFunc("GET /measurements/{uuid}", s.handleMeasurement)
+	mux.HandleFunc("DELETE /measurements/{uuid}", s.handleCancelMeasurement)
+	mux.HandleFunc("POST /targets/", s.handleUploadTarget)
+	mux.HandleFunc("GET /users/me/services", s.handleServices)
+	mux.HandleFunc("/clickhouse/", s.handleQuery)
+
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Config returns an iris.Config pointing at the server with valid credentials.
+func (s *Server) Config() iris.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return iris.Config{
+		Username: s.username,
+		Password: s.password,
+		Endpoint: s.URL,
+	}
+}
+
+// SetCredentialTTL sets the lifetime of the ClickHouse credentials issued
+// from now on.
+func (s *Server) SetCredentialTTL(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentialTTL = d
+}
+
+// OnQuery sets a function called with each ClickHouse query before it is
+// answered, e.g. to cancel a context after some chunks.
+func (s *Server) OnQuery(f func(sql string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onQuery = f
+}
+
+// RevokeTokens invalidates every JWT issued so far, as if they had expired.
+// Subsequent API requests are rejected with 401 until the client logs in again.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.tokens)
+}
+
+// ExpireCredentials invalidates every ClickHouse credential issued so far.
+func (s *Server) ExpireCredentials() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.credentials)
+}
+
+// AddMeasurement registers a measurement. Measurements are listed newest
+// first by creation time, as the Iris API does.
+func (s *Server) AddMeasurement(m iris.MeasurementReadWithAgents) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.measurements = append(s.measurements, m)
+	slices.SortStableFunc(s.measurements, func(a, b iris.MeasurementReadWithAgents) int {
+		return b.CreationTime.Compare(a.CreationTime.Time)
+	})
+}
+
+// SetMeasurementState sets the state of a measurement and of all its agents.
+// It reports whether the measurement exists.
+func (s *Server) SetMeasurementState(uuid string, state iris.MeasurementAgentState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.measurements {
+		m := &s.measurements[i]
+		if m.UUID != uuid {
+			continue
+		}
+		m.State = state
+		for j := range m.Agents {
+			m.Agents[j].State = state
+		}
+		return true
+	}
+	return false
+}
+
+// AddAgent registers an agent that measurements can be scheduled on, by UUID
+// or by any of its tags.
+func (s *Server) AddAgent(uuid string, tags ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agents[uuid] = tags
+}
+
+// TargetFile returns the content of an uploaded target file.
+func (s *Server) TargetFile(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.targets[key]
+	return slices.Clone(b), ok
+}
+
+// Stats returns the request counters.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Queries returns the SQL of every ClickHouse query received, in order.
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.queries)
+}
+
+// Measurement builds a measurement with one agent per agent UUID, all in the
+// given state.
+func Measurement(uuid string, created time.Time, state iris.MeasurementAgentState, tags []string, agentUUIDs ...string) iris.MeasurementReadWithAgents {
+	m := iris.MeasurementReadWithAgents{
+		UUID:         uuid,
+		Tool:         iris.ToolDiamondMiner,
+		Tags:         tags,
+		UserID:       "00000000-0000-0000-0000-000000000000",
+		CreationTime: iris.IrisTime{Time: created.UTC()},
+		State:        state,
+	}
+	for _, a := range agentUUIDs {
+		m.Agents = append(m.Agents, iris.MeasurementAgentRead{
+			AgentUUID: a,
+			State:     state,
+		})
+	}
+	return m
+}
+
+// ── Handlers ─────────────────────────────────────────────────────────────────
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid form")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.PostForm.Get("username") != s.username || r.PostForm.Get("password") != s.password {
+		s.stats.FailedLogins++
+		writeError(w, http.StatusBadRequest, "LOGIN_BAD_CREDENTIALS")
+		return
+	}
+	s.stats.Logins++
+	token := randomHex(16)
+	s.tokens[token] = true
+	writeJSON(w, iris.BearerResponse{AccessToken: token, TokenType: "bearer"})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, bearerToken(r))
+	s.stats.Logouts++
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleMeasurements(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	limit, err := intParam(q.Get("limit"), 200)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid limit")
+		return
+	}
+	offset, err := intParam(q.Get("offset"), 0)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid offset")
+		return
+	}
+	state := iris.MeasurementAgentState(q.Get("state"))
+	tag := q.Get("tag")
+
+	s.mu.Lock()
+	s.stats.Listings++
+	var matching []iris.MeasurementRead
+	for _, m := range s.measurements {
+		if state != "" && m.State != state {
+			continue
+		}
+		if tag != "" && !slices.Contains(m.Tags, tag) {
+			continue
+		}
+		matching = append(matching, lite(m))
+	}
+	s.mu.Unlock()
+
+	page := iris.Paginated[iris.MeasurementRead]{
+		Count:   len(matching),
+		Results: []iris.MeasurementRead{},
+	}
+	if offset < len(matching) {
+		page.Results = matching[offset:min(offset+limit, len(matching))]
+	}
+	writeJSON(w, page)
+}
+
+func (s *Server) handleMeasurement(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	uuid := r.PathValue("uuid")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.measurements {
+		if m.UUID == uuid {
+			writeJSON(w, m)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Measurement not found")
+}
+
+func (s *Server) handleUploadTarget(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	file, header, err := r.FormFile("target_file")
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "target_file is required")
+		return
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read target_file")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets[header.Filename] = content
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(iris.TargetUpload{Key: header.Filename, Action: "upload"})
+}
+
+func (s *Server) handleCreateMeasurement(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	var req iris.MeasurementCreate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid measurement")
+		return
+	}
+	if !slices.Contains([]iris.Tool{iris.ToolDiamondMiner, iris.ToolYarrp, iris.ToolPing, iris.ToolProbes}, req.Tool) {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("unknown tool %q", req.Tool))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := iris.MeasurementReadWithAgents{
+		UUID:         newUUID(),
+		Tool:         req.Tool,
+		Tags:         req.Tags,
+		UserID:       "00000000-0000-0000-0000-000000000000",
+		CreationTime: iris.IrisTime{Time: time.Now().UTC()},
+		State:        iris.StateCreated,
+	}
+	for _, a := range req.Agents {
+		if _, ok := s.targets[a.TargetFile]; !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Target file %s not found", a.TargetFile))
+			return
+		}
+		var uuids []string
+		switch {
+		case a.UUID != "":
+			if _, ok := s.agents[a.UUID]; ok {
+				uuids = []string{a.UUID}
+			}
+		case a.Tag != "":
+			for uuid, tags := range s.agents {
+				if slices.Contains(tags, a.Tag) {
+					uuids = append(uuids, uuid)
+				}
+			}
+			slices.Sort(uuids)
+		}
+		if len(uuids) == 0 {
+			writeError(w, http.StatusNotFound, "No agent found")
+			return
+		}
+		for _, uuid := range uuids {
+			m.Agents = append(m.Agents, iris.MeasurementAgentRead{
+				TargetFile:  a.TargetFile,
+				AgentUUID:   uuid,
+				State:       iris.StateCreated,
+				ProbingRate: a.ProbingRate,
+				BatchSize:   a.BatchSize,
+			})
+		}
+	}
+
+	s.measurements = append([]iris.MeasurementReadWithAgents{m}, s.measurements...)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+func (s *Server) handleCancelMeasurement(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	uuid := r.PathValue("uuid")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.measurements {
+		m := &s.measurements[i]
+		if m.UUID != uuid {
+			continue
+		}
+		if m.State.IsTerminal() {
+			writeError(w, http.StatusBadRequest, "Measurement already ended")
+			return
+		}
+		m.State = iris.StateCanceled
+		for j := range m.Agents {
+			m.Agents[j].State = iris.StateCanceled
+		}
+		writeJSON(w, map[string]string{"id": uuid, "action": "cancel"})
+		return
+	}
+	writeError(w, http.StatusNotFound, "Measurement not found")
+}
+
+func (s *Server) handleServices(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.ServiceLookups++
+
+	// Every lookup issues a new ClickHouse user, as Iris does.
+	username := "iristest_" + randomHex(4)
+	cred := clickhouseCredential{
+		password:  randomHex(16),
+		expiresAt: time.Now().Add(s.credentialTTL).UTC(),
+	}
+	s.credentials[username] = cred
+
+	writeJSON(w, iris.ExternalServices{
+		ClickHouse: iris.ClickHouseCredentials{
+			BaseURL:  s.URL + "/clickhouse/",
+			Database: clickhouseDatabase,
+			Username: username,
+			Password: cred.password,
+		},
+		ClickHouseExpirationTime: iris.IrisTime{Time: cred.expiresAt},
+		S3ExpirationTime:         iris.IrisTime{Time: cred.expiresAt},
+	})
+}
+
+// authorize checks the bearer token and writes a 401 if it is not valid.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens[bearerToken(r)] {
+		return true
+	}
+	s.stats.Unauthorized++
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+	return false
+}
+
+// ── Helpers ──────────────────────────────────────────────────────────────────
+
+// lite converts a measurement to its list representation.
+func lite(m iris.MeasurementReadWithAgents) iris.MeasurementRead {
+	agents := make([]iris.MeasurementAgentReadLite, 0, len(m.Agents))
+	for _, a := range m.Agents {
+		agents = append(agents, iris.MeasurementAgentReadLite{AgentUUID: a.AgentUUID})
+	}
+	return iris.MeasurementRead{
+		UUID:         m.UUID,
+		Tool:         m.Tool,
+		Tags:         m.Tags,
+		UserID:       m.UserID,
+		CreationTime: m.CreationTime,
+		StartTime:    m.StartTime,
+		EndTime:      m.EndTime,
+		State:        m.State,
+		Agents:       agents,
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func intParam(s string, fallback int) (int, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the FastAPI format used by Iris.
+func writeError(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"detail": detail})
+}
@@ -0,0 +1,80 @@
+package iris
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+)
+
+// TargetUpload is the response of a target file upload.
+type TargetUpload struct {
+	Key    string `json:"key"`
+	Action string `json:"action"`
+}
+
+// MeasurementAgentCreate selects the agents of a new measurement, either by
+// UUID or by tag, and the target list they probe.
+type MeasurementAgentCreate struct {
+	UUID           string         `json:"uuid,omitempty"`
+	Tag            string         `json:"tag,omitempty"`
+	TargetFile     string         `json:"target_file"`
+	ProbingRate    *int           `json:"probing_rate,omitempty"`
+	BatchSize      *int           `json:"batch_size,omitempty"`
+	ToolParameters map[string]any `json:"tool_parameters,omitempty"`
+}
+
+// MeasurementCreate is the request body of a measurement creation.
+type MeasurementCreate struct {
+	Tool   Tool                     `json:"tool"`
+	Agents []MeasurementAgentCreate `json:"agents"`
+	Tags   []string                 `json:"tags"`
+}
+
+// UploadTargetFile uploads a target list to Iris under the given name and
+// returns its key, to be used as MeasurementAgentCreate.TargetFile. Iris
+// expects one target per line: prefix,protocol,min_ttl,max_ttl,n_initial_flows.
+func (c *IrisClient) UploadTargetFile(name string, r io.Reader) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("target_file", filepath.Base(name))
+	if err != nil {
+		return "", fmt.Errorf("iris: failed to build target upload: %w", err)
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return "", fmt.Errorf("iris: failed to read target file: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("iris: failed to build target upload: %w", err)
+	}
+
+	var upload TargetUpload
+	if err := c.send(http.MethodPost, "/targets/", nil, w.FormDataContentType(), body.Bytes(), &upload, true); err != nil {
+		return "", fmt.Errorf("iris: failed to upload target file %s: %w", name, err)
+	}
+	return upload.Key, nil
+}
+
+// CreateMeasurement creates a measurement and returns it, including its UUID
+// and the agents it was scheduled on.
+func (c *IrisClient) CreateMeasurement(m MeasurementCreate) (MeasurementReadWithAgents, error) {
+	if len(m.Agents) == 0 {
+		return MeasurementReadWithAgents{}, fmt.Errorf("iris: a measurement requires at least one agent")
+	}
+	var created MeasurementReadWithAgents
+	if err := c.postJSON("/measurements/", m, &created); err != nil {
+		return MeasurementReadWithAgents{}, fmt.Errorf("iris: failed to create measurement: %w", err)
+	}
+	return created, nil
+}
+
+// CancelMeasurement cancels a measurement on all its agents.
+func (c *IrisClient) CancelMeasurement(uuid string) error {
+	if err := c.send(http.MethodDelete, "/measurements/"+url.PathEscape(uuid), nil, "", nil, nil, true); err != nil {
+		return fmt.Errorf("iris: failed to cancel measurement %s: %w", uuid, err)
+	}
+	return nil
+}
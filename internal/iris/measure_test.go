@@ -0,0 +1,242 @@
+package iris_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+)
+
+const targets = "192.0.2.0/24,icmp,2,32,6\n198.51.100.0/24,icmp,2,32,6\n"
+
+func TestUploadTargetFile(t *testing.T) {
+	client, srv := newTestClient(t)
+
+	// The file is uploaded under its base name, which is the key.
+	key, err := client.UploadTargetFile("/tmp/lists/zeph.csv", strings.NewReader(targets))
+	if err != nil {
+		t.Fatalf("UploadTargetFile: %v", err)
+	}
+	if key != "zeph.csv" {
+		t.Errorf("key = %q, want zeph.csv", key)
+	}
+	if content, ok := srv.TargetFile(key); !ok || string(content) != targets {
+		t.Errorf("uploaded content = %q, want %q", content, targets)
+	}
+
+	// After the token expired, the upload is sent again in full.
+	srv.RevokeTokens()
+	if _, err := client.UploadTargetFile("again.csv", strings.NewReader(targets)); err != nil {
+		t.Fatalf("UploadTargetFile after the token expired: %v", err)
+	}
+	if content, _ := srv.TargetFile("again.csv"); string(content) != targets {
+		t.Errorf("re-sent content = %q, want %q", content, targets)
+	}
+	if stats := srv.Stats(); stats.Unauthorized != 1 || stats.Logins != 2 {
+		t.Errorf("unauthorized = %d, logins = %d; want 1 and 2", stats.Unauthorized, stats.Logins)
+	}
+}
+
+func TestCreateMeasurement(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.AddAgent("ffffffff-0000-0000-0000-00000000000b", "all", "eu")
+	srv.AddAgent("ffffffff-0000-0000-0000-00000000000a", "all")
+	srv.AddAgent("ffffffff-0000-0000-0000-00000000000c")
+	if _, err := client.UploadTargetFile("zeph.csv", strings.NewReader(targets)); err != nil {
+		t.Fatalf("UploadTargetFile: %v", err)
+	}
+
+	rate := 1000
+	m, err := client.CreateMeasurement(iris.MeasurementCreate{
+		Tool: iris.ToolDiamondMiner,
+		Tags: []string{"zeph"},
+		Agents: []iris.MeasurementAgentCreate{
+			{Tag: "all", TargetFile: "zeph.csv", ProbingRate: &rate},
+			{UUID: "ffffffff-0000-0000-0000-00000000000c", TargetFile: "zeph.csv"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateMeasurement: %v", err)
+	}
+	if len(m.UUID) != 36 || m.Tool != iris.ToolDiamondMiner || len(m.Tags) != 1 || m.Tags[0] != "zeph" || m.State != iris.StateCreated {
+		t.Errorf("created = %+v, want a new diamond-miner measurement tagged zeph", m)
+	}
+	want := []struct {
+		uuid string
+		rate *int
+	}{
+		{"ffffffff-0000-0000-0000-00000000000a", &rate},
+		{"ffffffff-0000-0000-0000-00000000000b", &rate},
+		{"ffffffff-0000-0000-0000-00000000000c", nil},
+	}
+	if len(m.Agents) != len(want) {
+		t.Fatalf("got %d agents, want %d", len(m.Agents), len(want))
+	}
+	for i, a := range m.Agents {
+		if a.AgentUUID != want[i].uuid || a.TargetFile != "zeph.csv" || (a.ProbingRate == nil) != (want[i].rate == nil) || a.ProbingRate != nil && *a.ProbingRate != rate {
+			t.Errorf("agent %d = %+v, want %s with probing rate %v", i, a, want[i].uuid, want[i].rate)
+		}
+	}
+
+	// The created measurement is listed with the returned UUID.
+	got, err := client.Measurement(m.UUID)
+	if err != nil {
+		t.Fatalf("Measurement: %v", err)
+	}
+	if got.UUID != m.UUID || len(got.Agents) != 3 {
+		t.Errorf("Measurement(%s) = %+v, want the created measurement", m.UUID, got)
+	}
+}
+
+func TestCreateMeasurementErrors(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.AddAgent("ffffffff-0000-0000-0000-00000000000a", "all")
+	if _, err := client.UploadTargetFile("zeph.csv", strings.NewReader(targets)); err != nil {
+		t.Fatalf("UploadTargetFile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		m    iris.MeasurementCreate
+		want string
+	}{
+		{"no agents", iris.MeasurementCreate{Tool: iris.ToolDiamondMiner}, "requires at least one agent"},
+		{"unknown tool", iris.MeasurementCreate{Tool: "traceroute", Agents: []iris.MeasurementAgentCreate{{Tag: "all", TargetFile: "zeph.csv"}}}, `status 422 for POST /measurements/: unknown tool "traceroute"`},
+		{"missing target file", iris.MeasurementCreate{Tool: iris.ToolPing, Agents: []iris.MeasurementAgentCreate{{Tag: "all", TargetFile: "missing.csv"}}}, "status 404 for POST /measurements/: Target file missing.csv not found"},
+		{"unknown tag", iris.MeasurementCreate{Tool: iris.ToolPing, Agents: []iris.MeasurementAgentCreate{{Tag: "asia", TargetFile: "zeph.csv"}}}, "status 404 for POST /measurements/: No agent found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.CreateMeasurement(tt.m)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if found, err := client.Measurements().Fetch(); err != nil || len(found) != 0 {
+		t.Errorf("listed %d measurements (err %v), want none created", len(found), err)
+	}
+}
+
+// newRecordingServer starts a server accepting any login and answering
+// requests other than logins with status and body, and returns the last
+// request body it received.
+func newRecordingServer(t *testing.T, status int, body string) (*iris.IrisClient, func() []byte) {
+	t.Helper()
+	var last []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/auth/jwt/login" {
+			_ = json.NewEncoder(w).Encode(iris.BearerResponse{AccessToken: "token", TokenType: "bearer"})
+			return
+		}
+		last, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	client, err := iris.NewIrisClient(iris.Config{Username: "user", Password: "password", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewIrisClient: %v", err)
+	}
+	return client, func() []byte { return last }
+}
+
+func TestCreateMeasurementRequestBody(t *testing.T) {
+	client, last := newRecordingServer(t, http.StatusCreated, `{"uuid": "6f1c3a4e-0000-4000-8000-000000000001", "tool": "ping", "agents": [{"agent_uuid": "ffffffff-0000-0000-0000-00000000000a", "target_file": "zeph.csv", "state": "created"}]}`)
+
+	rate, batch := 1000, 50
+	m, err := client.CreateMeasurement(iris.MeasurementCreate{
+		Tool: iris.ToolPing,
+		Tags: []string{"zeph"},
+		Agents: []iris.MeasurementAgentCreate{
+			{Tag: "all", TargetFile: "zeph.csv", ProbingRate: &rate, BatchSize: &batch, ToolParameters: map[string]any{"n_flow_ids": 6}},
+			{UUID: "ffffffff-0000-0000-0000-00000000000a", TargetFile: "zeph.csv"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateMeasurement: %v", err)
+	}
+	if m.UUID != "6f1c3a4e-0000-4000-8000-000000000001" || len(m.Agents) != 1 || m.Agents[0].State != iris.StateCreated {
+		t.Errorf("created = %+v, want the measurement of the response", m)
+	}
+
+	// Unset fields are omitted, so that Iris applies its defaults.
+	var sent map[string]any
+	if err := json.Unmarshal(last(), &sent); err != nil {
+		t.Fatalf("request body %q: %v", last(), err)
+	}
+	want := map[string]any{
+		"tool": "ping",
+		"tags": []any{"zeph"},
+		"agents": []any{
+			map[string]any{"tag": "all", "target_file": "zeph.csv", "probing_rate": float64(1000), "batch_size": float64(50), "tool_parameters": map[string]any{"n_flow_ids": float64(6)}},
+			map[string]any{"uuid": "ffffffff-0000-0000-0000-00000000000a", "target_file": "zeph.csv"},
+		},
+	}
+	got, _ := json.Marshal(sent)
+	wantJSON, _ := json.Marshal(want)
+	if string(got) != string(wantJSON) {
+		t.Errorf("request body = %s, want %s", got, wantJSON)
+	}
+}
+
+func TestCreateMeasurementBadResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"not json", http.StatusCreated, "<html>created</html>", "failed to decode response"},
+		{"server error", http.StatusInternalServerError, "Internal Server Error", "unexpected status 500 for POST /measurements/"},
+		{"api error", http.StatusForbidden, `{"detail": "Account not verified"}`, "unexpected status 403 for POST /measurements/: Account not verified"},
+	}
+	m := iris.MeasurementCreate{Tool: iris.ToolPing, Agents: []iris.MeasurementAgentCreate{{Tag: "all", TargetFile: "zeph.csv"}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newRecordingServer(t, tt.status, tt.body)
+			_, err := client.CreateMeasurement(m)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelMeasurement(t *testing.T) {
+	client, srv := newTestClient(t)
+	srv.AddAgent(agentUUID, "all")
+	if _, err := client.UploadTargetFile("zeph.csv", strings.NewReader(targets)); err != nil {
+		t.Fatalf("UploadTargetFile: %v", err)
+	}
+	m, err := client.CreateMeasurement(iris.MeasurementCreate{
+		Tool:   iris.ToolDiamondMiner,
+		Agents: []iris.MeasurementAgentCreate{{Tag: "all", TargetFile: "zeph.csv"}},
+	})
+	if err != nil {
+		t.Fatalf("CreateMeasurement: %v", err)
+	}
+
+	if err := client.CancelMeasurement(m.UUID); err != nil {
+		t.Fatalf("CancelMeasurement: %v", err)
+	}
+	got, err := client.Measurement(m.UUID)
+	if err != nil {
+		t.Fatalf("Measurement: %v", err)
+	}
+	if got.State != iris.StateCanceled || len(got.Agents) != 1 || got.Agents[0].State != iris.StateCanceled {
+		t.Errorf("after cancel = %+v, want the measurement and its agent canceled", got)
+	}
+
+	if err := client.CancelMeasurement(m.UUID); err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "Measurement already ended") {
+		t.Errorf("second cancel error = %v, want a 400 with the API detail", err)
+	}
+	if err := client.CancelMeasurement(measurementUUID(404)); err == nil || !strings.Contains(err.Error(), "status 404") || !strings.Contains(err.Error(), "Measurement not found") {
+		t.Errorf("cancel of an unknown measurement error = %v, want a 404 with the API detail", err)
+	}
+}
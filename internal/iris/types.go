@@ -2,6 +2,7 @@ package iris
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -62,6 +63,16 @@ var AllMeasurementStates = []MeasurementAgentState{
 	StateOngoing,
 }
 
+// IsTerminal reports whether a measurement in this state will not produce
+// any more results.
+func (s MeasurementAgentState) IsTerminal() bool {
+	switch s {
+	case StateFinished, StateCanceled, StateAgentFailure:
+		return true
+	}
+	return false
+}
+
 type Tool string
 
 const (
@@ -200,6 +211,55 @@ func tableName(kind IrisTableKind, measurementUUID, agentUUID string) string {
 	)
 }
 
+// tablePartToUUID reverses uuidToTablePart.
+func tablePartToUUID(part string) string {
+	return strings.ReplaceAll(part, "_", "-")
+}
+
+// isUUID reports whether s is a canonical, dash-separated hexadecimal UUID.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParseTableName parses a table name of the form <kind>__<measurement>__<agent>
+// back into an IrisTable. The creation time is unknown and left zero.
+func ParseTableName(name string) (IrisTable, error) {
+	parts := strings.Split(name, "__")
+	if len(parts) != 3 {
+		return IrisTable{}, fmt.Errorf("iris: table name %q is not of the form <kind>__<measurement>__<agent>", name)
+	}
+	kind := IrisTableKind(parts[0])
+	if !slices.Contains(AllTableKinds, kind) {
+		return IrisTable{}, fmt.Errorf("iris: unknown table kind %q in table name %q", parts[0], name)
+	}
+	measurementUUID := tablePartToUUID(parts[1])
+	agentUUID := tablePartToUUID(parts[2])
+	if !isUUID(measurementUUID) || !isUUID(agentUUID) {
+		return IrisTable{}, fmt.Errorf("iris: table name %q does not contain valid UUIDs", name)
+	}
+	return IrisTable{
+		Kind:            kind,
+		TableName:       name,
+		MeasurementUUID: measurementUUID,
+		AgentUUID:       agentUUID,
+	}, nil
+}
+
 // NewIrisTableGroup constructs an IrisTableGroup for a given measurement and agent.
 func NewIrisTableGroup(measurementUUID, agentUUID string, creationTime IrisTime) IrisTableGroup {
 	makeTable := func(kind IrisTableKind) IrisTable {
@@ -223,6 +283,15 @@ func NewIrisTableGroup(measurementUUID, agentUUID string, creationTime IrisTime)
 	}
 }
 
+// TableGroups derives all IrisTableGroups from a MeasurementReadWithAgents.
+func (m MeasurementReadWithAgents) TableGroups() []IrisTableGroup {
+	groups := make([]IrisTableGroup, 0, len(m.Agents))
+	for _, agent := range m.Agents {
+		groups = append(groups, NewIrisTableGroup(m.UUID, agent.AgentUUID, m.CreationTime))
+	}
+	return groups
+}
+
 // TableGroupsForMeasurement derives all IrisTableGroups from a MeasurementRead.
 func TableGroupsForMeasurement(m MeasurementRead) []IrisTableGroup {
 	groups := make([]IrisTableGroup, 0, len(m.Agents))
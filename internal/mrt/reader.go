@@ -0,0 +1,336 @@
+// Package mrt reads BGP routing tables from MRT TABLE_DUMP_V2 files
+// (RFC 6396), as published by RIPE RIS and RouteViews collectors.
+//
+// Only what is needed to derive prefix origins is decoded: the peer index
+// table, the unicast RIB records (with and without ADD-PATH) and the AS_PATH
+// attribute of each RIB entry. Other record types are skipped and counted.
+package mrt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// MRT types and TABLE_DUMP_V2 subtypes, from RFC 6396 and RFC 8050.
+const (
+	typeTableDumpV2 = 13
+
+	subtypePeerIndexTable        = 1
+	subtypeRIBIPv4Unicast        = 2
+	subtypeRIBIPv6Unicast        = 4
+	subtypeRIBIPv4UnicastAddPath = 8
+	subtypeRIBIPv6UnicastAddPath = 10
+)
+
+// BGP path attribute types and AS_PATH segment types, from RFC 4271.
+const (
+	attrFlagExtendedLength = 0x10
+	attrTypeASPath         = 2
+
+	SegmentASSet      = 1
+	SegmentASSequence = 2
+)
+
+// maxRecordLength bounds the length of a single record, to fail on corrupt
+// files instead of allocating arbitrary amounts of memory.
+const maxRecordLength = 16 << 20
+
+// RIB is the routing table entry of a single prefix: one RIBEntry per peer
+// that announced it to the collector.
+type RIB struct {
+	// Timestamp is the time of the dump, from the MRT record header.
+	Timestamp time.Time
+	Prefix    netip.Prefix
+	Entries   []RIBEntry
+}
+
+// RIBEntry is the route of a prefix as received from a peer.
+type RIBEntry struct {
+	PeerIndex      uint16
+	OriginatedTime time.Time
+	// PathID is the ADD-PATH path identifier, 0 without ADD-PATH.
+	PathID uint32
+	ASPath []Segment
+}
+
+// Segment is an AS_PATH segment.
+type Segment struct {
+	Type uint8 // SegmentASSet or SegmentASSequence
+	ASNs []uint32
+}
+
+// Origin returns the origin AS of the route: the last AS of the AS_PATH. It
+// reports false if the path is empty or ends with an AS_SET of more than one
+// AS, in which case the origin is ambiguous.
+func (e RIBEntry) Origin() (uint32, bool) {
+	if len(e.ASPath) == 0 {
+		return 0, false
+	}
+	last := e.ASPath[len(e.ASPath)-1]
+	if len(last.ASNs) == 0 || (last.Type == SegmentASSet && len(last.ASNs) != 1) {
+		return 0, false
+	}
+	return last.ASNs[len(last.ASNs)-1], true
+}
+
+// Stats counts the records read so far.
+type Stats struct {
+	Records int // all records, including skipped ones
+	RIBs    int // unicast RIB records returned by Next
+	Skipped int // records of other types and subtypes
+}
+
+// Reader reads RIB records from an MRT stream.
+type Reader struct {
+	r     *bufio.Reader
+	peers int // number of peers in the peer index table, -1 before it
+	stats Stats
+}
+
+// NewReader returns a Reader for r, which may be compressed with gzip or
+// bzip2; the compression is detected from the first bytes of the stream.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(3)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("mrt: failed to read header: %w", err)
+	}
+
+	var src io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("mrt: failed to open gzip stream: %w", err)
+		}
+		src = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		src = bzip2.NewReader(br)
+	}
+	return &Reader{r: bufio.NewReaderSize(src, 1<<16), peers: -1}, nil
+}
+
+// Stats returns the record counters.
+func (r *Reader) Stats() Stats {
+	return r.stats
+}
+
+// Next returns the next unicast RIB record, skipping records of other types.
+// It returns io.EOF at the end of the stream.
+func (r *Reader) Next() (RIB, error) {
+	var header [12]byte
+	for {
+		if _, err := io.ReadFull(r.r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return RIB{}, io.EOF
+			}
+			return RIB{}, fmt.Errorf("mrt: failed to read record header: %w", err)
+		}
+		timestamp := time.Unix(int64(binary.BigEndian.Uint32(header[0:4])), 0).UTC()
+		typ := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		if length > maxRecordLength {
+			return RIB{}, fmt.Errorf("mrt: record %d is %d bytes long, file is corrupt", r.stats.Records+1, length)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return RIB{}, fmt.Errorf("mrt: failed to read record %d: %w", r.stats.Records+1, err)
+		}
+		r.stats.Records++
+
+		if typ != typeTableDumpV2 {
+			r.stats.Skipped++
+			continue
+		}
+		switch subtype {
+		case subtypePeerIndexTable:
+			n, err := parsePeerCount(body)
+			if err != nil {
+				return RIB{}, fmt.Errorf("mrt: record %d: %w", r.stats.Records, err)
+			}
+			r.peers = n
+			r.stats.Skipped++
+		case subtypeRIBIPv4Unicast, subtypeRIBIPv6Unicast, subtypeRIBIPv4UnicastAddPath, subtypeRIBIPv6UnicastAddPath:
+			if r.peers < 0 {
+				return RIB{}, fmt.Errorf("mrt: record %d: RIB record before the peer index table", r.stats.Records)
+			}
+			is4 := subtype == subtypeRIBIPv4Unicast || subtype == subtypeRIBIPv4UnicastAddPath
+			addPath := subtype == subtypeRIBIPv4UnicastAddPath || subtype == subtypeRIBIPv6UnicastAddPath
+			rib, err := r.parseRIB(body, is4, addPath)
+			if err != nil {
+				return RIB{}, fmt.Errorf("mrt: record %d: %w", r.stats.Records, err)
+			}
+			rib.Timestamp = timestamp
+			r.stats.RIBs++
+			return rib, nil
+		default:
+			r.stats.Skipped++
+		}
+	}
+}
+
+// parsePeerCount returns the number of peers of a PEER_INDEX_TABLE record.
+// Peers are only referenced by index, so their details are not decoded.
+func parsePeerCount(b []byte) (int, error) {
+	d := decoder{b: b}
+	d.skip(4) // collector BGP ID
+	d.skip(int(d.uint16()))
+	n := d.uint16()
+	if d.err != nil {
+		return 0, fmt.Errorf("invalid peer index table: %w", d.err)
+	}
+	return int(n), nil
+}
+
+// parseRIB decodes a RIB_IPV4_UNICAST or RIB_IPV6_UNICAST record, or their
+// ADD-PATH variants.
+func (r *Reader) parseRIB(b []byte, is4, addPath bool) (RIB, error) {
+	d := decoder{b: b}
+	d.skip(4) // sequence number
+	bits := int(d.uint8())
+	prefixBytes := d.bytes((bits + 7) / 8)
+	if d.err != nil {
+		return RIB{}, fmt.Errorf("invalid RIB record: %w", d.err)
+	}
+	prefix, err := makePrefix(prefixBytes, bits, is4)
+	if err != nil {
+		return RIB{}, err
+	}
+
+	rib := RIB{Prefix: prefix}
+	n := int(d.uint16())
+	for range n {
+		e := RIBEntry{PeerIndex: d.uint16()}
+		e.OriginatedTime = time.Unix(int64(d.uint32()), 0).UTC()
+		if addPath {
+			e.PathID = d.uint32()
+		}
+		attrs := d.bytes(int(d.uint16()))
+		if d.err != nil {
+			return RIB{}, fmt.Errorf("invalid RIB entry of %s: %w", prefix, d.err)
+		}
+		if int(e.PeerIndex) >= r.peers {
+			return RIB{}, fmt.Errorf("RIB entry of %s references peer %d of %d", prefix, e.PeerIndex, r.peers)
+		}
+		if e.ASPath, err = parseASPath(attrs); err != nil {
+			return RIB{}, fmt.Errorf("invalid AS_PATH of %s: %w", prefix, err)
+		}
+		rib.Entries = append(rib.Entries, e)
+	}
+	return rib, nil
+}
+
+// makePrefix builds a prefix from its bits-long truncated address.
+func makePrefix(b []byte, bits int, is4 bool) (netip.Prefix, error) {
+	var addr netip.Addr
+	if is4 {
+		if bits > 32 {
+			return netip.Prefix{}, fmt.Errorf("invalid IPv4 prefix length %d", bits)
+		}
+		var a [4]byte
+		copy(a[:], b)
+		addr = netip.AddrFrom4(a)
+	} else {
+		if bits > 128 {
+			return netip.Prefix{}, fmt.Errorf("invalid IPv6 prefix length %d", bits)
+		}
+		var a [16]byte
+		copy(a[:], b)
+		addr = netip.AddrFrom16(a)
+	}
+	return netip.PrefixFrom(addr, bits).Masked(), nil
+}
+
+// parseASPath extracts the AS_PATH attribute from a list of BGP path
+// attributes. In TABLE_DUMP_V2 files AS numbers are always 4 bytes long.
+// A missing AS_PATH yields an empty path.
+func parseASPath(attrs []byte) ([]Segment, error) {
+	d := decoder{b: attrs}
+	for len(d.b) > 0 && d.err == nil {
+		flags := d.uint8()
+		typ := d.uint8()
+		var length int
+		if flags&attrFlagExtendedLength != 0 {
+			length = int(d.uint16())
+		} else {
+			length = int(d.uint8())
+		}
+		value := d.bytes(length)
+		if d.err != nil {
+			break
+		}
+		if typ != attrTypeASPath {
+			continue
+		}
+
+		var path []Segment
+		p := decoder{b: value}
+		for len(p.b) > 0 && p.err == nil {
+			seg := Segment{Type: p.uint8()}
+			count := int(p.uint8())
+			for range count {
+				seg.ASNs = append(seg.ASNs, p.uint32())
+			}
+			path = append(path, seg)
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		return path, nil
+	}
+	return nil, d.err
+}
+
+// decoder reads big-endian values from a byte slice. The first read past
+// the end sets err; subsequent reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *decoder) skip(n int) {
+	d.bytes(n)
+}
+
+func (d *decoder) uint8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
@@ -0,0 +1,270 @@
+package mrt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var dumpTime = time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC)
+
+// record returns an MRT record of the given type and subtype.
+func record(typ, subtype uint16, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(dumpTime.Unix()))
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, subtype)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// peerIndex returns a PEER_INDEX_TABLE record announcing n peers. The peer
+// entries themselves are not decoded by the reader, so none are written.
+func peerIndex(n uint16) []byte {
+	body := []byte{192, 0, 2, 1} // collector BGP ID
+	body = binary.BigEndian.AppendUint16(body, 4)
+	body = append(body, "rrc0"...)
+	body = binary.BigEndian.AppendUint16(body, n)
+	return record(typeTableDumpV2, subtypePeerIndexTable, body)
+}
+
+// entry is a RIB entry to encode.
+type entry struct {
+	peer   uint16
+	pathID uint32 // written for the ADD-PATH subtypes only
+	attrs  []byte
+}
+
+// rib returns a RIB record of prefix with the given subtype.
+func rib(subtype uint16, prefix string, entries ...entry) []byte {
+	p := netip.MustParsePrefix(prefix)
+	body := binary.BigEndian.AppendUint32(nil, 7) // sequence number
+	body = append(body, byte(p.Bits()))
+	body = append(body, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(entries)))
+	addPath := subtype == subtypeRIBIPv4UnicastAddPath || subtype == subtypeRIBIPv6UnicastAddPath
+	for _, e := range entries {
+		body = binary.BigEndian.AppendUint16(body, e.peer)
+		body = binary.BigEndian.AppendUint32(body, uint32(dumpTime.Add(-time.Hour).Unix()))
+		if addPath {
+			body = binary.BigEndian.AppendUint32(body, e.pathID)
+		}
+		body = binary.BigEndian.AppendUint16(body, uint16(len(e.attrs)))
+		body = append(body, e.attrs...)
+	}
+	return record(typeTableDumpV2, subtype, body)
+}
+
+// attr returns a BGP path attribute, with an extended length if the flag is
+// set.
+func attr(flags, typ uint8, value []byte) []byte {
+	b := []byte{flags, typ}
+	if flags&attrFlagExtendedLength != 0 {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	} else {
+		b = append(b, byte(len(value)))
+	}
+	return append(b, value...)
+}
+
+// asPath returns the value of an AS_PATH attribute.
+func asPath(segments ...Segment) []byte {
+	var b []byte
+	for _, s := range segments {
+		b = append(b, s.Type, byte(len(s.ASNs)))
+		for _, asn := range s.ASNs {
+			b = binary.BigEndian.AppendUint32(b, asn)
+		}
+	}
+	return b
+}
+
+func seq(asns ...uint32) Segment { return Segment{Type: SegmentASSequence, ASNs: asns} }
+func set(asns ...uint32) Segment { return Segment{Type: SegmentASSet, ASNs: asns} }
+
+// origin is the ORIGIN attribute, preceding AS_PATH as in real dumps.
+var origin = attr(0x40, 1, []byte{0})
+
+// testStream is a dump of two peers covering every decoded record type,
+// with a record of another MRT type and an unknown subtype to skip.
+func testStream() []byte {
+	return bytes.Join([][]byte{
+		record(12, 1, []byte{1, 2, 3}), // TABLE_DUMP (v1)
+		peerIndex(2),
+		rib(subtypeRIBIPv4Unicast, "192.0.2.0/24",
+			entry{peer: 0, attrs: slices.Concat(origin, attr(0x40, attrTypeASPath, asPath(seq(3356, 64500))))},
+			entry{peer: 1, attrs: attr(0x50, attrTypeASPath, asPath(seq(174), seq(64500)))},
+		),
+		rib(subtypeRIBIPv6Unicast, "2001:db8::/32",
+			entry{peer: 1, attrs: attr(0x40, attrTypeASPath, asPath(seq(6939), set(64501)))},
+		),
+		record(typeTableDumpV2, 6, nil), // RIB_GENERIC
+		rib(subtypeRIBIPv4UnicastAddPath, "198.51.100.0/22",
+			entry{peer: 0, pathID: 1, attrs: attr(0x40, attrTypeASPath, asPath(seq(3356), set(64502, 64503)))},
+			entry{peer: 0, pathID: 2, attrs: origin},
+		),
+		rib(subtypeRIBIPv6UnicastAddPath, "2001:db8:1::/48",
+			entry{peer: 1, pathID: 9, attrs: attr(0x40, attrTypeASPath, asPath(seq(6939, 64504)))},
+		),
+	}, nil)
+}
+
+// wantRIBs is the content of testStream, by prefix: the path ID and origin
+// of each entry, or "-" when the origin is unknown.
+var wantRIBs = []string{
+	"192.0.2.0/24 [0:64500 0:64500]",
+	"2001:db8::/32 [0:64501]",
+	"198.51.100.0/22 [1:- 2:-]",
+	"2001:db8:1::/48 [9:64504]",
+}
+
+// readAll reads every RIB of r and formats them like wantRIBs.
+func readAll(t *testing.T, r io.Reader) ([]string, Stats) {
+	t.Helper()
+	reader, err := NewReader(r)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	var got []string
+	for {
+		rib, err := reader.Next()
+		if err == io.EOF {
+			return got, reader.Stats()
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if !rib.Timestamp.Equal(dumpTime) {
+			t.Errorf("timestamp of %s = %s, want %s", rib.Prefix, rib.Timestamp, dumpTime)
+		}
+		var entries []string
+		for _, e := range rib.Entries {
+			if !e.OriginatedTime.Equal(dumpTime.Add(-time.Hour)) {
+				t.Errorf("originated time of %s = %s", rib.Prefix, e.OriginatedTime)
+			}
+			if asn, ok := e.Origin(); ok {
+				entries = append(entries, fmt.Sprintf("%d:%d", e.PathID, asn))
+			} else {
+				entries = append(entries, fmt.Sprintf("%d:-", e.PathID))
+			}
+		}
+		got = append(got, fmt.Sprintf("%s %v", rib.Prefix, entries))
+	}
+}
+
+func TestReader(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(testStream())
+	_ = w.Close()
+	// testdata/rib.mrt.bz2 is testStream compressed with bzip2, which the
+	// standard library cannot write.
+	bz2, err := os.ReadFile("testdata/rib.mrt.bz2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"plain", testStream()},
+		{"gzip", gz.Bytes()},
+		{"bzip2", bz2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stats := readAll(t, bytes.NewReader(tt.input))
+			if strings.Join(got, "\n") != strings.Join(wantRIBs, "\n") {
+				t.Errorf("read\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(wantRIBs, "\n"))
+			}
+			if want := (Stats{Records: 7, RIBs: 4, Skipped: 3}); stats != want {
+				t.Errorf("stats = %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func TestReaderEmpty(t *testing.T) {
+	got, stats := readAll(t, bytes.NewReader(nil))
+	if len(got) != 0 || stats != (Stats{}) {
+		t.Errorf("read %v with stats %+v from an empty stream", got, stats)
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	tests := []struct {
+		name string
+		path []Segment
+		want uint32 // 0 when ambiguous
+	}{
+		{"sequence", []Segment{seq(3356, 64500)}, 64500},
+		{"single AS_SET", []Segment{seq(3356), set(64500)}, 64500},
+		{"AS_SET", []Segment{seq(3356), set(64500, 64501)}, 0},
+		{"AS_SET before the origin", []Segment{set(64500, 64501), seq(64502)}, 64502},
+		{"empty", nil, 0},
+		{"empty segment", []Segment{seq()}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asn, ok := RIBEntry{ASPath: tt.path}.Origin()
+			if ok != (tt.want != 0) || asn != tt.want {
+				t.Errorf("Origin() = %d, %v; want %d", asn, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	v4 := rib(subtypeRIBIPv4Unicast, "192.0.2.0/24", entry{peer: 0, attrs: attr(0x40, attrTypeASPath, asPath(seq(64500)))})
+	badLength := record(typeTableDumpV2, subtypeRIBIPv4Unicast, []byte{0, 0, 0, 1, 33, 192, 0, 2, 0, 0})
+	hugeRecord := record(typeTableDumpV2, subtypeRIBIPv4Unicast, nil)
+	binary.BigEndian.PutUint32(hugeRecord[8:12], maxRecordLength+1)
+	truncatedPath := rib(subtypeRIBIPv4Unicast, "192.0.2.0/24", entry{peer: 0, attrs: attr(0x40, attrTypeASPath, asPath(seq(64500))[:4])})
+	truncatedAttr := rib(subtypeRIBIPv4Unicast, "192.0.2.0/24", entry{peer: 0, attrs: attr(0x50, attrTypeASPath, asPath(seq(64500)))[:5]})
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"RIB before the peer index table", v4, "record 1: RIB record before the peer index table"},
+		{"unknown peer", append(peerIndex(0), v4...), "record 2: RIB entry of 192.0.2.0/24 references peer 0 of 0"},
+		{"truncated peer index table", record(typeTableDumpV2, subtypePeerIndexTable, []byte{192, 0, 2}), "record 1: invalid peer index table: unexpected EOF"},
+		{"truncated header", v4[:6], "failed to read record header: unexpected EOF"},
+		{"truncated body", append(peerIndex(1), v4[:len(v4)-2]...), "failed to read record 2: unexpected EOF"},
+		{"oversized record", hugeRecord, "record 1 is 16777217 bytes long, file is corrupt"},
+		{"invalid prefix length", append(peerIndex(1), badLength...), "record 2: invalid IPv4 prefix length 33"},
+		{"truncated AS_PATH", append(peerIndex(1), truncatedPath...), "record 2: invalid AS_PATH of 192.0.2.0/24: unexpected EOF"},
+		{"truncated attribute", append(peerIndex(1), truncatedAttr...), "record 2: invalid AS_PATH of 192.0.2.0/24: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := NewReader(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			for err == nil {
+				_, err = reader.Next()
+			}
+			if errors.Is(err, io.EOF) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewReaderCorruptGzip(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte{0x1f, 0x8b, 0x00}))
+	if err == nil || !strings.Contains(err.Error(), "mrt: failed to open gzip stream") {
+		t.Errorf("error = %v, want a gzip error", err)
+	}
+}
@@ -2,15 +2,33 @@ package retina
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	DefaultEndpoint  = "http://iprl.dioptra.io/api/v1/stream"
-	DefaultBatchSize = 1000
+	DefaultEndpoint      = "http://iprl.dioptra.io/api/v1/stream"
+	DefaultBatchSize     = 1000
+	DefaultFlushInterval = 10 * time.Second
+
+	DefaultMaxReconnects     = 10
+	DefaultReconnectDelay    = time.Second
+	DefaultMaxReconnectDelay = time.Minute
+	DefaultIdleTimeout       = 2 * time.Minute
+	// DefaultResumeParam is the query parameter carrying the first sequence
+	// number wanted when resuming the stream.
+	DefaultResumeParam = "from_sequence_number"
 )
 
 // Config holds the configuration for a RetinaClient.
@@ -25,17 +43,82 @@ type Config struct {
 	// Defaults to 1000.
 	BatchSize int
 
+	// FlushInterval is the longest a FIE waits in a partial batch before
+	// the batch is sent, so that a slow trickle of FIEs is still delivered.
+	// Defaults to DefaultFlushInterval; negative flushes only full batches.
+	FlushInterval time.Duration
+
 	// HTTPClient is the HTTP client used for the streaming request.
 	// Defaults to http.DefaultClient.
 	HTTPClient *http.Client
+
+	// MaxReconnects is the number of consecutive reconnection attempts
+	// after which the stream gives up. The count is reset whenever a
+	// connection delivers new FIEs. Defaults to DefaultMaxReconnects;
+	// negative disables reconnection.
+	MaxReconnects int
+
+	// ReconnectDelay is the base delay of the exponential backoff between
+	// reconnection attempts, capped at MaxReconnectDelay.
+	// Default to DefaultReconnectDelay and DefaultMaxReconnectDelay.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+
+	// ResumeParam is the query parameter sent with the next wanted sequence
+	// number when resuming. Defaults to DefaultResumeParam.
+	ResumeParam string
+
+	// IdleTimeout is the longest a connection may go without receiving
+	// data, response headers included, before it is dropped and
+	// reconnected. It detects half-open connections, on which reads would
+	// otherwise block forever. Defaults to DefaultIdleTimeout; negative
+	// disables it.
+	IdleTimeout time.Duration
 }
 
-// StreamResponse is the result type yielded by Stream. Exactly one of Batch
-// or Err is set per value. When Err is set the channel is closed immediately
-// after; no further values are sent.
+// StreamResponse is the result type yielded by Stream. Exactly one of Batch,
+// Reconnect, Gap or Err is set per value. When Err is set the channel is
+// closed immediately after; no further values are sent.
 type StreamResponse struct {
 	Batch []SequencedFIE
-	Err   error
+	// Reconnect reports a dropped connection about to be retried.
+	Reconnect *Reconnect
+	// Gap reports FIEs missed across a reconnection, or sequence numbers
+	// going backwards.
+	Gap *Gap
+	Err error
+}
+
+// Reconnect describes a reconnection attempt.
+type Reconnect struct {
+	Attempt int           // consecutive attempt, from 1
+	Delay   time.Duration // wait before the attempt
+	// After is the last sequence number received, 0 if none.
+	After uint64
+	Cause error
+}
+
+// Gap describes a jump in sequence numbers. A forward jump after a
+// reconnection happens when the server does not support resuming or no
+// longer holds the missed FIEs. A backward jump, a Reset, happens when the
+// server restarted its numbering, e.g. after a restart; the FIEs it sent
+// before are unknown.
+type Gap struct {
+	After uint64 // last sequence number received before the jump
+	Next  uint64 // first sequence number received after it
+}
+
+// Reset reports whether the sequence numbers went backwards.
+func (g Gap) Reset() bool {
+	return g.Next <= g.After
+}
+
+// Missing returns the number of FIEs missed, 0 after a Reset.
+func (g Gap) Missing() uint64 {
+	if g.Reset() {
+		return 0
+	}
+	return g.Next - g.After - 1
 }
 
 // RetinaClient consumes the Retina FIE stream and delivers results in batches.
@@ -52,16 +135,36 @@ func NewRetinaClient(cfg Config) *RetinaClient {
 	if cfg.BatchSize <= 0 {
 		cfg.BatchSize = DefaultBatchSize
 	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
 	if cfg.HTTPClient == nil {
 		cfg.HTTPClient = http.DefaultClient
 	}
+	if cfg.MaxReconnects == 0 {
+		cfg.MaxReconnects = DefaultMaxReconnects
+	}
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = DefaultReconnectDelay
+	}
+	if cfg.MaxReconnectDelay <= 0 {
+		cfg.MaxReconnectDelay = DefaultMaxReconnectDelay
+	}
+	if cfg.ResumeParam == "" {
+		cfg.ResumeParam = DefaultResumeParam
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
 	return &RetinaClient{cfg: cfg}
 }
 
 // Stream opens the configured endpoint and returns a channel of StreamResponse.
-// Each value carries either a batch of up to Config.BatchSize SequencedFIEs or
-// a non-nil error. After an error the channel is closed; on EOF or context
-// cancellation any partial batch accumulated so far is flushed before closing.
+// Each value carries either a batch of up to Config.BatchSize SequencedFIEs,
+// sent when full or Config.FlushInterval after its first FIE, a reconnection
+// or gap notice, or a non-nil error. After an error the channel is closed; on
+// context cancellation any partial batch accumulated so far is flushed before
+// closing.
 //
 // Typical usage:
 //
@@ -72,82 +175,404 @@ func NewRetinaClient(cfg Config) *RetinaClient {
 //	    // process r.Batch
 //	}
 func (c *RetinaClient) Stream(ctx context.Context) <-chan StreamResponse {
+	return c.StreamWith(ctx, StreamOptions{})
+}
+
+// StreamOptions holds the per-stream options of StreamWith.
+type StreamOptions struct {
+	// After resumes the stream after this sequence number, e.g. the last
+	// one stored by a previous run; 0 starts at the live head.
+	After uint64
+	// Filter, if set, drops the FIEs it does not keep before batching.
+	Filter *Filter
+	// Record, if set, receives every line of the stream as received, before
+	// filtering, so that the stream can be fed again with Replay. Lines
+	// dropped as already delivered are not recorded.
+	Record io.Writer
+}
+
+// StreamWith is like Stream, with options.
+//
+// The stream is live and never ends on its own, so a connection error, an
+// unexpected status or the end of the response body is a dropped connection:
+// the partial batch is flushed and the client reconnects with exponential
+// backoff, asking the server for the FIEs after the last sequence number
+// received. A connection receiving nothing for Config.IdleTimeout is
+// dropped too. FIEs the server sends again are dropped; if the first new
+// FIE is not the next sequence number, a Gap is reported. Sequence numbers
+// going backwards, on a connection or across a reconnection, are a reset of
+// the server numbering: a Gap is reported and the FIEs are delivered.
+// Decoding errors and 4xx statuses other than 429 are not retried.
+func (c *RetinaClient) StreamWith(ctx context.Context, opts StreamOptions) <-chan StreamResponse {
 	ch := make(chan StreamResponse)
 
 	go func() {
 		defer close(ch)
 
-		// send delivers r on ch. It always uses a background context so that a
+		// send delivers r on ch. It never waits on ctx so that a
 		// partial-batch flush after cancellation is never dropped.
 		send := func(r StreamResponse) {
 			ch <- r
 		}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Endpoint, nil)
-		if err != nil {
-			send(StreamResponse{Err: fmt.Errorf("retina: build request: %w", err)})
-			return
+		last := opts.After
+		attempt := 0
+		for {
+			received, err := c.connect(ctx, last, opts, send)
+			// received is lower than last after a reset.
+			if received != last {
+				last = received
+				attempt = 0
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			var fatal *fatalError
+			if errors.As(err, &fatal) {
+				send(StreamResponse{Err: fatal.err})
+				return
+			}
+
+			attempt++
+			if c.cfg.MaxReconnects < 0 || attempt > c.cfg.MaxReconnects {
+				send(StreamResponse{Err: fmt.Errorf("retina: gave up after %d reconnection attempt(s): %w", attempt-1, err)})
+				return
+			}
+			delay := c.reconnectDelay(attempt)
+			send(StreamResponse{Reconnect: &Reconnect{Attempt: attempt, Delay: delay, After: last, Cause: err}})
+			if sleep(ctx, delay) != nil {
+				return
+			}
 		}
+	}()
+
+	return ch
+}
+
+// fatalError wraps errors that reconnecting would not fix.
+type fatalError struct{ err error }
+
+func (e *fatalError) Error() string { return e.err.Error() }
 
-		resp, err := c.cfg.HTTPClient.Do(req)
+// connect streams a single connection, resuming after the sequence number
+// after if not 0, and returns the last sequence number received and the
+// reason the connection ended. Batches are flushed before returning.
+func (c *RetinaClient) connect(ctx context.Context, after uint64, opts StreamOptions, send func(StreamResponse)) (uint64, error) {
+	u := c.cfg.Endpoint
+	if after > 0 {
+		parsed, err := url.Parse(u)
 		if err != nil {
-			send(StreamResponse{Err: fmt.Errorf("retina: connect: %w", err)})
-			return
+			return after, &fatalError{fmt.Errorf("retina: build request: %w", err)}
+		}
+		q := parsed.Query()
+		q.Set(c.cfg.ResumeParam, strconv.FormatUint(after+1, 10))
+		parsed.RawQuery = q.Encode()
+		u = parsed.String()
+	}
+	// The request is canceled when the connection is idle for too long.
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var idle *idleTimer
+	if c.cfg.IdleTimeout > 0 {
+		idle = newIdleTimer(c.cfg.IdleTimeout, cancel)
+	}
+	defer idle.stop()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, u, nil)
+	if err != nil {
+		return after, &fatalError{fmt.Errorf("retina: build request: %w", err)}
+	}
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		if idle.expired() {
+			return after, idle.err()
 		}
-		defer resp.Body.Close()
+		return after, fmt.Errorf("retina: connect: %w", err)
+	}
+	defer resp.Body.Close()
+	// From now on, only the time spent waiting in reads counts.
+	idle.stop()
 
-		if resp.StatusCode != http.StatusOK {
-			send(StreamResponse{Err: fmt.Errorf("retina: unexpected status %s", resp.Status)})
-			return
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("retina: unexpected status %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return after, &fatalError{err}
 		}
+		return after, err
+	}
+
+	var body io.Reader = resp.Body
+	if idle != nil {
+		body = &idleReader{r: resp.Body, timer: idle}
+	}
+	last, err := c.consume(ctx, body, after, after, opts, nil, send)
+	switch {
+	case idle.expired() && ctx.Err() == nil:
+		err = idle.err()
+	case errors.Is(err, io.EOF):
+		err = errors.New("retina: stream ended")
+	}
+	return last, err
+}
 
-		batch := make([]SequencedFIE, 0, c.cfg.BatchSize)
+// idleTimer cancels a request when it is not reset in time.
+type idleTimer struct {
+	d     time.Duration
+	timer *time.Timer
+	fired atomic.Bool
+}
 
-		flush := func() {
-			if len(batch) > 0 {
-				send(StreamResponse{Batch: batch})
-				batch = make([]SequencedFIE, 0, c.cfg.BatchSize)
-			}
-		}
+func newIdleTimer(d time.Duration, cancel context.CancelFunc) *idleTimer {
+	t := &idleTimer{d: d}
+	t.timer = time.AfterFunc(d, func() {
+		t.fired.Store(true)
+		cancel()
+	})
+	return t
+}
+
+func (t *idleTimer) reset() { t.timer.Reset(t.d) }
 
-		scanner := bufio.NewScanner(resp.Body)
+// stop stops t; it does nothing if t is nil.
+func (t *idleTimer) stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
 
+// expired reports whether the timer canceled the request; false if t is nil.
+func (t *idleTimer) expired() bool {
+	return t != nil && t.fired.Load()
+}
+
+func (t *idleTimer) err() error {
+	return fmt.Errorf("retina: no data received for %s", t.d)
+}
+
+// idleReader runs an idleTimer while a read waits for data, so that the
+// time the consumer spends blocked on a full queue does not count.
+type idleReader struct {
+	r     io.Reader
+	timer *idleTimer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.timer.reset()
+	defer r.timer.stop()
+	return r.r.Read(p)
+}
+
+// consume decodes and batches the NDJSON stream r, dropping the FIEs up to
+// the sequence number after if not 0, until r or ctx ends. It returns the
+// last sequence number received and io.EOF at the end of r. If pace is set,
+// lines are read no faster than it allows. Batches are flushed before
+// returning.
+//
+// A sequence number not above the previous one read from r, or than prev
+// for the first, is a reset of the server numbering: it is reported as a
+// Gap and delivered, along with the FIEs that follow. prev is after for a
+// resumed connection, which should not start at or below it, and 0 for a
+// recording, which starts wherever it was recorded.
+func (c *RetinaClient) consume(ctx context.Context, r io.Reader, after, prev uint64, opts StreamOptions, pace *pacer, send func(StreamResponse)) (uint64, error) {
+	last := after
+	first := true
+	batch := make([]SequencedFIE, 0, c.cfg.BatchSize)
+
+	// The flush timer runs while batch is not empty.
+	timer := time.NewTimer(0)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			timer.Stop()
+			send(StreamResponse{Batch: batch})
+			batch = make([]SequencedFIE, 0, c.cfg.BatchSize)
+		}
+	}
+	defer flush()
+
+	// Lines are read in a separate goroutine so that the flush timer fires
+	// while the connection is idle or replay is paced.
+	lines := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	var scanErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
 		for scanner.Scan() {
-			// On cancellation break out of the scan loop so we can flush below.
+			line := bytes.Clone(scanner.Bytes())
+			if pace != nil && pace.wait(ctx, line) != nil {
+				return
+			}
 			select {
-			case <-ctx.Done():
-				flush()
+			case lines <- line:
+			case <-done:
 				return
-			default:
 			}
+		}
+		scanErr = scanner.Err()
+	}()
 
-			line := scanner.Bytes()
-			if len(line) == 0 {
-				continue
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			// Break out of the loop so we flush on return.
+			return last, ctx.Err()
+		case <-timer.C:
+			flush()
+			continue
+		case l, ok := <-lines:
+			if !ok {
+				if ctx.Err() != nil {
+					return last, ctx.Err()
+				}
+				if scanErr != nil {
+					return last, fmt.Errorf("retina: read stream: %w", scanErr)
+				}
+				return last, io.EOF
 			}
+			line = l
+		}
+		if len(line) == 0 {
+			continue
+		}
 
-			var fie SequencedFIE
-			if err := json.Unmarshal(line, &fie); err != nil {
-				flush()
-				send(StreamResponse{Err: fmt.Errorf("retina: decode FIE: %w", err)})
-				return
-			}
+		var fie SequencedFIE
+		if err := json.Unmarshal(line, &fie); err != nil {
+			return last, &fatalError{fmt.Errorf("retina: decode FIE: %w", err)}
+		}
 
-			batch = append(batch, fie)
+		switch {
+		case prev > 0 && fie.SequenceNumber <= prev:
+			flush()
+			send(StreamResponse{Gap: &Gap{After: last, Next: fie.SequenceNumber}})
+		case after > 0 && fie.SequenceNumber <= last:
+			// Replayed FIEs already delivered.
+			prev = fie.SequenceNumber
+			continue
+		case first && after > 0 && fie.SequenceNumber > after+1:
+			flush()
+			send(StreamResponse{Gap: &Gap{After: after, Next: fie.SequenceNumber}})
+		}
+		first = false
+		prev, last = fie.SequenceNumber, fie.SequenceNumber
 
-			if len(batch) >= c.cfg.BatchSize {
-				flush()
+		if opts.Record != nil {
+			if _, err := opts.Record.Write(append(line, '\n')); err != nil {
+				return last, &fatalError{fmt.Errorf("retina: record: %w", err)}
 			}
 		}
+		if !opts.Filter.Keep(&fie) {
+			continue
+		}
+		if len(batch) == 0 && c.cfg.FlushInterval > 0 {
+			timer.Reset(c.cfg.FlushInterval)
+		}
+		batch = append(batch, fie)
 
-		if err := scanner.Err(); err != nil {
+		if len(batch) >= c.cfg.BatchSize {
 			flush()
-			send(StreamResponse{Err: fmt.Errorf("retina: read stream: %w", err)})
-			return
+		}
+	}
+}
+
+// Replay is like StreamWith, but reads a stream recorded with
+// StreamOptions.Record from r, which may be gzip-compressed, instead of the
+// endpoint. The channel is closed at the end of r. With a positive speed,
+// FIEs are delivered at the pace of their production_timestamp: 1 replays in
+// real time, 2 twice as fast; otherwise they are delivered as fast as they
+// are consumed.
+func (c *RetinaClient) Replay(ctx context.Context, r io.Reader, speed float64, opts StreamOptions) <-chan StreamResponse {
+	ch := make(chan StreamResponse)
+
+	go func() {
+		defer close(ch)
+		send := func(r StreamResponse) {
+			ch <- r
+		}
+
+		br := bufio.NewReader(r)
+		var src io.Reader = br
+		if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			gz, err := gzip.NewReader(br)
+			if err != nil {
+				send(StreamResponse{Err: fmt.Errorf("retina: failed to open gzip stream: %w", err)})
+				return
+			}
+			defer gz.Close()
+			src = gz
 		}
 
-		flush()
+		var pace *pacer
+		if speed > 0 {
+			pace = &pacer{speed: speed}
+		}
+		_, err := c.consume(ctx, src, opts.After, 0, opts, pace, send)
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return
+		}
+		var fatal *fatalError
+		if errors.As(err, &fatal) {
+			err = fatal.err
+		}
+		send(StreamResponse{Err: err})
 	}()
 
 	return ch
 }
+
+// pacer delays replayed lines so that they are delivered at speed times the
+// pace of their production_timestamp.
+type pacer struct {
+	speed float64
+	start time.Time // wall clock time of the first line
+	first time.Time // production_timestamp of the first line
+}
+
+// wait sleeps until line is due. Lines without a valid timestamp are not
+// delayed; their decoding errors are reported by consume.
+func (p *pacer) wait(ctx context.Context, line []byte) error {
+	var fie struct {
+		ProductionTimestamp time.Time `json:"production_timestamp"`
+	}
+	if err := json.Unmarshal(line, &fie); err != nil || fie.ProductionTimestamp.IsZero() {
+		return nil
+	}
+	if p.start.IsZero() {
+		p.start, p.first = time.Now(), fie.ProductionTimestamp
+		return nil
+	}
+	offset := time.Duration(float64(fie.ProductionTimestamp.Sub(p.first)) / p.speed)
+	return sleep(ctx, time.Until(p.start.Add(offset)))
+}
+
+// reconnectDelay returns the wait before the given reconnection attempt
+// (from 1): ReconnectDelay doubled on each attempt, capped at
+// MaxReconnectDelay, with the upper half randomised.
+func (c *RetinaClient) reconnectDelay(attempt int) time.Duration {
+	d := c.cfg.ReconnectDelay
+	for range attempt - 1 {
+		d *= 2
+		if d >= c.cfg.MaxReconnectDelay {
+			d = c.cfg.MaxReconnectDelay
+			break
+		}
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,170 @@
+package retina
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stream returns NDJSON lines of FIEs with the given sequence numbers.
+func stream(seqs ...uint64) string {
+	var b strings.Builder
+	for _, seq := range seqs {
+		fmt.Fprintf(&b, "{\"sequence_number\": %d}\n", seq)
+	}
+	return b.String()
+}
+
+// collect reads ch until it is closed or n FIEs were delivered, and returns
+// the delivered sequence numbers, the gaps and the first error.
+func collect(t *testing.T, ch <-chan StreamResponse, cancel context.CancelFunc, n int) ([]uint64, []Gap, error) {
+	t.Helper()
+	var (
+		seqs []uint64
+		gaps []Gap
+		err  error
+	)
+	for r := range ch {
+		switch {
+		case r.Gap != nil:
+			gaps = append(gaps, *r.Gap)
+		case r.Err != nil && err == nil:
+			err = r.Err
+		}
+		for _, fie := range r.Batch {
+			seqs = append(seqs, fie.SequenceNumber)
+		}
+		if len(seqs) >= n {
+			cancel()
+		}
+	}
+	return seqs, gaps, err
+}
+
+func TestStreamReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		after uint64
+		conns []string // body of each connection
+		want  []uint64
+		gaps  []Gap
+	}{
+		{"on a connection", 0, []string{stream(7, 8, 1, 2)}, []uint64{7, 8, 1, 2}, []Gap{{After: 8, Next: 1}}},
+		{"across a reconnection", 0, []string{stream(7, 8), stream(1, 2)}, []uint64{7, 8, 1, 2}, []Gap{{After: 8, Next: 1}}},
+		{"when resuming", 8, []string{stream(1, 2)}, []uint64{1, 2}, []Gap{{After: 8, Next: 1}}},
+		{"forward gap", 8, []string{stream(12, 13)}, []uint64{12, 13}, []Gap{{After: 8, Next: 12}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				conns = tt.conns
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if len(conns) == 0 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, conns[0])
+				conns = conns[1:]
+			}))
+			defer srv.Close()
+
+			client := NewRetinaClient(Config{Endpoint: srv.URL, BatchSize: 1, ReconnectDelay: time.Millisecond, MaxReconnects: 3})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			seqs, gaps, _ := collect(t, client.StreamWith(ctx, StreamOptions{After: tt.after}), cancel, len(tt.want))
+			if fmt.Sprint(seqs) != fmt.Sprint(tt.want) {
+				t.Errorf("delivered %v, want %v", seqs, tt.want)
+			}
+			if fmt.Sprint(gaps) != fmt.Sprint(tt.gaps) {
+				t.Errorf("gaps %v, want %v", gaps, tt.gaps)
+			}
+			for _, g := range gaps {
+				if g.Reset() != (g.Next <= g.After) || g.Reset() && g.Missing() != 0 {
+					t.Errorf("gap %+v: Reset %v, Missing %d", g, g.Reset(), g.Missing())
+				}
+			}
+		})
+	}
+}
+
+func TestReplayDropsDelivered(t *testing.T) {
+	client := NewRetinaClient(Config{BatchSize: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// A recording spanning a reset, resumed after 2.
+	r := strings.NewReader(stream(1, 2, 3, 1, 2))
+	seqs, gaps, err := collect(t, client.Replay(ctx, r, 0, StreamOptions{After: 2}), cancel, 10)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if fmt.Sprint(seqs) != "[3 1 2]" || len(gaps) != 1 || !gaps[0].Reset() {
+		t.Errorf("replayed %v with gaps %v, want [3 1 2] and a reset", seqs, gaps)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Query().Get(DefaultResumeParam))
+		n := len(requests)
+		mu.Unlock()
+		switch n {
+		case 1:
+			// A half-open connection: two FIEs, then nothing.
+			fmt.Fprint(w, stream(1, 2))
+			w.(http.Flusher).Flush()
+			<-release
+		case 2:
+			// Nothing at all, not even the headers.
+			<-release
+		default:
+			fmt.Fprint(w, stream(3))
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := NewRetinaClient(Config{Endpoint: srv.URL, BatchSize: 1, IdleTimeout: 50 * time.Millisecond, ReconnectDelay: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var (
+		seqs   []uint64
+		causes []string
+	)
+	for r := range client.Stream(ctx) {
+		if r.Reconnect != nil {
+			causes = append(causes, r.Reconnect.Cause.Error())
+		}
+		for _, fie := range r.Batch {
+			seqs = append(seqs, fie.SequenceNumber)
+		}
+		if len(seqs) == 3 {
+			cancel()
+		}
+	}
+	if fmt.Sprint(seqs) != "[1 2 3]" {
+		t.Errorf("delivered %v, want [1 2 3]", seqs)
+	}
+	if len(causes) < 2 || !strings.Contains(causes[0], "no data received for 50ms") || !strings.Contains(causes[1], "no data received for 50ms") {
+		t.Errorf("reconnection causes = %q, want two idle timeouts", causes)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) < 3 || requests[1] != "3" || requests[2] != "3" {
+		t.Errorf("resume parameters = %q, want reconnections from 3", requests)
+	}
+}
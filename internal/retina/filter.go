@@ -0,0 +1,154 @@
+package retina
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	api "github.com/dioptra-io/retina-commons/api/v1"
+)
+
+// Filter selects the FIEs delivered by Stream. FIEs are filtered as they are
+// decoded, before batching, and dropped FIEs are counted by criterion. Unset
+// criteria keep every FIE, so the zero Filter keeps everything.
+//
+// The fields must not be modified once the Filter is in use.
+type Filter struct {
+	// AgentIDs keeps the FIEs of these agents.
+	AgentIDs []string
+	// IPVersion keeps the FIEs of this IP version; 0 keeps both.
+	IPVersion api.IPVersion
+	// Protocols keeps the FIEs probed with these protocols.
+	Protocols []api.Protocol
+	// Destinations keeps the FIEs whose destination address falls within one
+	// of these prefixes. IPv4 prefixes match IPv4 destinations, whether or
+	// not they are IPv4-mapped.
+	Destinations []netip.Prefix
+	// MinTTL and MaxTTL keep the FIEs whose near probe TTL is within the
+	// inclusive range; a MaxTTL of 0 leaves the range unbounded above.
+	MinTTL, MaxTTL uint8
+
+	once sync.Once
+	// destinations indexes Destinations by prefix length.
+	destinations map[netip.Prefix]struct{}
+	lengths4     []int
+	lengths6     []int
+
+	agentID, ipVersion, protocol, destination, ttl atomic.Uint64
+}
+
+// FilterCounts is the number of FIEs dropped by each criterion of a Filter.
+// A FIE failing several criteria is counted once, under the first in field
+// order.
+type FilterCounts struct {
+	AgentID     uint64
+	IPVersion   uint64
+	Protocol    uint64
+	Destination uint64
+	TTL         uint64
+}
+
+// Total returns the number of FIEs dropped.
+func (c FilterCounts) Total() uint64 {
+	return c.AgentID + c.IPVersion + c.Protocol + c.Destination + c.TTL
+}
+
+// Dropped returns the number of FIEs dropped so far. It is safe to call
+// while a stream is running. A nil Filter drops nothing.
+func (f *Filter) Dropped() FilterCounts {
+	if f == nil {
+		return FilterCounts{}
+	}
+	return FilterCounts{
+		AgentID:     f.agentID.Load(),
+		IPVersion:   f.ipVersion.Load(),
+		Protocol:    f.protocol.Load(),
+		Destination: f.destination.Load(),
+		TTL:         f.ttl.Load(),
+	}
+}
+
+// Keep reports whether fie passes the filter, counting it if not. A nil
+// Filter keeps every FIE.
+func (f *Filter) Keep(fie *SequencedFIE) bool {
+	if f == nil {
+		return true
+	}
+	f.once.Do(f.index)
+
+	switch {
+	case len(f.AgentIDs) > 0 && !slices.Contains(f.AgentIDs, fie.Agent.AgentID):
+		f.agentID.Add(1)
+	case f.IPVersion != 0 && fie.IPVersion != f.IPVersion:
+		f.ipVersion.Add(1)
+	case len(f.Protocols) > 0 && !slices.Contains(f.Protocols, fie.Protocol):
+		f.protocol.Add(1)
+	case f.destinations != nil && !f.matchDestination(fie):
+		f.destination.Add(1)
+	case !f.matchTTL(fie):
+		f.ttl.Add(1)
+	default:
+		return true
+	}
+	return false
+}
+
+// index builds the destination lookup: a set of masked prefixes and the
+// distinct prefix lengths per address family, so that a destination is
+// matched with one lookup per length.
+func (f *Filter) index() {
+	if len(f.Destinations) == 0 {
+		return
+	}
+	f.destinations = make(map[netip.Prefix]struct{}, len(f.Destinations))
+	for _, p := range f.Destinations {
+		if a := p.Addr(); a.Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(a.Unmap(), p.Bits()-96)
+		}
+		p = p.Masked()
+		f.destinations[p] = struct{}{}
+		if p.Addr().Is4() {
+			f.lengths4 = append(f.lengths4, p.Bits())
+		} else {
+			f.lengths6 = append(f.lengths6, p.Bits())
+		}
+	}
+	slices.Sort(f.lengths4)
+	f.lengths4 = slices.Compact(f.lengths4)
+	slices.Sort(f.lengths6)
+	f.lengths6 = slices.Compact(f.lengths6)
+}
+
+func (f *Filter) matchDestination(fie *SequencedFIE) bool {
+	addr, ok := netip.AddrFromSlice(fie.DestinationAddress)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	lengths := f.lengths6
+	if addr.Is4() {
+		lengths = f.lengths4
+	}
+	for _, bits := range lengths {
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if _, ok := f.destinations[p]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchTTL(fie *SequencedFIE) bool {
+	if f.MinTTL == 0 && f.MaxTTL == 0 {
+		return true
+	}
+	if fie.NearInfo == nil {
+		return false
+	}
+	ttl := fie.NearInfo.ProbeTTL
+	return ttl >= f.MinTTL && (f.MaxTTL == 0 || ttl <= f.MaxTTL)
+}
@@ -1,20 +1,33 @@
 package ripe
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	DefaultEndpoint   = "https://stat.ripe.net"
-	DefaultMaxRetries = 3
-	DefaultRetryDelay = 2 * time.Second
+	DefaultEndpoint    = "https://stat.ripe.net"
+	DefaultMaxRetries  = 3
+	DefaultRetryDelay  = 2 * time.Second
+	DefaultConcurrency = 4
+	// DefaultMaxRetryDelay caps the exponential backoff between retries.
+	DefaultMaxRetryDelay = time.Minute
+	// DefaultSourceApp identifies this tool to RIPE Stat, as its fair-use
+	// policy asks of regular users.
+	DefaultSourceApp = "mpat"
+	// DefaultRateLimit keeps well within the RIPE Stat fair-use limits.
+	DefaultRateLimit = 4.0
 )
 
 // Tier1ASNs hardcodes the tier 1 ASNs retieved from Better Targeting document
@@ -44,12 +57,31 @@ type RipeConfig struct {
 	// Defaults to https://stat.ripe.net.
 	Endpoint string
 	// MaxRetries is the maximum number of attempts for a failed request.
-	// Retries are only performed on network errors and 5xx responses.
+	// Retries are only performed on network errors, 429 and 5xx responses.
 	// Defaults to DefaultMaxRetries.
 	MaxRetries int
-	// RetryDelay is the duration to wait between retry attempts.
-	// Defaults to DefaultRetryDelay.
+	// RetryDelay is the base delay of the exponential backoff between retry
+	// attempts. Defaults to DefaultRetryDelay.
 	RetryDelay time.Duration
+	// MaxRetryDelay caps the backoff. A Retry-After sent by the server is
+	// honoured even if longer. Defaults to DefaultMaxRetryDelay.
+	MaxRetryDelay time.Duration
+	// SourceApp is sent as the sourceapp parameter of every request.
+	// Defaults to DefaultSourceApp.
+	SourceApp string
+	// Concurrency is the number of ASNs fetched at the same time.
+	// Defaults to DefaultConcurrency.
+	Concurrency int
+	// RateLimit is the maximum number of requests per second sent to the
+	// API, retries included. Defaults to DefaultRateLimit; negative disables it.
+	RateLimit float64
+}
+
+func (c *RipeConfig) sourceApp() string {
+	if c.SourceApp == "" {
+		return DefaultSourceApp
+	}
+	return c.SourceApp
 }
 
 func (c *RipeConfig) endpoint() string {
@@ -61,16 +93,24 @@ func (c *RipeConfig) endpoint() string {
 
 // RipeClient is a client for the RIPE Stat Data API.
 // No authentication is required — the API is public.
+// RipeClient is safe for concurrent use; the rate limit is shared by all
+// queries of a client.
 type RipeClient struct {
-	config RipeConfig
-	http   *http.Client
+	config  RipeConfig
+	http    *http.Client
+	limiter *rateLimiter
 }
 
 // NewRipeClient creates a new RipeClient with the given config.
 func NewRipeClient(cfg RipeConfig) *RipeClient {
+	rate := cfg.RateLimit
+	if rate == 0 {
+		rate = DefaultRateLimit
+	}
 	return &RipeClient{
-		config: cfg,
-		http:   &http.Client{Timeout: 30 * time.Second},
+		config:  cfg,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		limiter: newRateLimiter(rate),
 	}
 }
 
@@ -89,8 +129,8 @@ func (c *RipeClient) PrefixesByASN(asn uint32) *PrefixQuery {
 }
 
 // PrefixesByASNs returns a new PrefixQuery for a list of ASNs.
-// Fetch() will query each ASN sequentially and aggregate the results,
-// failing fast on the first error.
+// Fetch() will query the ASNs concurrently and aggregate the results,
+// failing fast on the first error; FetchEach() reports every ASN.
 func (c *RipeClient) PrefixesByASNs(asns []uint32) *PrefixQuery {
 	return &PrefixQuery{
 		client: c,
@@ -118,26 +158,118 @@ func (q *PrefixQuery) AtTime(t time.Time) *PrefixQuery {
 	return q
 }
 
+// ASNResult is the outcome of fetching the prefixes of a single ASN.
+type ASNResult struct {
+	ASN      uint32
+	Prefixes []Prefix
+	Err      error
+}
+
 // Fetch executes the query and returns the list of prefixes originated by all ASNs.
-// Fails fast on the first error.
-func (q *PrefixQuery) Fetch() ([]Prefix, error) {
+// Fails fast on the first error: the ASNs in flight are canceled, no further
+// ASN is started and the error that caused the failure is returned.
+func (q *PrefixQuery) Fetch(ctx context.Context) ([]Prefix, error) {
 	if q.err != nil {
 		return nil, q.err
 	}
 
+	results, err := q.fetch(ctx, true)
+	if err != nil {
+		return nil, err
+	}
 	var all []Prefix
-	for _, asn := range q.asns {
-		prefixes, err := q.fetchASN(asn)
-		if err != nil {
-			return nil, err
-		}
-		all = append(all, prefixes...)
+	for _, r := range results {
+		all = append(all, r.Prefixes...)
 	}
 	return all, nil
 }
 
+// FetchEach executes the query and returns one result per ASN, in query
+// order. A failed ASN does not stop the others; canceling ctx does, and the
+// ASNs not fetched carry the context error.
+func (q *PrefixQuery) FetchEach(ctx context.Context) ([]ASNResult, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	return q.fetch(ctx, false)
+}
+
+// fetch queries the ASNs with the configured concurrency. With failFast, the
+// first error cancels the remaining ASNs and is returned.
+func (q *PrefixQuery) fetch(ctx context.Context, failFast bool) ([]ASNResult, error) {
+	results := make([]ASNResult, len(q.asns))
+	err := q.client.forEach(ctx, len(q.asns), failFast,
+		func(ctx context.Context, i int) error {
+			prefixes, err := q.fetchASN(ctx, q.asns[i])
+			results[i] = ASNResult{ASN: q.asns[i], Prefixes: prefixes, Err: err}
+			return err
+		},
+		func(i int, err error) {
+			results[i] = ASNResult{ASN: q.asns[i], Err: fmt.Errorf("ripe: ASN %d not fetched: %w", q.asns[i], err)}
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// forEach calls fetch for every index in [0, n) with the configured
+// concurrency. With failFast, the first error returned by fetch cancels the
+// calls in flight, no further call is started and the error is returned.
+// The indices never started because ctx was canceled are passed to skipped
+// with the context error.
+func (c *RipeClient) forEach(ctx context.Context, n int, failFast bool, fetch func(ctx context.Context, i int) error, skipped func(i int, err error)) error {
+	concurrency := c.config.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	next := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for range min(concurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fetch(ctx, i); err != nil && failFast {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						cancel()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range n {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			for j := i; j < n; j++ {
+				skipped(j, ctx.Err())
+			}
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+	return firstErr
+}
+
 // fetchASN fetches prefixes for a single ASN.
-func (q *PrefixQuery) fetchASN(asn uint32) ([]Prefix, error) {
+// Network errors, 429 and 5xx responses are retried with exponential backoff
+// and jitter, waiting for Retry-After instead when a 429 or 503 carries one.
+func (q *PrefixQuery) fetchASN(ctx context.Context, asn uint32) ([]Prefix, error) {
 	params := url.Values{}
 	params.Set("resource", fmt.Sprintf("AS%d", asn))
 	params.Set("list_prefixes", "true")
@@ -146,75 +278,192 @@ func (q *PrefixQuery) fetchASN(asn uint32) ([]Prefix, error) {
 	if !q.queryTime.IsZero() {
 		params.Set("query_time", q.queryTime.UTC().Format(time.RFC3339))
 	}
-	u := fmt.Sprintf("%s/data/ris-prefixes/data.json?%s", q.client.config.endpoint(), params.Encode())
 
-	maxRetries := q.client.config.MaxRetries
-	if maxRetries == 0 {
-		maxRetries = DefaultMaxRetries
+	var result risPrefixesResponse
+	if err := q.client.getJSON(ctx, "ris-prefixes", params, &result); err != nil {
+		return nil, fmt.Errorf("ripe: failed to fetch prefixes for ASN %d: %w", asn, err)
 	}
-	retryDelay := q.client.config.RetryDelay
-	if retryDelay == 0 {
-		retryDelay = DefaultRetryDelay
+
+	queryTime, err := parseQueryTime(result.Data.QueryTime)
+	if err != nil {
+		return nil, err
 	}
 
-	var lastErr error
-	for attempt := range maxRetries {
-		resp, err := q.client.http.Get(u)
+	var prefixes []Prefix
+	for _, raw := range result.Data.Prefixes.V4.Originating {
+		p, err := parsePrefix(asn, raw, queryTime, false)
 		if err != nil {
-			// Network error — retryable.
-			lastErr = fmt.Errorf("ripe: request failed for ASN %d: %w", asn, err)
-			if attempt < maxRetries-1 {
-				time.Sleep(retryDelay)
-			}
-			continue
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	for _, raw := range result.Data.Prefixes.V6.Originating {
+		p, err := parsePrefix(asn, raw, queryTime, true)
+		if err != nil {
+			return nil, err
 		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			lastErr = fmt.Errorf("ripe: unexpected status %d for ASN %d", resp.StatusCode, asn)
-			// Only retry on 5xx.
-			if resp.StatusCode >= 500 && attempt < maxRetries-1 {
-				time.Sleep(retryDelay)
-				continue
+// getJSON queries a RIPE Stat data call and decodes its response into out,
+// which must have a top-level status field. Retryable failures are retried
+// up to MaxRetries attempts in total.
+func (c *RipeClient) getJSON(ctx context.Context, call string, params url.Values, out any) error {
+	params.Set("sourceapp", c.config.sourceApp())
+	u := fmt.Sprintf("%s/data/%s/data.json?%s", c.config.endpoint(), call, params.Encode())
+
+	maxRetries := c.config.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	var lastErr error
+	for attempt := range maxRetries {
+		if attempt > 0 {
+			if err := sleep(ctx, c.retryDelay(attempt, lastErr)); err != nil {
+				return err
 			}
-			break
 		}
-
-		var result risPrefixesResponse
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			resp.Body.Close()
-			return nil, fmt.Errorf("ripe: failed to decode response for ASN %d: %w", asn, err)
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
 		}
-		resp.Body.Close()
 
-		if result.Status != "ok" {
-			return nil, fmt.Errorf("ripe: API returned status %q for ASN %d", result.Status, asn)
+		err := c.get(ctx, u, out)
+		if err == nil {
+			return nil
 		}
-
-		queryTime, err := time.Parse("2006-01-02T15:04:05", result.Data.QueryTime)
-		if err != nil {
-			return nil, fmt.Errorf("ripe: failed to parse query_time %q: %w", result.Data.QueryTime, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.Retryable() {
+			return err
 		}
+	}
+	return fmt.Errorf("gave up after %d attempt(s): %w", maxRetries, lastErr)
+}
 
-		var prefixes []Prefix
-		for _, raw := range result.Data.Prefixes.V4.Originating {
-			p, err := parsePrefix(asn, raw, queryTime, false)
-			if err != nil {
-				return nil, err
-			}
-			prefixes = append(prefixes, p)
+// get sends a single GET request and decodes the response.
+func (c *RipeClient) get(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
 		}
-		for _, raw := range result.Data.Prefixes.V6.Originating {
-			p, err := parsePrefix(asn, raw, queryTime, true)
-			if err != nil {
-				return nil, err
-			}
-			prefixes = append(prefixes, p)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if status.Status != "ok" {
+		// Not retried: the API answered, the request itself is at fault.
+		return &StatusError{StatusCode: resp.StatusCode, APIStatus: status.Status}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// retryDelay returns how long to wait before the given attempt (1 for the
+// first retry): the server's Retry-After if lastErr carries one, otherwise
+// RetryDelay doubled on each attempt, capped at MaxRetryDelay, with the upper
+// half randomised so that concurrent workers do not retry in lockstep.
+func (c *RipeClient) retryDelay(attempt int, lastErr error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(lastErr, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+
+	base := c.config.RetryDelay
+	if base <= 0 {
+		base = DefaultRetryDelay
+	}
+	maxDelay := c.config.MaxRetryDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxRetryDelay
+	}
+	d := base
+	for range attempt - 1 {
+		d *= 2
+		if d >= maxDelay {
+			d = maxDelay
+			break
 		}
-		return prefixes, nil
 	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// StatusError is returned when RIPE Stat answers with a non-200 HTTP status
+// or a status other than "ok" in the response body.
+type StatusError struct {
+	StatusCode int
+	// APIStatus is the status field of the response body, set when the HTTP
+	// status was 200 but the data call failed.
+	APIStatus string
+	// RetryAfter is the delay requested by the Retry-After header, if any.
+	RetryAfter time.Duration
+}
 
-	return nil, fmt.Errorf("ripe: gave up after %d attempt(s) for ASN %d: %w", maxRetries, asn, lastErr)
+func (e *StatusError) Error() string {
+	if e.APIStatus != "" {
+		return fmt.Sprintf("API returned status %q", e.APIStatus)
+	}
+	return fmt.Sprintf("unexpected status %d", e.StatusCode)
+}
+
+// Retryable reports whether the request may succeed if sent again.
+func (e *StatusError) Retryable() bool {
+	return e.APIStatus == "" && (e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date. It returns 0 if the header is absent or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // parsePrefix parses a prefix string like "67.24.0.0/13" or "2001:13b2::/32"
@@ -247,3 +496,37 @@ func parsePrefix(asn uint32, raw string, queryTime time.Time, isV6 bool) (Prefix
 		QueryTime: queryTime,
 	}, nil
 }
+
+// rateLimiter spaces requests evenly to at most a given number per second.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest time of the next request
+}
+
+// newRateLimiter returns a limiter for perSecond requests per second, or a
+// no-op limiter if perSecond is not positive.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the caller may send a request or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	return sleep(ctx, time.Until(at))
+}
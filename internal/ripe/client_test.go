@@ -0,0 +1,324 @@
+package ripe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/ripe/ripetest"
+)
+
+var snapshotTime = time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC)
+
+// newTestClient starts a stand-in serving a snapshot of AS1 to AS6 and
+// returns a client for it. Unset delays of cfg are kept short so that
+// retries do not slow the tests down, and the rate limit is disabled.
+func newTestClient(t *testing.T, cfg RipeConfig) (*RipeClient, *ripetest.Server) {
+	t.Helper()
+	srv := ripetest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddSnapshot(1, snapshotTime, "192.0.2.0/24", "2001:db8::/32")
+	for asn := uint32(2); asn <= 6; asn++ {
+		srv.AddSnapshot(asn, snapshotTime, "198.51.100.0/24")
+	}
+
+	cfg.Endpoint = srv.URL
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = 20 * time.Millisecond
+	}
+	if cfg.RateLimit == 0 {
+		cfg.RateLimit = -1
+	}
+	return NewRipeClient(cfg), srv
+}
+
+func TestFetchPrefixes(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{})
+
+	prefixes, err := client.PrefixesByASN(1).AtTime(snapshotTime).Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if len(prefixes) != 2 {
+		t.Fatalf("got %d prefixes, want 2", len(prefixes))
+	}
+	v4, v6 := prefixes[0], prefixes[1]
+	if v4.Network.String() != "192.0.2.0" || v4.PrefixLen != 24 || len(v4.Network) != 16 {
+		t.Errorf("IPv4 prefix = %s/%d (%d bytes), want 192.0.2.0/24 stored IPv4-mapped", v4.Network, v4.PrefixLen, len(v4.Network))
+	}
+	if v6.Network.String() != "2001:db8::" || v6.PrefixLen != 32 {
+		t.Errorf("IPv6 prefix = %s/%d, want 2001:db8::/32", v6.Network, v6.PrefixLen)
+	}
+	if !v4.QueryTime.Equal(snapshotTime) {
+		t.Errorf("query time = %s, want %s", v4.QueryTime, snapshotTime)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(reqs))
+	}
+	if reqs[0].QueryTime != "2026-06-01T08:00:00Z" {
+		t.Errorf("query_time = %q, want 2026-06-01T08:00:00Z", reqs[0].QueryTime)
+	}
+}
+
+func TestSourceApp(t *testing.T) {
+	tests := []struct {
+		name      string
+		sourceApp string
+		want      string
+	}{
+		{"default", "", DefaultSourceApp},
+		{"configured", "my-study", "my-study"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, srv := newTestClient(t, RipeConfig{SourceApp: tt.sourceApp})
+			if _, err := client.PrefixesByASN(1).Fetch(context.Background()); err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			for _, r := range srv.Requests() {
+				if r.SourceApp != tt.want {
+					t.Errorf("sourceapp = %q, want %q", r.SourceApp, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryOnServerError(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{MaxRetries: 3, RetryDelay: 40 * time.Millisecond})
+	srv.Fail("AS1", ripetest.Failure{Status: http.StatusInternalServerError, Times: 2})
+
+	if _, err := client.PrefixesByASN(1).Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	reqs := srv.Requests()
+	wantStatus := []int{500, 500, 200}
+	if len(reqs) != len(wantStatus) {
+		t.Fatalf("got %d requests, want %d", len(reqs), len(wantStatus))
+	}
+	for i, r := range reqs {
+		if r.Status != wantStatus[i] {
+			t.Errorf("request %d status = %d, want %d", i, r.Status, wantStatus[i])
+		}
+	}
+	// The backoff doubles: the first retry waits at least half of
+	// RetryDelay, the second at least RetryDelay.
+	if gap := reqs[1].At.Sub(reqs[0].At); gap < 20*time.Millisecond {
+		t.Errorf("first retry after %s, want at least 20ms", gap)
+	}
+	if gap := reqs[2].At.Sub(reqs[1].At); gap < 40*time.Millisecond {
+		t.Errorf("second retry after %s, want at least 40ms", gap)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{MaxRetries: 3, RetryDelay: time.Millisecond})
+	srv.Fail("AS1", ripetest.Failure{Status: http.StatusBadGateway, Times: 10})
+
+	_, err := client.PrefixesByASN(1).Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "gave up after 3 attempt(s)") {
+		t.Fatalf("Fetch error = %v, want giving up after 3 attempts", err)
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("Fetch error = %v, want a StatusError with status 502", err)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{MaxRetries: 3})
+	srv.Fail("AS1", ripetest.Failure{Status: http.StatusBadRequest, Times: 1})
+
+	if _, err := client.PrefixesByASN(1).Fetch(context.Background()); err == nil {
+		t.Fatal("Fetch succeeded, want an error")
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			t.Parallel()
+			// Retry-After is honoured even though it exceeds MaxRetryDelay.
+			client, srv := newTestClient(t, RipeConfig{RetryDelay: time.Millisecond, MaxRetryDelay: 10 * time.Millisecond})
+			srv.Fail("AS1", ripetest.Failure{Status: status, RetryAfter: "1", Times: 1})
+
+			if _, err := client.PrefixesByASN(1).Fetch(context.Background()); err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			reqs := srv.Requests()
+			if len(reqs) != 2 {
+				t.Fatalf("got %d requests, want 2", len(reqs))
+			}
+			if gap := reqs[1].At.Sub(reqs[0].At); gap < time.Second {
+				t.Errorf("retried after %s, want at least the 1s Retry-After", gap)
+			}
+		})
+	}
+}
+
+func TestCancelDuringBackoff(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{})
+	srv.Fail("AS1", ripetest.Failure{Status: http.StatusServiceUnavailable, RetryAfter: "60", Times: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.PrefixesByASN(1).Fetch(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Fetch error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Fetch returned after %s, want it to stop when ctx is done", elapsed)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestFetchEach(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{MaxRetries: 1})
+	srv.Fail("AS2", ripetest.Failure{Status: http.StatusNotFound, Times: 1})
+
+	results, err := client.PrefixesByASNs([]uint32{1, 2, 3}).FetchEach(context.Background())
+	if err != nil {
+		t.Fatalf("FetchEach: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, asn := range []uint32{1, 2, 3} {
+		if results[i].ASN != asn {
+			t.Errorf("result %d is for ASN %d, want %d", i, results[i].ASN, asn)
+		}
+	}
+	if results[0].Err != nil || len(results[0].Prefixes) != 2 {
+		t.Errorf("ASN 1: %d prefixes, error %v; want 2 prefixes", len(results[0].Prefixes), results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("ASN 2 succeeded, want its error")
+	}
+	if results[2].Err != nil || len(results[2].Prefixes) != 1 {
+		t.Errorf("ASN 3: %d prefixes, error %v; want 1 prefix", len(results[2].Prefixes), results[2].Err)
+	}
+}
+
+func TestFetchEachConcurrency(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	client, srv := newTestClient(t, RipeConfig{Concurrency: 2})
+	srv.SetDelay(delay)
+
+	start := time.Now()
+	results, err := client.PrefixesByASNs([]uint32{1, 2, 3, 4, 5, 6}).FetchEach(context.Background())
+	if err != nil {
+		t.Fatalf("FetchEach: %v", err)
+	}
+	elapsed := time.Since(start)
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("ASN %d: %v", r.ASN, r.Err)
+		}
+	}
+	// Two workers take three rounds for six ASNs; one would take six.
+	if elapsed < 3*delay {
+		t.Errorf("FetchEach took %s, want at least %s with 2 workers", elapsed, 3*delay)
+	}
+	if elapsed >= 6*delay {
+		t.Errorf("FetchEach took %s, want less than %s with 2 workers", elapsed, 6*delay)
+	}
+}
+
+func TestFetchEachCanceled(t *testing.T) {
+	client, srv := newTestClient(t, RipeConfig{Concurrency: 1})
+	srv.SetDelay(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results, err := client.PrefixesByASNs([]uint32{1, 2, 3}).FetchEach(ctx)
+	if err != nil {
+		t.Fatalf("FetchEach: %v", err)
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.DeadlineExceeded) {
+			t.Errorf("ASN %d error = %v, want context.DeadlineExceeded", r.ASN, r.Err)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// Retries count against the limit too.
+	client, srv := newTestClient(t, RipeConfig{Concurrency: 4, RateLimit: 20, RetryDelay: time.Millisecond})
+	srv.Fail("AS1", ripetest.Failure{Status: http.StatusInternalServerError, Times: 1})
+
+	if _, err := client.PrefixesByASNs([]uint32{1, 2, 3, 4}).FetchEach(context.Background()); err != nil {
+		t.Fatalf("FetchEach: %v", err)
+	}
+	reqs := srv.Requests()
+	if len(reqs) != 5 {
+		t.Fatalf("got %d requests, want 5", len(reqs))
+	}
+	// 20 requests per second are spaced by 50ms; allow for scheduling.
+	for i := 1; i < len(reqs); i++ {
+		if gap := reqs[i].At.Sub(reqs[i-1].At); gap < 40*time.Millisecond {
+			t.Errorf("request %d sent %s after the previous one, want about 50ms", i, gap)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	client := NewRipeClient(RipeConfig{RetryDelay: 100 * time.Millisecond, MaxRetryDelay: 300 * time.Millisecond})
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 300 * time.Millisecond}, // capped
+		{10, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			d := client.retryDelay(tt.attempt, errors.New("connection reset"))
+			if d < tt.max/2 || d > tt.max {
+				t.Errorf("retryDelay(%d) = %s, want within [%s, %s]", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+
+	retryAfter := &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}
+	if d := client.retryDelay(1, retryAfter); d != 5*time.Second {
+		t.Errorf("retryDelay with Retry-After = %s, want 5s", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package ripe
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// NeighbourQuery is a builder for the asn-neighbours endpoint.
+type NeighbourQuery struct {
+	client    *RipeClient
+	asns      []uint32
+	queryTime time.Time
+	err       error // stored here, surfaced at FetchEach()
+}
+
+// NeighboursByASNs returns a new NeighbourQuery for a list of ASNs.
+func (c *RipeClient) NeighboursByASNs(asns []uint32) *NeighbourQuery {
+	return &NeighbourQuery{
+		client: c,
+		asns:   asns,
+	}
+}
+
+// At sets the query time using a date and a TimeOfDay enum value, as
+// PrefixQuery.At does.
+func (q *NeighbourQuery) At(date time.Time, tod TimeOfDay) *NeighbourQuery {
+	t, err := tod.QueryTime(date)
+	if err != nil {
+		q.err = err
+		return q
+	}
+	q.queryTime = t
+	return q
+}
+
+// AtTime sets the query time using a raw timestamp.
+func (q *NeighbourQuery) AtTime(t time.Time) *NeighbourQuery {
+	q.queryTime = t.UTC()
+	return q
+}
+
+// NeighboursResult is the outcome of fetching the neighbours of a single ASN.
+type NeighboursResult struct {
+	ASN        uint32
+	Neighbours []Neighbour
+	Err        error
+}
+
+// FetchEach executes the query and returns one result per ASN, in query
+// order. A failed ASN does not stop the others; canceling ctx does, and the
+// ASNs not fetched carry the context error.
+func (q *NeighbourQuery) FetchEach(ctx context.Context) ([]NeighboursResult, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	results := make([]NeighboursResult, len(q.asns))
+	err := q.client.forEach(ctx, len(q.asns), false,
+		func(ctx context.Context, i int) error {
+			neighbours, err := q.fetchASN(ctx, q.asns[i])
+			results[i] = NeighboursResult{ASN: q.asns[i], Neighbours: neighbours, Err: err}
+			return err
+		},
+		func(i int, err error) {
+			results[i] = NeighboursResult{ASN: q.asns[i], Err: fmt.Errorf("ripe: ASN %d not fetched: %w", q.asns[i], err)}
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// fetchASN fetches the neighbours of a single ASN.
+func (q *NeighbourQuery) fetchASN(ctx context.Context, asn uint32) ([]Neighbour, error) {
+	params := url.Values{}
+	params.Set("resource", fmt.Sprintf("AS%d", asn))
+	params.Set("lod", "1") // include power and peer counts
+	if !q.queryTime.IsZero() {
+		params.Set("query_time", q.queryTime.Format(time.RFC3339))
+	}
+
+	var result asnNeighboursResponse
+	if err := q.client.getJSON(ctx, "asn-neighbours", params, &result); err != nil {
+		return nil, fmt.Errorf("ripe: failed to fetch neighbours of ASN %d: %w", asn, err)
+	}
+	queryTime, err := parseQueryTime(result.Data.QueryEndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	neighbours := make([]Neighbour, 0, len(result.Data.Neighbours))
+	for _, n := range result.Data.Neighbours {
+		neighbours = append(neighbours, Neighbour{
+			ASN:       asn,
+			Neighbour: n.ASN,
+			Type:      n.Type,
+			Power:     n.Power,
+			V4Peers:   n.V4Peers,
+			V6Peers:   n.V6Peers,
+			QueryTime: queryTime,
+		})
+	}
+	return neighbours, nil
+}
+
+// ExpandedASN is an ASN reached by Expand.
+type ExpandedASN struct {
+	ASN uint32
+	// Hops is the number of neighbour hops from the queried ASNs, 0 for them.
+	Hops int
+	// Via is the ASN of the previous hop through which ASN was first
+	// reached, 0 for the queried ASNs.
+	Via uint32
+}
+
+// Expand returns the queried ASNs followed by every ASN up to hops neighbour
+// hops away from them, breadth first, each once at its shortest distance.
+// Only neighbours of the given types ("left", "right", "uncertain") are
+// followed; none means all. The first failed ASN fails the expansion, since
+// the set would otherwise silently depend on transient errors.
+//
+// The set grows quickly: one hop from the tier-1 ASNs is already thousands
+// of ASNs, each of which costs a request at the next hop.
+func (q *NeighbourQuery) Expand(ctx context.Context, hops int, types ...string) ([]ExpandedASN, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	seen := make(map[uint32]bool)
+	var expanded []ExpandedASN
+	var frontier []uint32
+	for _, asn := range q.asns {
+		if !seen[asn] {
+			seen[asn] = true
+			expanded = append(expanded, ExpandedASN{ASN: asn})
+			frontier = append(frontier, asn)
+		}
+	}
+
+	for hop := 1; hop <= hops && len(frontier) > 0; hop++ {
+		next := *q
+		next.asns = frontier
+		results, err := next.FetchEach(ctx)
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, r := range results {
+			if r.Err != nil {
+				return nil, r.Err
+			}
+			for _, n := range r.Neighbours {
+				if seen[n.Neighbour] || (len(types) > 0 && !slices.Contains(types, n.Type)) {
+					continue
+				}
+				seen[n.Neighbour] = true
+				expanded = append(expanded, ExpandedASN{ASN: n.Neighbour, Hops: hop, Via: r.ASN})
+				frontier = append(frontier, n.Neighbour)
+			}
+		}
+	}
+	return expanded, nil
+}
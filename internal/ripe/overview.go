@@ -0,0 +1,197 @@
+package ripe
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PrefixOverviewQuery is a builder for the prefix-overview endpoint.
+type PrefixOverviewQuery struct {
+	client    *RipeClient
+	resources []string
+	queryTime time.Time
+	err       error // stored here, surfaced at FetchEach()
+}
+
+// PrefixOverviews returns a new PrefixOverviewQuery for a list of prefixes
+// or addresses, e.g. "193.0.0.0/21" or "2001:67c:2e8::1". Invalid resources
+// are reported at FetchEach() time.
+func (c *RipeClient) PrefixOverviews(resources []string) *PrefixOverviewQuery {
+	q := &PrefixOverviewQuery{client: c}
+	for _, r := range resources {
+		if _, err := parseResource(r); err != nil {
+			q.err = err
+			break
+		}
+		q.resources = append(q.resources, r)
+	}
+	return q
+}
+
+// AtTime sets the query time. Without it, RIPE Stat answers from its latest data.
+func (q *PrefixOverviewQuery) AtTime(t time.Time) *PrefixOverviewQuery {
+	q.queryTime = t.UTC()
+	return q
+}
+
+// PrefixOverviewResult is the outcome of fetching the overview of a single
+// resource.
+type PrefixOverviewResult struct {
+	Resource string
+	Overview PrefixOverview
+	Err      error
+}
+
+// FetchEach executes the query and returns one result per resource, in query
+// order. A failed resource does not stop the others; canceling ctx does, and
+// the resources not fetched carry the context error.
+func (q *PrefixOverviewQuery) FetchEach(ctx context.Context) ([]PrefixOverviewResult, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	results := make([]PrefixOverviewResult, len(q.resources))
+	err := q.client.forEach(ctx, len(q.resources), false,
+		func(ctx context.Context, i int) error {
+			ov, err := q.fetchResource(ctx, q.resources[i])
+			results[i] = PrefixOverviewResult{Resource: q.resources[i], Overview: ov, Err: err}
+			return err
+		},
+		func(i int, err error) {
+			results[i] = PrefixOverviewResult{Resource: q.resources[i], Err: fmt.Errorf("ripe: %s not fetched: %w", q.resources[i], err)}
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// fetchResource fetches the overview of a single resource.
+func (q *PrefixOverviewQuery) fetchResource(ctx context.Context, resource string) (PrefixOverview, error) {
+	params := url.Values{}
+	params.Set("resource", resource)
+	if !q.queryTime.IsZero() {
+		params.Set("query_time", q.queryTime.Format(time.RFC3339))
+	}
+
+	var result prefixOverviewResponse
+	if err := q.client.getJSON(ctx, "prefix-overview", params, &result); err != nil {
+		return PrefixOverview{}, fmt.Errorf("ripe: failed to fetch prefix overview of %s: %w", resource, err)
+	}
+
+	ov := PrefixOverview{
+		Resource:        resource,
+		Announced:       result.Data.Announced,
+		LessSpecific:    result.Data.IsLessSpecific,
+		Block:           result.Data.Block.Resource,
+		BlockDesc:       result.Data.Block.Desc,
+		RelatedPrefixes: result.Data.ActualNumRelated,
+	}
+	prefix, err := parseResource(result.Data.Resource)
+	if err != nil {
+		return PrefixOverview{}, err
+	}
+	ov.Prefix = prefix
+	for _, a := range result.Data.ASNs {
+		ov.ASNs = append(ov.ASNs, a.ASN)
+		ov.Holders = append(ov.Holders, a.Holder)
+	}
+	if ov.QueryTime, err = parseQueryTime(result.Data.QueryTime); err != nil {
+		return PrefixOverview{}, err
+	}
+	return ov, nil
+}
+
+// NetworkInfoQuery is a builder for the network-info endpoint.
+type NetworkInfoQuery struct {
+	client *RipeClient
+	addrs  []netip.Addr
+}
+
+// NetworkInfo returns a new NetworkInfoQuery for a list of addresses.
+// network-info only reflects the latest RIS data; it has no query time.
+func (c *RipeClient) NetworkInfo(addrs []netip.Addr) *NetworkInfoQuery {
+	return &NetworkInfoQuery{client: c, addrs: addrs}
+}
+
+// NetworkInfoResult is the outcome of looking up a single address.
+type NetworkInfoResult struct {
+	Address netip.Addr
+	Info    NetworkInfo
+	Err     error
+}
+
+// FetchEach executes the query and returns one result per address, in query
+// order. A failed address does not stop the others; canceling ctx does, and
+// the addresses not fetched carry the context error.
+func (q *NetworkInfoQuery) FetchEach(ctx context.Context) ([]NetworkInfoResult, error) {
+	results := make([]NetworkInfoResult, len(q.addrs))
+	err := q.client.forEach(ctx, len(q.addrs), false,
+		func(ctx context.Context, i int) error {
+			info, err := q.fetchAddr(ctx, q.addrs[i])
+			results[i] = NetworkInfoResult{Address: q.addrs[i], Info: info, Err: err}
+			return err
+		},
+		func(i int, err error) {
+			results[i] = NetworkInfoResult{Address: q.addrs[i], Err: fmt.Errorf("ripe: %s not fetched: %w", q.addrs[i], err)}
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// fetchAddr looks up a single address.
+func (q *NetworkInfoQuery) fetchAddr(ctx context.Context, addr netip.Addr) (NetworkInfo, error) {
+	addr = addr.Unmap()
+	params := url.Values{}
+	params.Set("resource", addr.String())
+
+	var result networkInfoResponse
+	if err := q.client.getJSON(ctx, "network-info", params, &result); err != nil {
+		return NetworkInfo{}, fmt.Errorf("ripe: failed to fetch network info of %s: %w", addr, err)
+	}
+
+	info := NetworkInfo{Address: addr}
+	if result.Data.Prefix != "" {
+		prefix, err := netip.ParsePrefix(result.Data.Prefix)
+		if err != nil {
+			return NetworkInfo{}, fmt.Errorf("ripe: invalid prefix %q for %s: %w", result.Data.Prefix, addr, err)
+		}
+		info.Prefix = prefix
+	}
+	for _, s := range result.Data.ASNs {
+		asn, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return NetworkInfo{}, fmt.Errorf("ripe: invalid ASN %q for %s: %w", s, addr, err)
+		}
+		info.ASNs = append(info.ASNs, uint32(asn))
+	}
+	return info, nil
+}
+
+// parseResource parses a prefix, or an address as a single-address prefix.
+func parseResource(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("ripe: invalid prefix or address %q", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseQueryTime parses a RIPE Stat timestamp, e.g. "2026-06-01T08:00:00".
+func parseQueryTime(s string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02T15:04:05", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ripe: failed to parse query_time %q: %w", s, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,460 @@
+// Package ripetest provides an in-memory stand-in for the RIPE Stat Data API,
+// for testing the ripe client and the services built on it without reaching
+// stat.ripe.net.
+//
+// A Server serves the ris-prefixes, prefix-overview and network-info data
+// calls from snapshots registered with AddSnapshot, and the asn-neighbours
+// data call from neighbours registered with AddNeighbours. It records every
+// request, and can be told to fail the next requests for a resource with a
+// given HTTP status and Retry-After header to exercise retries, backoff and
+// rate limiting.
+package ripetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request is a request received by a Server.
+type Request struct {
+	Call      string // data call, e.g. "ris-prefixes"
+	Resource  string // resource parameter, e.g. "AS3356"
+	QueryTime string // query_time parameter, empty if absent
+	SourceApp string // sourceapp parameter, empty if absent
+	At        time.Time
+	Status    int // HTTP status of the response
+}
+
+// Failure describes how the next requests for a resource fail.
+type Failure struct {
+	// Status is the HTTP status returned, e.g. 429 or 503.
+	Status int
+	// RetryAfter is the value of the Retry-After header, omitted if empty.
+	RetryAfter string
+	// Times is the number of requests that fail before the resource is
+	// served normally again.
+	Times int
+}
+
+// Server is a running RIPE Stat stand-in. Its methods are safe for
+// concurrent use.
+type Server struct {
+	// URL is the base URL of the API, to be used as ripe.RipeConfig.Endpoint.
+	URL string
+
+	srv *httptest.Server
+
+	mu         sync.Mutex
+	snapshots  map[uint32][]snapshot          // by ASN, sorted by time
+	neighbours map[uint32][]neighbourSnapshot // by ASN, sorted by time
+	failures   map[string]Failure             // by resource
+	delay      time.Duration
+	requests   []Request
+}
+
+type snapshot struct {
+	time     time.Time
+	prefixes []netip.Prefix
+}
+
+// Neighbour is an adjacency served by the asn-neighbours data call.
+type Neighbour struct {
+	ASN   uint32
+	Type  string // "left", "right" or "uncertain"
+	Power uint32
+}
+
+type neighbourSnapshot struct {
+	time       time.Time
+	neighbours []Neighbour
+}
+
+// NewServer starts an empty Server. ASNs without snapshots originate no
+// prefixes, as unknown ASNs do on RIPE Stat. The caller must call Close when
+// done.
+func NewServer() *Server {
+	s := &Server{
+		snapshots:  make(map[uint32][]snapshot),
+		neighbours: make(map[uint32][]neighbourSnapshot),
+		failures:   make(map[string]Failure),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /data/{call}/data.json", s.handleData)
+
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// AddSnapshot registers the prefixes originated by asn at time t. A query
+// is answered from the latest snapshot at or before its query_time, or from
+// the latest snapshot if it has none. Invalid prefixes panic.
+func (s *Server) AddSnapshot(asn uint32, t time.Time, prefixes ...string) {
+	snap := snapshot{time: t.UTC()}
+	for _, p := range prefixes {
+		snap.prefixes = append(snap.prefixes, netip.MustParsePrefix(p))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[asn] = append(s.snapshots[asn], snap)
+	slices.SortStableFunc(s.snapshots[asn], func(a, b snapshot) int {
+		return a.time.Compare(b.time)
+	})
+}
+
+// AddNeighbours registers the neighbours of asn at time t. Queries are
+// answered from the latest registration at or before their query_time, as
+// for AddSnapshot.
+func (s *Server) AddNeighbours(asn uint32, t time.Time, neighbours ...Neighbour) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.neighbours[asn] = append(s.neighbours[asn], neighbourSnapshot{time: t.UTC(), neighbours: neighbours})
+	slices.SortStableFunc(s.neighbours[asn], func(a, b neighbourSnapshot) int {
+		return a.time.Compare(b.time)
+	})
+}
+
+// Fail makes the next f.Times requests for resource (e.g. "AS3356") fail.
+// It replaces any failure still pending for the resource.
+func (s *Server) Fail(resource string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[resource] = f
+}
+
+// SetDelay delays every response by d, to test cancellation.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// Requests returns every request received, in order of arrival.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// ── Handlers ─────────────────────────────────────────────────────────────────
+
+func (s *Server) handleData(w http.ResponseWriter, r *http.Request) {
+	call := r.PathValue("call")
+	q := r.URL.Query()
+	req := Request{
+		Call:      call,
+		Resource:  q.Get("resource"),
+		QueryTime: q.Get("query_time"),
+		SourceApp: q.Get("sourceapp"),
+		At:        time.Now(),
+	}
+
+	s.mu.Lock()
+	delay := s.delay
+	f, failing := s.failures[req.Resource]
+	if failing {
+		f.Times--
+		if f.Times <= 0 {
+			delete(s.failures, req.Resource)
+		} else {
+			s.failures[req.Resource] = f
+		}
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status := http.StatusOK
+	defer func() {
+		req.Status = status
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+	}()
+
+	if failing {
+		status = f.Status
+		if f.RetryAfter != "" {
+			w.Header().Set("Retry-After", f.RetryAfter)
+		}
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	switch call {
+	case "ris-prefixes":
+		status = s.servePrefixes(w, req)
+	case "prefix-overview":
+		status = s.serveOverview(w, req)
+	case "network-info":
+		status = s.serveNetworkInfo(w, req)
+	case "asn-neighbours":
+		status = s.serveNeighbours(w, req)
+	default:
+		status = http.StatusNotFound
+		writeError(w, status, fmt.Sprintf("unknown data call %q", call))
+	}
+}
+
+// servePrefixes answers a ris-prefixes query and returns the HTTP status.
+func (s *Server) servePrefixes(w http.ResponseWriter, req Request) int {
+	asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(req.Resource), "AS"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid resource %q", req.Resource))
+		return http.StatusBadRequest
+	}
+	at := time.Now().UTC()
+	if req.QueryTime != "" {
+		at, err = time.Parse(time.RFC3339, req.QueryTime)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid query_time %q", req.QueryTime))
+			return http.StatusBadRequest
+		}
+	}
+
+	s.mu.Lock()
+	var snap snapshot
+	for _, sn := range s.snapshots[uint32(asn)] {
+		if sn.time.After(at) {
+			break
+		}
+		snap = sn
+	}
+	s.mu.Unlock()
+
+	queryTime := snap.time
+	if queryTime.IsZero() {
+		queryTime = at.Truncate(8 * time.Hour)
+	}
+	v4, v6 := []string{}, []string{}
+	for _, p := range snap.prefixes {
+		if p.Addr().Is4() {
+			v4 = append(v4, p.String())
+		} else {
+			v6 = append(v6, p.String())
+		}
+	}
+
+	writeJSON(w, map[string]any{
+		"status": "ok",
+		"data": map[string]any{
+			"resource":   req.Resource,
+			"query_time": queryTime.Format("2006-01-02T15:04:05"),
+			"prefixes": map[string]any{
+				"v4": map[string]any{"originating": v4},
+				"v6": map[string]any{"originating": v6},
+			},
+		},
+	})
+	return http.StatusOK
+}
+
+// serveOverview answers a prefix-overview query from the snapshots of all
+// ASNs and returns the HTTP status. An exact match is reported as announced;
+// otherwise the most specific covering prefix is, as less specific.
+func (s *Server) serveOverview(w http.ResponseWriter, req Request) int {
+	resource, err := parseResource(req.Resource)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return http.StatusBadRequest
+	}
+	at, status := queryTime(w, req)
+	if status != http.StatusOK {
+		return status
+	}
+
+	match, origins := s.longestMatch(resource, at)
+	asns := []map[string]any{}
+	for _, asn := range origins {
+		asns = append(asns, map[string]any{"asn": asn, "holder": fmt.Sprintf("AS%d", asn)})
+	}
+	announced := match.IsValid()
+	if !announced {
+		match = resource
+	}
+	writeJSON(w, map[string]any{
+		"status": "ok",
+		"data": map[string]any{
+			"resource":           match.String(),
+			"announced":          announced,
+			"is_less_specific":   announced && match != resource,
+			"asns":               asns,
+			"block":              map[string]any{"resource": "", "desc": "", "name": ""},
+			"actual_num_related": 0,
+			"query_time":         at.Truncate(8 * time.Hour).Format("2006-01-02T15:04:05"),
+		},
+	})
+	return http.StatusOK
+}
+
+// serveNetworkInfo answers a network-info query from the latest snapshots
+// and returns the HTTP status.
+func (s *Server) serveNetworkInfo(w http.ResponseWriter, req Request) int {
+	addr, err := netip.ParseAddr(req.Resource)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid resource %q", req.Resource))
+		return http.StatusBadRequest
+	}
+	match, origins := s.longestMatch(netip.PrefixFrom(addr, addr.BitLen()), time.Now().UTC())
+	prefix, asns := "", []string{}
+	if match.IsValid() {
+		prefix = match.String()
+		for _, asn := range origins {
+			asns = append(asns, strconv.FormatUint(uint64(asn), 10))
+		}
+	}
+	writeJSON(w, map[string]any{
+		"status": "ok",
+		"data":   map[string]any{"asns": asns, "prefix": prefix},
+	})
+	return http.StatusOK
+}
+
+// serveNeighbours answers an asn-neighbours query and returns the HTTP status.
+func (s *Server) serveNeighbours(w http.ResponseWriter, req Request) int {
+	asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(req.Resource), "AS"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid resource %q", req.Resource))
+		return http.StatusBadRequest
+	}
+	at, status := queryTime(w, req)
+	if status != http.StatusOK {
+		return status
+	}
+
+	s.mu.Lock()
+	var snap neighbourSnapshot
+	for _, sn := range s.neighbours[uint32(asn)] {
+		if sn.time.After(at) {
+			break
+		}
+		snap = sn
+	}
+	s.mu.Unlock()
+
+	end := snap.time
+	if end.IsZero() {
+		end = at.Truncate(8 * time.Hour)
+	}
+	neighbours := []map[string]any{}
+	for _, n := range snap.neighbours {
+		neighbours = append(neighbours, map[string]any{
+			"asn":      n.ASN,
+			"type":     n.Type,
+			"power":    n.Power,
+			"v4_peers": n.Power,
+			"v6_peers": 0,
+		})
+	}
+	writeJSON(w, map[string]any{
+		"status": "ok",
+		"data": map[string]any{
+			"resource":        req.Resource,
+			"query_starttime": end.Format("2006-01-02T15:04:05"),
+			"query_endtime":   end.Format("2006-01-02T15:04:05"),
+			"neighbours":      neighbours,
+		},
+	})
+	return http.StatusOK
+}
+
+// longestMatch returns the most specific prefix covering p among the latest
+// snapshots at or before at of all ASNs, with its sorted origins. The prefix
+// is invalid if none covers p.
+func (s *Server) longestMatch(p netip.Prefix, at time.Time) (netip.Prefix, []uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		match   netip.Prefix
+		origins []uint32
+	)
+	for asn, snaps := range s.snapshots {
+		var snap snapshot
+		for _, sn := range snaps {
+			if sn.time.After(at) {
+				break
+			}
+			snap = sn
+		}
+		for _, q := range snap.prefixes {
+			if q.Addr().Is4() != p.Addr().Is4() || q.Bits() > p.Bits() || !q.Contains(p.Addr()) {
+				continue
+			}
+			switch {
+			case !match.IsValid() || q.Bits() > match.Bits():
+				match, origins = q, []uint32{asn}
+			case q == match && !slices.Contains(origins, asn):
+				origins = append(origins, asn)
+			}
+		}
+	}
+	slices.Sort(origins)
+	return match, origins
+}
+
+// ── Helpers ──────────────────────────────────────────────────────────────────
+
+// queryTime returns the query_time parameter of req, or the current time if
+// absent. On error it writes a 400 response and returns its status.
+func queryTime(w http.ResponseWriter, req Request) (time.Time, int) {
+	if req.QueryTime == "" {
+		return time.Now().UTC(), http.StatusOK
+	}
+	at, err := time.Parse(time.RFC3339, req.QueryTime)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid query_time %q", req.QueryTime))
+		return time.Time{}, http.StatusBadRequest
+	}
+	return at.UTC(), http.StatusOK
+}
+
+// parseResource parses a prefix, or an address as a single-address prefix.
+func parseResource(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid resource %q", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format used by RIPE Stat.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status":      "error",
+		"status_code": status,
+		"messages":    [][]string{{"error", message}},
+		"data":        map[string]any{},
+	})
+}
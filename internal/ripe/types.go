@@ -3,6 +3,7 @@ package ripe
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"time"
 )
 
@@ -56,3 +57,95 @@ type risPrefixesResponse struct {
 		} `json:"prefixes"`
 	} `json:"data"`
 }
+
+// PrefixOverview is the routing state of a prefix or address, as reported by
+// the prefix-overview data call.
+type PrefixOverview struct {
+	// Resource is the queried prefix or address.
+	Resource string
+	// Prefix is the prefix RIPE Stat resolved the resource to: the resource
+	// itself for a prefix, the most specific announced prefix covering it for
+	// an address.
+	Prefix netip.Prefix
+	// Announced reports whether Prefix is seen by the RIS collectors.
+	Announced bool
+	// LessSpecific reports whether Prefix is a less specific announcement
+	// covering the resource rather than the resource itself.
+	LessSpecific bool
+	// ASNs are the origin ASes of Prefix, and Holders their holder names.
+	ASNs    []uint32
+	Holders []string
+	// Block is the registry block containing the resource, e.g. "193.0.0.0/8",
+	// and BlockDesc its description.
+	Block     string
+	BlockDesc string
+	// RelatedPrefixes is the number of announced prefixes overlapping Prefix.
+	RelatedPrefixes int
+	QueryTime       time.Time
+}
+
+// NetworkInfo is the longest announced prefix containing an address and its
+// origin ASes, as reported by the network-info data call. Prefix is invalid
+// and ASNs empty if the address is not routed.
+type NetworkInfo struct {
+	Address netip.Addr
+	Prefix  netip.Prefix
+	ASNs    []uint32
+}
+
+// Neighbour is an AS adjacent to another in the AS paths seen by RIS, as
+// reported by the asn-neighbours data call.
+type Neighbour struct {
+	ASN       uint32 // queried AS
+	Neighbour uint32
+	// Type is "left" if Neighbour appears before ASN in AS paths, i.e.
+	// towards the collectors, typically an upstream; "right" if it appears
+	// after ASN, typically a customer; "uncertain" if both are seen.
+	Type string
+	// Power is the number of AS paths in which the adjacency was seen.
+	Power     uint32
+	V4Peers   uint32
+	V6Peers   uint32
+	QueryTime time.Time
+}
+
+type prefixOverviewResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Resource       string `json:"resource"`
+		Announced      bool   `json:"announced"`
+		IsLessSpecific bool   `json:"is_less_specific"`
+		ASNs           []struct {
+			ASN    uint32 `json:"asn"`
+			Holder string `json:"holder"`
+		} `json:"asns"`
+		Block struct {
+			Resource string `json:"resource"`
+			Desc     string `json:"desc"`
+		} `json:"block"`
+		ActualNumRelated int    `json:"actual_num_related"`
+		QueryTime        string `json:"query_time"`
+	} `json:"data"`
+}
+
+type networkInfoResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ASNs   []string `json:"asns"`
+		Prefix string   `json:"prefix"`
+	} `json:"data"`
+}
+
+type asnNeighboursResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		QueryEndTime string `json:"query_endtime"`
+		Neighbours   []struct {
+			ASN     uint32 `json:"asn"`
+			Type    string `json:"type"`
+			Power   uint32 `json:"power"`
+			V4Peers uint32 `json:"v4_peers"`
+			V6Peers uint32 `json:"v6_peers"`
+		} `json:"neighbours"`
+	} `json:"data"`
+}
@@ -58,7 +58,7 @@ func (s DynamicSchema) SchemaName() string {
 }
 
 func (s DynamicSchema) DDL(database, table string) string {
-	str, err := renderDDLTemplate(s.ddlTemplate, database, table)
+	str, err := renderDDLTemplate(s.ddlTemplate, database, table, templateOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -66,5 +66,5 @@ func (s DynamicSchema) DDL(database, table string) string {
 }
 
 func (s DynamicSchema) Columns() ([]Column, error) {
-	return parseColumnsFromDDLTemplate(s.ddlTemplate)
+	return parseColumnsFromDDLTemplate(s.ddlTemplate, templateOptions{})
 }
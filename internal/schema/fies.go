@@ -7,14 +7,37 @@ import (
 //go:embed templates/fies.tmpl
 var fiesDDLTemplate string
 
-type FIEsSchema struct{}
+type FIEsSchema struct {
+	// Provenance adds the measurement_uuid and agent_uuid columns, which
+	// record the Iris measurement and agent each row originates from.
+	Provenance bool
+	// Annotated adds the near_asn, far_asn and destination_asn columns,
+	// the origin ASNs of the near and far reply addresses and of the
+	// destination, 0 when unknown.
+	Annotated bool
+	// Deduplicated uses a ReplacingMergeTree engine with sequence_number
+	// appended to the sorting key, so that background merges collapse rows
+	// stored more than once, e.g. by a restarted Retina stream. Queries
+	// needing exact counts before merges complete should use FINAL.
+	Deduplicated bool
+}
 
 func (s FIEsSchema) SchemaName() string {
-	return "fies"
+	name := "fies"
+	if s.Provenance {
+		name += "+provenance"
+	}
+	if s.Annotated {
+		name += "+asn"
+	}
+	if s.Deduplicated {
+		name += "+dedup"
+	}
+	return name
 }
 
 func (s FIEsSchema) DDL(database, table string) string {
-	str, err := renderDDLTemplate(fiesDDLTemplate, database, table)
+	str, err := renderDDLTemplate(fiesDDLTemplate, database, table, s.options())
 	if err != nil {
 		panic(err)
 	}
@@ -22,5 +45,9 @@ func (s FIEsSchema) DDL(database, table string) string {
 }
 
 func (s FIEsSchema) Columns() ([]Column, error) {
-	return parseColumnsFromDDLTemplate(fiesDDLTemplate)
+	return parseColumnsFromDDLTemplate(fiesDDLTemplate, s.options())
+}
+
+func (s FIEsSchema) options() templateOptions {
+	return templateOptions{Provenance: s.Provenance, Annotated: s.Annotated, Deduplicated: s.Deduplicated}
 }
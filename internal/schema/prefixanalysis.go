@@ -0,0 +1,54 @@
+package schema
+
+import (
+	_ "embed"
+)
+
+//go:embed templates/moasprefixes.tmpl
+var moasPrefixesDDLTemplate string
+
+//go:embed templates/prefixhierarchy.tmpl
+var prefixHierarchyDDLTemplate string
+
+// MOASPrefixesSchema describes the structure of the moasprefixes table,
+// which lists the prefixes originated by more than one AS (Multiple Origin
+// AS) in a snapshot of a ripeprefixes table, with their sorted origin set.
+type MOASPrefixesSchema struct{}
+
+func (s MOASPrefixesSchema) SchemaName() string {
+	return "moasprefixes"
+}
+
+func (s MOASPrefixesSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(moasPrefixesDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s MOASPrefixesSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(moasPrefixesDDLTemplate, templateOptions{})
+}
+
+// PrefixHierarchySchema describes the structure of the prefixhierarchy
+// table, which links every prefix of a snapshot to its most specific
+// covering prefix of the same snapshot. depth is the number of covering
+// prefixes, 0 for top-level prefixes, whose parent columns are zero.
+type PrefixHierarchySchema struct{}
+
+func (s PrefixHierarchySchema) SchemaName() string {
+	return "prefixhierarchy"
+}
+
+func (s PrefixHierarchySchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(prefixHierarchyDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s PrefixHierarchySchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(prefixHierarchyDDLTemplate, templateOptions{})
+}
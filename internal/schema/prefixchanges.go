@@ -0,0 +1,31 @@
+package schema
+
+import (
+	_ "embed"
+)
+
+//go:embed templates/prefixchanges.tmpl
+var prefixChangesDDLTemplate string
+
+// PrefixChangesSchema describes the structure of the prefixchanges table,
+// which records how the origins of each prefix changed between consecutive
+// snapshots of a ripeprefixes table. event is one of "announced",
+// "withdrawn" or "origin_changed"; asns and previous_asns are the sorted
+// origin sets at event_time and previous_time.
+type PrefixChangesSchema struct{}
+
+func (s PrefixChangesSchema) SchemaName() string {
+	return "prefixchanges"
+}
+
+func (s PrefixChangesSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(prefixChangesDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s PrefixChangesSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(prefixChangesDDLTemplate, templateOptions{})
+}
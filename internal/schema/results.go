@@ -7,14 +7,21 @@ import (
 //go:embed templates/results.tmpl
 var resultsDDLTemplate string
 
-type ResultsSchema struct{}
+type ResultsSchema struct {
+	// Provenance adds the measurement_uuid and agent_uuid columns, which
+	// record the Iris measurement and agent each row originates from.
+	Provenance bool
+}
 
 func (s ResultsSchema) SchemaName() string {
+	if s.Provenance {
+		return "results+provenance"
+	}
 	return "results"
 }
 
 func (s ResultsSchema) DDL(database, table string) string {
-	str, err := renderDDLTemplate(resultsDDLTemplate, database, table)
+	str, err := renderDDLTemplate(resultsDDLTemplate, database, table, s.options())
 	if err != nil {
 		panic(err)
 	}
@@ -22,5 +29,9 @@ func (s ResultsSchema) DDL(database, table string) string {
 }
 
 func (s ResultsSchema) Columns() ([]Column, error) {
-	return parseColumnsFromDDLTemplate(resultsDDLTemplate)
+	return parseColumnsFromDDLTemplate(resultsDDLTemplate, s.options())
+}
+
+func (s ResultsSchema) options() templateOptions {
+	return templateOptions{Provenance: s.Provenance}
 }
@@ -7,14 +7,21 @@ import (
 //go:embed templates/resultslite.tmpl
 var resultsliteDDLTemplate string
 
-type ResultsLiteSchema struct{}
+type ResultsLiteSchema struct {
+	// Provenance adds the measurement_uuid and agent_uuid columns, which
+	// record the Iris measurement and agent each row originates from.
+	Provenance bool
+}
 
 func (s ResultsLiteSchema) SchemaName() string {
+	if s.Provenance {
+		return "resultslite+provenance"
+	}
 	return "resultslite"
 }
 
 func (s ResultsLiteSchema) DDL(database, table string) string {
-	str, err := renderDDLTemplate(resultsliteDDLTemplate, database, table)
+	str, err := renderDDLTemplate(resultsliteDDLTemplate, database, table, s.options())
 	if err != nil {
 		panic(err)
 	}
@@ -22,5 +29,9 @@ func (s ResultsLiteSchema) DDL(database, table string) string {
 }
 
 func (s ResultsLiteSchema) Columns() ([]Column, error) {
-	return parseColumnsFromDDLTemplate(resultsliteDDLTemplate)
+	return parseColumnsFromDDLTemplate(resultsliteDDLTemplate, s.options())
+}
+
+func (s ResultsLiteSchema) options() templateOptions {
+	return templateOptions{Provenance: s.Provenance}
 }
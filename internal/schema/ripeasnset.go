@@ -0,0 +1,31 @@
+package schema
+
+import (
+	_ "embed"
+)
+
+//go:embed templates/ripeasnset.tmpl
+var ripeASNSetDDLTemplate string
+
+// RipeASNSetSchema describes the structure of the ripeasnset table, which
+// records the ASNs whose prefixes were fetched into a ripeprefixes table,
+// per snapshot, and how each was selected. source is "asns", "file:<path>",
+// "group:<name>" or "neighbour"; neighbours have the number of hops from the
+// selected ASNs and the ASN they were reached via, 0 otherwise.
+type RipeASNSetSchema struct{}
+
+func (s RipeASNSetSchema) SchemaName() string {
+	return "ripeasnset"
+}
+
+func (s RipeASNSetSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(ripeASNSetDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s RipeASNSetSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(ripeASNSetDDLTemplate, templateOptions{})
+}
@@ -16,7 +16,7 @@ func (s RipePrefixesSchema) SchemaName() string {
 }
 
 func (s RipePrefixesSchema) DDL(database, table string) string {
-	str, err := renderDDLTemplate(ripePrefixesDDLTemplate, database, table)
+	str, err := renderDDLTemplate(ripePrefixesDDLTemplate, database, table, templateOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -24,5 +24,5 @@ func (s RipePrefixesSchema) DDL(database, table string) string {
 }
 
 func (s RipePrefixesSchema) Columns() ([]Column, error) {
-	return parseColumnsFromDDLTemplate(ripePrefixesDDLTemplate)
+	return parseColumnsFromDDLTemplate(ripePrefixesDDLTemplate, templateOptions{})
 }
@@ -0,0 +1,80 @@
+package schema
+
+import (
+	_ "embed"
+)
+
+//go:embed templates/ripeoverview.tmpl
+var ripeOverviewDDLTemplate string
+
+//go:embed templates/ripenetworkinfo.tmpl
+var ripeNetworkInfoDDLTemplate string
+
+//go:embed templates/ripeneighbours.tmpl
+var ripeNeighboursDDLTemplate string
+
+// RipeOverviewSchema describes the structure of the ripeoverview table,
+// which stores the RIPE Stat prefix-overview of prefixes and addresses:
+// whether they are announced, by which origins, and in which registry block.
+// network and prefix_len are the prefix RIPE Stat resolved the resource to,
+// IPv4 mapped as in the ripeprefixes table.
+type RipeOverviewSchema struct{}
+
+func (s RipeOverviewSchema) SchemaName() string {
+	return "ripeoverview"
+}
+
+func (s RipeOverviewSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(ripeOverviewDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s RipeOverviewSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(ripeOverviewDDLTemplate, templateOptions{})
+}
+
+// RipeNetworkInfoSchema describes the structure of the ripenetworkinfo
+// table, which maps addresses to their longest announced prefix and its
+// origins, from the RIPE Stat network-info data call. Unrouted addresses
+// have a zero network and prefix_len and no asns.
+type RipeNetworkInfoSchema struct{}
+
+func (s RipeNetworkInfoSchema) SchemaName() string {
+	return "ripenetworkinfo"
+}
+
+func (s RipeNetworkInfoSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(ripeNetworkInfoDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s RipeNetworkInfoSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(ripeNetworkInfoDDLTemplate, templateOptions{})
+}
+
+// RipeNeighboursSchema describes the structure of the ripeneighbours table,
+// which stores the AS adjacencies of the RIPE Stat asn-neighbours data call,
+// one row per (asn, neighbour). type is "left", "right" or "uncertain".
+type RipeNeighboursSchema struct{}
+
+func (s RipeNeighboursSchema) SchemaName() string {
+	return "ripeneighbours"
+}
+
+func (s RipeNeighboursSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(ripeNeighboursDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s RipeNeighboursSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(ripeNeighboursDDLTemplate, templateOptions{})
+}
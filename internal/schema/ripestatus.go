@@ -0,0 +1,29 @@
+package schema
+
+import (
+	_ "embed"
+)
+
+//go:embed templates/ripestatus.tmpl
+var ripeStatusDDLTemplate string
+
+// RipeStatusSchema describes the structure of the ripestatus table, which
+// records the outcome of fetching the prefixes of each ASN from RIPE Stat.
+// status is either "ok" or "failed"; error is empty for successful ASNs.
+type RipeStatusSchema struct{}
+
+func (s RipeStatusSchema) SchemaName() string {
+	return "ripestatus"
+}
+
+func (s RipeStatusSchema) DDL(database, table string) string {
+	str, err := renderDDLTemplate(ripeStatusDDLTemplate, database, table, templateOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return str
+}
+
+func (s RipeStatusSchema) Columns() ([]Column, error) {
+	return parseColumnsFromDDLTemplate(ripeStatusDDLTemplate, templateOptions{})
+}
@@ -130,10 +130,20 @@ func MissingColumns(a, b Schema) ([]string, error) {
 	return missing, nil
 }
 
+// templateOptions holds the optional features a DDL template may toggle.
+type templateOptions struct {
+	// Provenance adds the measurement_uuid and agent_uuid columns.
+	Provenance bool
+	// Annotated adds the near_asn, far_asn and destination_asn columns.
+	Annotated bool
+	// Deduplicated selects the ReplacingMergeTree variant of the table.
+	Deduplicated bool
+}
+
 // parseColumnsFromDDLTemplate renders the DDL template with dummy values and parses
 // the resulting CREATE TABLE statement to extract column definitions.
-func parseColumnsFromDDLTemplate(ddlTemplate string) ([]Column, error) {
-	ddl, err := renderDDLTemplate(ddlTemplate, "database", "table") // placeholder values
+func parseColumnsFromDDLTemplate(ddlTemplate string, opts templateOptions) ([]Column, error) {
+	ddl, err := renderDDLTemplate(ddlTemplate, "database", "table", opts) // placeholder values
 	if err != nil {
 		return nil, fmt.Errorf("schema: failed to render DDL template: %w", err)
 	}
@@ -141,15 +151,18 @@ func parseColumnsFromDDLTemplate(ddlTemplate string) ([]Column, error) {
 	return ParseColumnsFromDDL(ddl)
 }
 
-func renderDDLTemplate(templateString, database, table string) (string, error) {
+func renderDDLTemplate(templateString, database, table string, opts templateOptions) (string, error) {
 	t, err := template.New("schema").Parse(templateString)
 	if err != nil {
 		return "", err
 	}
 	var buf bytes.Buffer
 	if err := t.Execute(&buf, map[string]any{
-		"Database": database,
-		"Table":    table,
+		"Database":     database,
+		"Table":        table,
+		"Provenance":   opts.Provenance,
+		"Annotated":    opts.Annotated,
+		"Deduplicated": opts.Deduplicated,
 	}); err != nil {
 		return "", err
 	}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	"github.com/dioptra-io/ufuk-research/internal/schema"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+//go:embed templates/ripeprefixes_dict.tmpl
+var ripePrefixesDictTemplate string
+
+//go:embed templates/ripeprefixes_dict_source.tmpl
+var ripePrefixesDictSourceTemplate string
+
+// dictCommentPrefix marks the dictionaries created by DictService, so that
+// List only reports those.
+const dictCommentPrefix = "mpat:ip_trie "
+
+// DictConfig holds the configuration of a prefix-to-ASN dictionary.
+type DictConfig struct {
+	// Source is the ripeprefixes table the dictionary is loaded from.
+	Source store.DatabaseTable
+	// QueryTime selects the snapshot of Source. If zero, the latest snapshot
+	// at load time is used, so that a reload picks up newer snapshots.
+	QueryTime time.Time
+	// Replace replaces an existing dictionary of the same name.
+	Replace bool
+}
+
+// DictInfo describes an installed dictionary.
+type DictInfo struct {
+	Name        string
+	Status      string
+	Source      string // source table and snapshot, as recorded at creation
+	Elements    uint64
+	Bytes       uint64
+	LastUpdate  time.Time
+	LastFailure string
+}
+
+// DictService manages IP_TRIE dictionaries mapping prefixes to their origin
+// ASN, loaded from ripeprefixes tables.
+//
+// Lookups take an IPv6 address, with IPv4 addresses IPv4-mapped as in the
+// results tables:
+//
+//	dictGetOrDefault('mpat.asn_dict', 'asn', probe_dst_addr, toUInt32(0))
+type DictService struct {
+	store *store.Store
+}
+
+// NewDictService creates a new DictService with the given store.
+func NewDictService(s *store.Store) *DictService {
+	return &DictService{store: s}
+}
+
+type dictTemplateData struct {
+	Database string
+	Name     string
+	Replace  bool
+	User     string
+	Password string
+	Query    string
+	Comment  string
+}
+
+type dictSourceTemplateData struct {
+	SourceDatabase string
+	SourceTable    string
+	QueryTime      string
+}
+
+// Create installs the dictionary dict and loads it. ripeprefixes tables store
+// IPv4 prefixes as IPv4-mapped networks with their IPv4 length; they are
+// turned back into IPv4 CIDR keys, which IP_TRIE matches against
+// IPv4-mapped lookups. A prefix announced by several origins (MOAS) maps to
+// the lowest ASN.
+func (d *DictService) Create(ctx context.Context, dict store.DatabaseTable, cfg DictConfig) (DictInfo, error) {
+	log := slog.Default()
+
+	targetSchema := schema.RipePrefixesSchema{}
+	existingSchema, err := d.store.TableSchema(ctx, cfg.Source)
+	if err != nil {
+		return DictInfo{}, fmt.Errorf("dict: failed to get source table schema: %w", err)
+	}
+	if existingSchema == nil {
+		return DictInfo{}, fmt.Errorf("dict: source table %s.%s does not exist", cfg.Source.Database, cfg.Source.Table)
+	}
+	ok, err := schema.AreEquivalent(targetSchema, existingSchema, false)
+	if err != nil {
+		return DictInfo{}, fmt.Errorf("dict: failed to compare schemas: %w", err)
+	}
+	if !ok {
+		return DictInfo{}, fmt.Errorf("dict: source table %s.%s is not a %s table", cfg.Source.Database, cfg.Source.Table, targetSchema.SchemaName())
+	}
+
+	snapshot := "latest"
+	sourceData := dictSourceTemplateData{SourceDatabase: cfg.Source.Database, SourceTable: cfg.Source.Table}
+	if !cfg.QueryTime.IsZero() {
+		snapshot = cfg.QueryTime.UTC().Format(time.RFC3339)
+		sourceData.QueryTime = cfg.QueryTime.UTC().Format(time.DateTime)
+		n, err := d.store.RowCountWhere(ctx, cfg.Source, fmt.Sprintf("query_time = toDateTime('%s', 'UTC')", sourceData.QueryTime))
+		if err != nil {
+			return DictInfo{}, fmt.Errorf("dict: failed to look up snapshot: %w", err)
+		}
+		if n == 0 {
+			return DictInfo{}, fmt.Errorf("dict: no snapshot at %s in %s.%s", snapshot, cfg.Source.Database, cfg.Source.Table)
+		}
+	}
+	query, err := renderTemplate("dict_source", ripePrefixesDictSourceTemplate, sourceData)
+	if err != nil {
+		return DictInfo{}, fmt.Errorf("dict: failed to render source query: %w", err)
+	}
+
+	user, password := d.store.Credentials()
+	ddl, err := renderTemplate("dict", ripePrefixesDictTemplate, dictTemplateData{
+		Database: dict.Database,
+		Name:     dict.Table,
+		Replace:  cfg.Replace,
+		User:     quoteString(user),
+		Password: quoteString(password),
+		Query:    quoteString(query),
+		Comment:  quoteString(fmt.Sprintf("%s%s.%s@%s", dictCommentPrefix, cfg.Source.Database, cfg.Source.Table, snapshot)),
+	})
+	if err != nil {
+		return DictInfo{}, fmt.Errorf("dict: failed to render DDL: %w", err)
+	}
+
+	if err := d.store.Exec(ctx, ddl); err != nil {
+		return DictInfo{}, fmt.Errorf("dict: failed to create dictionary %s.%s: %w", dict.Database, dict.Table, err)
+	}
+	log.InfoContext(ctx, "created dictionary",
+		"dict", fmt.Sprintf("%s.%s", dict.Database, dict.Table),
+		"source", fmt.Sprintf("%s.%s", cfg.Source.Database, cfg.Source.Table),
+		"snapshot", snapshot,
+	)
+
+	// Dictionaries load lazily on first use; load now to surface errors.
+	return d.Reload(ctx, dict)
+}
+
+// Reload reloads the dictionary from its source and returns its new state.
+func (d *DictService) Reload(ctx context.Context, dict store.DatabaseTable) (DictInfo, error) {
+	if err := d.store.Exec(ctx, fmt.Sprintf("SYSTEM RELOAD DICTIONARY %s.%s", dict.Database, dict.Table)); err != nil {
+		return DictInfo{}, fmt.Errorf("dict: failed to reload dictionary %s.%s: %w", dict.Database, dict.Table, err)
+	}
+	infos, err := d.list(ctx, dict.Database, dict.Table)
+	if err != nil {
+		return DictInfo{}, err
+	}
+	if len(infos) == 0 {
+		return DictInfo{}, fmt.Errorf("dict: dictionary %s.%s not found", dict.Database, dict.Table)
+	}
+	slog.Default().InfoContext(ctx, "loaded dictionary",
+		"dict", fmt.Sprintf("%s.%s", dict.Database, dict.Table),
+		"elements", infos[0].Elements,
+	)
+	return infos[0], nil
+}
+
+// Drop removes the dictionary. It is not an error if it does not exist.
+func (d *DictService) Drop(ctx context.Context, dict store.DatabaseTable) error {
+	if err := d.store.Exec(ctx, fmt.Sprintf("DROP DICTIONARY IF EXISTS %s.%s", dict.Database, dict.Table)); err != nil {
+		return fmt.Errorf("dict: failed to drop dictionary %s.%s: %w", dict.Database, dict.Table, err)
+	}
+	return nil
+}
+
+// List returns the dictionaries of database created by Create, by name.
+func (d *DictService) List(ctx context.Context, database string) ([]DictInfo, error) {
+	return d.list(ctx, database, "")
+}
+
+// list returns the dictionaries of database created by Create, restricted
+// to the one named name if it is not empty.
+func (d *DictService) list(ctx context.Context, database, name string) ([]DictInfo, error) {
+	query := `SELECT name, toString(status), comment, element_count, bytes_allocated,
+       last_successful_update_time, last_exception
+FROM system.dictionaries
+WHERE database = ? AND startsWith(comment, ?) AND (? = '' OR name = ?)
+ORDER BY name`
+	rows, err := d.store.Query(ctx, query, database, dictCommentPrefix, name, name)
+	if err != nil {
+		return nil, fmt.Errorf("dict: failed to list dictionaries: %w", err)
+	}
+	defer rows.Close()
+
+	var infos []DictInfo
+	for rows.Next() {
+		var info DictInfo
+		if err := rows.Scan(&info.Name, &info.Status, &info.Source, &info.Elements, &info.Bytes, &info.LastUpdate, &info.LastFailure); err != nil {
+			return nil, fmt.Errorf("dict: failed to scan dictionary: %w", err)
+		}
+		info.Source = strings.TrimPrefix(info.Source, dictCommentPrefix)
+		infos = append(infos, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("dict: failed to list dictionaries: %w", err)
+	}
+	return infos, nil
+}
+
+// quoteString escapes s for use inside a single-quoted ClickHouse string.
+func quoteString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,9 +19,16 @@ const (
 	DefaultFetchLiteSchema             = true
 )
 
+// chunkKey is the order in which the rows of a source table are read in
+// chunks: the sorting key of the Iris results tables, so that ClickHouse
+// reads in order, then the selected columns to break ties. Without it, the
+// rows of a chunk vary between queries and skipped chunks would not match
+// those committed by an earlier run.
+const chunkKey = "probe_protocol, probe_src_addr, probe_dst_prefix, probe_dst_addr, probe_src_port, probe_dst_port, probe_ttl"
+
 // tableInfo holds pre-scanned metadata for a source table.
 type tableInfo struct {
-	name   string
+	source iris.IrisTable
 	total  int64
 	chunks int64
 }
@@ -32,6 +40,11 @@ type FetchConfig struct {
 	Lite              bool // if true, uses ResultsLiteSchema, otherwise ResultsSchema
 	EWMAAlpha         float64
 	IPVersion         uint8 // 0 = both, 4 = IPv4 only, 6 = IPv6 only
+	Provenance        bool  // if true, adds the measurement_uuid and agent_uuid columns
+	// SkipChunks skips the first chunks, counted over all source tables in
+	// order, e.g. those committed by an interrupted run. The chunks only
+	// match those of the earlier run with the same ChunkSize and IPVersion.
+	SkipChunks int64
 }
 
 // DefaultFetchConfig returns a FetchConfig with sensible defaults.
@@ -63,75 +76,55 @@ func NewFetchService(s *store.Store, irisClient *iris.IrisClient, cfg FetchConfi
 // targetSchema returns the schema to use based on the Lite config flag.
 func (f *FetchService) targetSchema() schema.Schema {
 	if f.config.Lite {
-		return schema.ResultsLiteSchema{}
+		return schema.ResultsLiteSchema{Provenance: f.config.Provenance}
 	}
-	return schema.ResultsSchema{}
+	return schema.ResultsSchema{Provenance: f.config.Provenance}
 }
 
-// Fetch fetches data from the given source tables into dest.
-func (f *FetchService) Fetch(ctx context.Context, sourceNames []string, dest store.DatabaseTable) error {
+// Fetch fetches data from the given source tables into dest. When provenance
+// is enabled, the measurement and agent UUIDs of each source are written
+// alongside its rows.
+func (f *FetchService) Fetch(ctx context.Context, sources []iris.IrisTable, dest store.DatabaseTable) error {
 	log := slog.Default()
 	targetSchema := f.targetSchema()
 
-	// Build column list from schema — only non-materialized columns.
-	cols, err := targetSchema.Columns()
+	selectCols, err := f.selectColumns()
 	if err != nil {
-		return fmt.Errorf("fetch: failed to get columns for schema %s: %w", targetSchema.SchemaName(), err)
-	}
-	colNames := make([]string, 0, len(cols))
-	for _, col := range cols {
-		if !col.Materialized {
-			colNames = append(colNames, col.Name)
-		}
+		return err
 	}
-	selectCols := strings.Join(colNames, ", ")
 
 	// Step 1: Pre-scan source tables.
-	tables := make([]tableInfo, 0, len(sourceNames))
+	tables := make([]tableInfo, 0, len(sources))
 	totalChunks := int64(0)
 	where := f.ipVersionFilter()
-	for _, name := range sourceNames {
-		total, err := countSourceRows(f.irisClient, name, where)
+	for _, source := range sources {
+		if f.config.Provenance && (source.MeasurementUUID == "" || source.AgentUUID == "") {
+			return fmt.Errorf("fetch: provenance is enabled but the measurement or agent UUID of %s is unknown", source.TableName)
+		}
+		total, err := countSourceRows(f.irisClient, source.TableName, where)
 		if err != nil {
-			return fmt.Errorf("fetch: failed to count rows in %s: %w", name, err)
+			return fmt.Errorf("fetch: failed to count rows in %s: %w", source.TableName, err)
 		}
 		chunks := (total + int64(f.config.ChunkSize) - 1) / int64(f.config.ChunkSize)
 		if chunks == 0 {
 			chunks = 1
 		}
-		tables = append(tables, tableInfo{name: name, total: total, chunks: chunks})
+		tables = append(tables, tableInfo{source: source, total: total, chunks: chunks})
 		totalChunks += chunks
 	}
 
 	log.InfoContext(ctx, "pre-scan complete",
 		"tables", len(tables),
 		"total_chunks", totalChunks,
+		"skip_chunks", f.config.SkipChunks,
 		"policy", f.config.PreparationPolicy,
 		"schema", targetSchema.SchemaName(),
 		"dest", fmt.Sprintf("%s.%s", dest.Database, dest.Table),
 	)
 
 	// Step 2: Prepare destination table.
-	if err := f.store.PrepareTable(ctx, f.config.PreparationPolicy, dest, targetSchema); err != nil {
-		return fmt.Errorf("fetch: failed to prepare destination table: %w", err)
-	}
-
-	// Check if the existing table's schema is equivalent to the target schema.
-	existingSchema, err := f.store.TableSchema(ctx, dest)
-	if err != nil {
-		return fmt.Errorf("fetch: failed to get existing table schema: %w", err)
-	}
-	if existingSchema != nil {
-		ok, err := schema.AreEquivalent(targetSchema, existingSchema, false)
-		if err != nil {
-			return fmt.Errorf("fetch: failed to compare schemas: %w", err)
-		}
-		if !ok {
-			missing, _ := schema.MissingColumns(targetSchema, existingSchema)
-			extra, _ := schema.MissingColumns(existingSchema, targetSchema)
-			return fmt.Errorf("fetch: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
-				dest.Database, dest.Table, targetSchema.SchemaName(), missing, extra)
-		}
+	if err := f.prepareDest(ctx, dest); err != nil {
+		return err
 	}
 
 	// Step 3: Fetch and write chunks.
@@ -141,7 +134,7 @@ func (f *FetchService) Fetch(ctx context.Context, sourceNames []string, dest sto
 
 	for i, t := range tables {
 		log.InfoContext(ctx, "fetching table",
-			"table", t.name,
+			"table", t.source.TableName,
 			"rows", t.total,
 			"chunks", t.chunks,
 			"progress", fmt.Sprintf("%d/%d", i+1, len(tables)),
@@ -150,18 +143,21 @@ func (f *FetchService) Fetch(ctx context.Context, sourceNames []string, dest sto
 		for c := int64(0); c < t.chunks; c++ {
 			offset := c * int64(f.config.ChunkSize)
 
+			// Chunks are not canceled once started: neither the Iris query
+			// nor the insert take ctx, so the fetch stops between chunks.
+			if ctx.Err() != nil {
+				return &InterruptedError{Op: "fetch", Dest: dest, Chunks: globalChunk, Resume: strconv.FormatInt(globalChunk, 10), Err: ctx.Err()}
+			}
 			globalChunk++
+			if globalChunk <= f.config.SkipChunks {
+				continue
+			}
 			chunkRows := int64(f.config.ChunkSize)
 			if remaining := t.total - offset; remaining < chunkRows {
 				chunkRows = remaining
 			}
 
-			sql := fmt.Sprintf("SELECT %s FROM %s", selectCols, t.name)
-			if where != "" {
-				sql += " WHERE " + where
-			}
-			sql += fmt.Sprintf(" LIMIT %d OFFSET %d", f.config.ChunkSize, offset)
-			rows, err := f.irisClient.Query().Select(sql).Json()
+			rows, err := f.irisClient.Query().Select(f.chunkQuery(t.source, selectCols, where, offset)).Json()
 			if err != nil {
 				return fmt.Errorf("[%d/%d] chunk %d: failed to query: %w", i+1, len(tables), c+1, err)
 			}
@@ -213,6 +209,79 @@ func (f *FetchService) Fetch(ctx context.Context, sourceNames []string, dest sto
 	return nil
 }
 
+// selectColumns returns the comma-separated list of columns to read from the
+// source tables: the non-materialized columns of the target schema, excluding
+// the provenance columns.
+func (f *FetchService) selectColumns() (string, error) {
+	targetSchema := f.targetSchema()
+	cols, err := targetSchema.Columns()
+	if err != nil {
+		return "", fmt.Errorf("fetch: failed to get columns for schema %s: %w", targetSchema.SchemaName(), err)
+	}
+	colNames := make([]string, 0, len(cols))
+	for _, col := range cols {
+		if col.Materialized || isProvenanceColumn(col.Name) {
+			continue
+		}
+		colNames = append(colNames, col.Name)
+	}
+	return strings.Join(colNames, ", "), nil
+}
+
+// chunkQuery returns the query reading the chunk of source starting at
+// offset, in chunkKey order.
+func (f *FetchService) chunkQuery(source iris.IrisTable, selectCols, where string, offset int64) string {
+	sql := fmt.Sprintf("SELECT %s%s FROM %s", selectCols, f.provenanceColumns(source), source.TableName)
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	return sql + fmt.Sprintf(" ORDER BY %s, %s LIMIT %d OFFSET %d", chunkKey, selectCols, f.config.ChunkSize, offset)
+}
+
+// prepareDest prepares dest according to the preparation policy and checks
+// that its schema is equivalent to the target schema.
+func (f *FetchService) prepareDest(ctx context.Context, dest store.DatabaseTable) error {
+	targetSchema := f.targetSchema()
+	if err := f.store.PrepareTable(ctx, f.config.PreparationPolicy, dest, targetSchema); err != nil {
+		return fmt.Errorf("fetch: failed to prepare destination table: %w", err)
+	}
+
+	// Check if the existing table's schema is equivalent to the target schema.
+	existingSchema, err := f.store.TableSchema(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("fetch: failed to get existing table schema: %w", err)
+	}
+	if existingSchema != nil {
+		ok, err := schema.AreEquivalent(targetSchema, existingSchema, false)
+		if err != nil {
+			return fmt.Errorf("fetch: failed to compare schemas: %w", err)
+		}
+		if !ok {
+			missing, _ := schema.MissingColumns(targetSchema, existingSchema)
+			extra, _ := schema.MissingColumns(existingSchema, targetSchema)
+			return fmt.Errorf("fetch: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+				dest.Database, dest.Table, targetSchema.SchemaName(), missing, extra)
+		}
+	}
+	return nil
+}
+
+// provenanceColumns returns the constant select expressions filling the
+// provenance columns for source, or an empty string if provenance is disabled.
+func (f *FetchService) provenanceColumns(source iris.IrisTable) string {
+	if !f.config.Provenance {
+		return ""
+	}
+	return fmt.Sprintf(", toUUID('%s') AS measurement_uuid, toUUID('%s') AS agent_uuid",
+		source.MeasurementUUID, source.AgentUUID)
+}
+
+// isProvenanceColumn reports whether name is one of the provenance columns,
+// which are not read from the source but derived from its table metadata.
+func isProvenanceColumn(name string) bool {
+	return name == "measurement_uuid" || name == "agent_uuid"
+}
+
 func (f *FetchService) ipVersionFilter() string {
 	switch f.config.IPVersion {
 	case 4:
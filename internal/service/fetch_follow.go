@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultFollowInterval = 5 * time.Minute
+)
+
+// followKey is the tuple rows are ordered by when following a source. The
+// local watermark of a source is the largest followKey it has locally; only
+// rows with a larger key are fetched from Iris.
+const followKey = "toUnixTimestamp(capture_timestamp), probe_src_addr, probe_protocol, probe_dst_addr, probe_src_port, probe_dst_port, probe_ttl"
+
+// watermark is the followKey of the latest row of a source present locally.
+type watermark struct {
+	timestamp uint32
+	srcAddr   string
+	protocol  uint8
+	dstAddr   string
+	srcPort   uint16
+	dstPort   uint16
+	ttl       uint8
+}
+
+// condition returns the WHERE fragment selecting the rows after w.
+func (w watermark) condition() string {
+	return fmt.Sprintf("(%s) > (%d, toIPv6('%s'), %d, toIPv6('%s'), %d, %d, %d)",
+		followKey, w.timestamp, w.srcAddr, w.protocol, w.dstAddr, w.srcPort, w.dstPort, w.ttl)
+}
+
+// FollowResult summarises a Follow run.
+type FollowResult struct {
+	// Rows is the number of rows fetched while following.
+	Rows int64
+	// NewPrefixes is a SQL subquery returning the probe_dst_prefix values
+	// that received rows while following. It can be used as
+	// FIEComputeConfig.PrefixFilter to compute the FIEs of these prefixes.
+	NewPrefixes string
+}
+
+// Follow incrementally fetches the results of the given measurements into
+// dest. Every interval, it appends the rows of each results table that are
+// newer than the local watermark of that table, until every measurement has
+// reached a terminal state. A final poll is made after a measurement ends.
+//
+// Following requires provenance, since watermarks are tracked per source
+// using the measurement_uuid and agent_uuid columns.
+func (f *FetchService) Follow(ctx context.Context, measurementUUIDs []string, dest store.DatabaseTable, interval time.Duration) (FollowResult, error) {
+	log := slog.Default()
+	if !f.config.Provenance {
+		return FollowResult{}, fmt.Errorf("fetch: follow requires provenance")
+	}
+	if interval <= 0 {
+		interval = DefaultFollowInterval
+	}
+
+	selectCols, err := f.selectColumns()
+	if err != nil {
+		return FollowResult{}, err
+	}
+	if err := f.prepareDest(ctx, dest); err != nil {
+		return FollowResult{}, err
+	}
+
+	// The watermarks at the start delimit the rows fetched by this run, from
+	// which the new prefixes are derived.
+	initial := make(map[string]uint32)
+	pending := make(map[string]bool)
+	for _, uuid := range measurementUUIDs {
+		pending[uuid] = true
+	}
+
+	var total int64
+	for poll := 1; ; poll++ {
+		pollStart := time.Now()
+		var pollRows int64
+
+		for _, uuid := range measurementUUIDs {
+			if !pending[uuid] {
+				continue
+			}
+			m, err := f.irisClient.Measurement(uuid)
+			if err != nil {
+				return FollowResult{}, fmt.Errorf("fetch: failed to poll measurement %s: %w", uuid, err)
+			}
+			// Read the state before fetching, so that rows written right
+			// before the measurement ended are caught by this poll.
+			if m.State.IsTerminal() {
+				pending[uuid] = false
+			}
+
+			for _, g := range m.TableGroups() {
+				source := g.Results
+				if _, ok := initial[source.TableName]; !ok {
+					wm, err := f.localWatermark(ctx, source, dest)
+					if err != nil {
+						return FollowResult{}, err
+					}
+					if wm != nil {
+						initial[source.TableName] = wm.timestamp
+					} else {
+						initial[source.TableName] = 0
+					}
+				}
+				n, err := f.fetchNewRows(ctx, source, dest, selectCols)
+				if err != nil {
+					return FollowResult{}, err
+				}
+				pollRows += n
+			}
+		}
+		total += pollRows
+
+		remaining := 0
+		for _, p := range pending {
+			if p {
+				remaining++
+			}
+		}
+		log.InfoContext(ctx, "poll complete",
+			"poll", poll,
+			"rows", pollRows,
+			"total_rows", total,
+			"ongoing_measurements", remaining,
+			"elapsed", time.Since(pollStart).Round(time.Second),
+		)
+		if remaining == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return FollowResult{Rows: total, NewPrefixes: newPrefixesQuery(dest, initial)}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+
+	log.InfoContext(ctx, "follow complete",
+		"measurements", len(measurementUUIDs),
+		"total_rows", total,
+	)
+	return FollowResult{Rows: total, NewPrefixes: newPrefixesQuery(dest, initial)}, nil
+}
+
+// fetchNewRows appends the rows of source newer than its local watermark,
+// in keyset-paginated chunks ordered by followKey. It returns the number of
+// rows fetched.
+func (f *FetchService) fetchNewRows(ctx context.Context, source iris.IrisTable, dest store.DatabaseTable, selectCols string) (int64, error) {
+	var fetched int64
+	for {
+		wm, err := f.localWatermark(ctx, source, dest)
+		if err != nil {
+			return fetched, err
+		}
+		var conds []string
+		if where := f.ipVersionFilter(); where != "" {
+			conds = append(conds, where)
+		}
+		if wm != nil {
+			conds = append(conds, wm.condition())
+		}
+		where := strings.Join(conds, " AND ")
+
+		count, err := countSourceRows(f.irisClient, source.TableName, where)
+		if err != nil {
+			return fetched, fmt.Errorf("fetch: failed to count new rows in %s: %w", source.TableName, err)
+		}
+		if count == 0 {
+			return fetched, nil
+		}
+
+		query := fmt.Sprintf("SELECT %s%s FROM %s", selectCols, f.provenanceColumns(source), source.TableName)
+		if where != "" {
+			query += " WHERE " + where
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d", followKey, f.config.ChunkSize)
+		rows, err := f.irisClient.Query().Select(query).Json()
+		if err != nil {
+			return fetched, fmt.Errorf("fetch: failed to query new rows in %s: %w", source.TableName, err)
+		}
+		if err := f.store.InsertJSONL(dest, rows); err != nil {
+			return fetched, fmt.Errorf("fetch: failed to write new rows of %s: %w", source.TableName, err)
+		}
+
+		n := min(count, int64(f.config.ChunkSize))
+		fetched += n
+		slog.Default().InfoContext(ctx, "fetched new rows",
+			"table", source.TableName,
+			"rows", n,
+			"remaining", count-n,
+		)
+		if count <= int64(f.config.ChunkSize) {
+			return fetched, nil
+		}
+	}
+}
+
+// localWatermark returns the followKey of the latest row of source in dest,
+// or nil if dest holds no row of source.
+func (f *FetchService) localWatermark(ctx context.Context, source iris.IrisTable, dest store.DatabaseTable) (*watermark, error) {
+	query := fmt.Sprintf(`SELECT
+    toUnixTimestamp(capture_timestamp), toString(probe_src_addr), probe_protocol,
+    toString(probe_dst_addr), probe_src_port, probe_dst_port, probe_ttl
+FROM %s.%s
+WHERE measurement_uuid = toUUID(?) AND agent_uuid = toUUID(?)
+ORDER BY %s
+LIMIT 1`, dest.Database, dest.Table, descending(followKey))
+
+	var w watermark
+	err := f.store.QueryRow(ctx, query, source.MeasurementUUID, source.AgentUUID).Scan(
+		&w.timestamp, &w.srcAddr, &w.protocol, &w.dstAddr, &w.srcPort, &w.dstPort, &w.ttl,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetch: failed to read local watermark of %s: %w", source.TableName, err)
+	}
+	return &w, nil
+}
+
+// descending turns a comma-separated key into an ORDER BY clause sorting
+// every column in descending order.
+func descending(key string) string {
+	cols := strings.Split(key, ", ")
+	for i, c := range cols {
+		cols[i] = c + " DESC"
+	}
+	return strings.Join(cols, ", ")
+}
+
+// newPrefixesQuery returns a subquery selecting the prefixes of dest that
+// received rows at or after the initial watermark of their source.
+func newPrefixesQuery(dest store.DatabaseTable, initial map[string]uint32) string {
+	if len(initial) == 0 {
+		return ""
+	}
+	conds := make([]string, 0, len(initial))
+	for _, name := range slices.Sorted(maps.Keys(initial)) {
+		ts := initial[name]
+		t, err := iris.ParseTableName(name)
+		if err != nil {
+			continue
+		}
+		conds = append(conds, fmt.Sprintf(
+			"(measurement_uuid = toUUID('%s') AND agent_uuid = toUUID('%s') AND toUnixTimestamp(capture_timestamp) >= %d)",
+			t.MeasurementUUID, t.AgentUUID, ts,
+		))
+	}
+	return fmt.Sprintf("SELECT DISTINCT probe_dst_prefix FROM %s.%s WHERE %s",
+		dest.Database, dest.Table, strings.Join(conds, " OR "))
+}
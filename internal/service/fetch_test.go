@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/dioptra-io/ufuk-research/internal/iris"
+	"github.com/dioptra-io/ufuk-research/internal/iris/iristest"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/dioptra-io/ufuk-research/internal/store/storetest"
+)
+
+const (
+	testMeasurement = "00000000-0000-0000-0000-000000000001"
+	testAgent1      = "ffffffff-0000-0000-0000-000000000001"
+	testAgent2      = "ffffffff-0000-0000-0000-000000000002"
+)
+
+// newTestFetch starts an Iris stand-in serving the results of one
+// measurement on two agents: 5 IPv4 rows for the first agent, and 2 IPv4
+// and 1 IPv6 rows for the second.
+func newTestFetch(t *testing.T) (*iris.IrisClient, *iristest.Server, []iris.IrisTable) {
+	t.Helper()
+	srv := iristest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddResults(testMeasurement, testAgent1, iristest.ResultRows(5, "::ffff:192.0.2.1")...)
+	srv.AddResults(testMeasurement, testAgent2, iristest.ResultRows(2, "::ffff:192.0.2.2")...)
+	srv.AddResults(testMeasurement, testAgent2, iristest.ResultRows(1, "2001:db8::2")...)
+
+	client, err := iris.NewIrisClient(srv.Config())
+	if err != nil {
+		t.Fatalf("NewIrisClient: %v", err)
+	}
+	sources := []iris.IrisTable{
+		iris.NewIrisTableGroup(testMeasurement, testAgent1, iris.IrisTime{}).Results,
+		iris.NewIrisTableGroup(testMeasurement, testAgent2, iris.IrisTime{}).Results,
+	}
+	return client, srv, sources
+}
+
+// readChunk runs query against the stand-in and returns its rows.
+func readChunk(t *testing.T, client *iris.IrisClient, query string) []map[string]any {
+	t.Helper()
+	body, err := client.Query().Select(query).Json()
+	if err != nil {
+		t.Fatalf("query %q: %v", query, err)
+	}
+	defer body.Close()
+	r, err := decompressIfNeeded(body)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	var rows []map[string]any
+	dec := json.NewDecoder(r)
+	for {
+		var row map[string]any
+		if err := dec.Decode(&row); err == io.EOF {
+			return rows
+		} else if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// TestChunkQueries reads every chunk of the sources as Fetch does, without
+// writing them: the chunks cover each source once, in sorting key order.
+func TestChunkQueries(t *testing.T) {
+	client, _, sources := newTestFetch(t)
+
+	tests := []struct {
+		name       string
+		ipVersion  uint8
+		provenance bool
+		want       map[string][]float64 // probe TTLs by probe source address
+	}{
+		{"all", 0, false, map[string][]float64{
+			"::ffff:192.0.2.1": {1, 2, 3, 4, 5},
+			"::ffff:192.0.2.2": {1, 2},
+			"2001:db8::2":      {1},
+		}},
+		{"ipv4", 4, false, map[string][]float64{
+			"::ffff:192.0.2.1": {1, 2, 3, 4, 5},
+			"::ffff:192.0.2.2": {1, 2},
+		}},
+		{"ipv6 with provenance", 6, true, map[string][]float64{
+			"2001:db8::2": {1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultFetchConfig()
+			config.ChunkSize = 2
+			config.IPVersion = tt.ipVersion
+			config.Provenance = tt.provenance
+			f := NewFetchService(nil, client, config)
+			selectCols, err := f.selectColumns()
+			if err != nil {
+				t.Fatalf("selectColumns: %v", err)
+			}
+
+			got := make(map[string][]float64)
+			for _, source := range sources {
+				total, err := countSourceRows(client, source.TableName, f.ipVersionFilter())
+				if err != nil {
+					t.Fatalf("countSourceRows: %v", err)
+				}
+				var read int64
+				for offset := int64(0); offset < total; offset += int64(config.ChunkSize) {
+					rows := readChunk(t, client, f.chunkQuery(source, selectCols, f.ipVersionFilter(), offset))
+					if len(rows) == 0 || len(rows) > config.ChunkSize {
+						t.Fatalf("chunk at offset %d of %s has %d rows", offset, source.TableName, len(rows))
+					}
+					read += int64(len(rows))
+					for _, row := range rows {
+						if _, ok := row["probe_dst_prefix"]; ok {
+							t.Errorf("row %v has the materialized probe_dst_prefix column", row)
+						}
+						if tt.provenance && (row["measurement_uuid"] != source.MeasurementUUID || row["agent_uuid"] != source.AgentUUID) {
+							t.Errorf("row %v does not record its source %s/%s", row, source.MeasurementUUID, source.AgentUUID)
+						}
+						src := row["probe_src_addr"].(string)
+						got[src] = append(got[src], row["probe_ttl"].(float64))
+					}
+				}
+				if read != total {
+					t.Errorf("read %d rows of %s, want %d", read, source.TableName, total)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("read %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchProvenanceRequiresSource(t *testing.T) {
+	client, _, _ := newTestFetch(t)
+	config := DefaultFetchConfig()
+	config.Provenance = true
+	// The sources are checked before the store is used.
+	sources := []iris.IrisTable{{Kind: iris.TableKindResults, TableName: "results_custom"}}
+	err := NewFetchService(nil, client, config).Fetch(context.Background(), sources, store.DatabaseTable{Database: "db", Table: "dest"})
+	if err == nil || !strings.Contains(err.Error(), "measurement or agent UUID of results_custom is unknown") {
+		t.Errorf("Fetch error = %v, want unknown provenance", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	s := storetest.Open(t)
+	client, srv, sources := newTestFetch(t)
+	dest := storetest.Table(t, s)
+
+	config := DefaultFetchConfig()
+	config.ChunkSize = 2
+	config.IPVersion = 4
+	if err := NewFetchService(s, client, config).Fetch(context.Background(), sources, dest); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if n := storetest.RowCount(t, s, dest, ""); n != 7 {
+		t.Errorf("fetched %d rows, want the 7 IPv4 rows", n)
+	}
+
+	// Chunks are read in a fixed order, so that skipped chunks are those
+	// already committed.
+	var chunks int
+	for _, q := range srv.Queries() {
+		if strings.Contains(q, "count()") {
+			continue
+		}
+		chunks++
+		if !strings.Contains(q, " ORDER BY "+chunkKey+", ") || !strings.Contains(q, " LIMIT 2 OFFSET ") {
+			t.Errorf("chunk query %q is not ordered by the sorting key and limited to 2 rows", q)
+		}
+	}
+	if chunks != 4 {
+		t.Errorf("sent %d chunk queries, want 3 for the first table and 1 for the second", chunks)
+	}
+
+	// The fail policy refuses to write to the existing table.
+	if err := NewFetchService(s, client, config).Fetch(context.Background(), sources, dest); err == nil {
+		t.Error("second Fetch with the fail policy succeeded, want an error")
+	}
+}
+
+func TestFetchProvenance(t *testing.T) {
+	s := storetest.Open(t)
+	client, _, sources := newTestFetch(t)
+	dest := storetest.Table(t, s)
+
+	config := DefaultFetchConfig()
+	config.Provenance = true
+	if err := NewFetchService(s, client, config).Fetch(context.Background(), sources, dest); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	for agent, want := range map[string]uint64{testAgent1: 5, testAgent2: 3} {
+		where := fmt.Sprintf("measurement_uuid = toUUID('%s') AND agent_uuid = toUUID('%s')", testMeasurement, agent)
+		if n := storetest.RowCount(t, s, dest, where); n != want {
+			t.Errorf("agent %s: %d rows, want %d", agent, n, want)
+		}
+	}
+}
+
+func TestFetchSkipChunks(t *testing.T) {
+	s := storetest.Open(t)
+	client, _, sources := newTestFetch(t)
+	dest := storetest.Table(t, s)
+
+	// The first table has chunks of TTLs {1, 2}, {3, 4} and {5}: skipping
+	// two chunks leaves TTL 5 and the second table.
+	config := DefaultFetchConfig()
+	config.ChunkSize = 2
+	config.SkipChunks = 2
+	if err := NewFetchService(s, client, config).Fetch(context.Background(), sources, dest); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if n := storetest.RowCount(t, s, dest, ""); n != 4 {
+		t.Errorf("fetched %d rows, want 4", n)
+	}
+	if n := storetest.RowCount(t, s, dest, "probe_src_addr = toIPv6('::ffff:192.0.2.1') AND probe_ttl = 5"); n != 1 {
+		t.Errorf("the last chunk of the first table was not fetched")
+	}
+}
+
+func TestFetchInterrupted(t *testing.T) {
+	s := storetest.Open(t)
+	client, srv, sources := newTestFetch(t)
+	dest := storetest.Table(t, s)
+
+	// Cancel while the second chunk is queried: the chunk is still
+	// committed, and the fetch stops before the third.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var chunks int
+	srv.OnQuery(func(sql string) {
+		if strings.Contains(sql, " ORDER BY ") {
+			if chunks++; chunks == 2 {
+				cancel()
+			}
+		}
+	})
+
+	config := DefaultFetchConfig()
+	config.ChunkSize = 2
+	err := NewFetchService(s, client, config).Fetch(ctx, sources, dest)
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("Fetch error = %v, want an InterruptedError", err)
+	}
+	if interrupted.Chunks != 2 || interrupted.Resume != "2" || !errors.Is(err, context.Canceled) {
+		t.Errorf("InterruptedError = %+v, want 2 chunks committed and a resume at 2", interrupted)
+	}
+	if n := storetest.RowCount(t, s, dest, ""); n != 4 {
+		t.Errorf("committed %d rows, want the 4 rows of the first 2 chunks", n)
+	}
+}
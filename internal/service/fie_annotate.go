@@ -0,0 +1,415 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	"github.com/dioptra-io/ufuk-research/internal/schema"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultFIEAnnotateChunkSize         = 10_000
+	DefaultFIEAnnotatePreparationPolicy = store.PreparationPolicyFail
+)
+
+//go:embed templates/fie_annotate_cursor.tmpl
+var fieAnnotateCursorTemplate string
+
+//go:embed templates/fie_annotate_insert.tmpl
+var fieAnnotateInsertTemplate string
+
+type fieAnnotateTemplateData struct {
+	SourceDatabase  string
+	SourceTable     string
+	DestDatabase    string
+	DestTable       string
+	Dict            string
+	ChunkSize       int
+	Cursor          string
+	Last            string
+	WindowCondition string
+}
+
+// FIEAnnotateConfig holds the configuration for the FIE annotation service.
+// Exactly one of Dict and Prefixes must be set.
+type FIEAnnotateConfig struct {
+	// ChunkSize is the number of distinct near reply addresses per chunk.
+	ChunkSize         int
+	PreparationPolicy store.PreparationPolicy
+
+	// Dict is an IP_TRIE dictionary created by DictService.
+	Dict *store.DatabaseTable
+	// Prefixes is a ripeprefixes table. A temporary dictionary is created
+	// for each snapshot used and dropped when done.
+	Prefixes *store.DatabaseTable
+	// QueryTime selects the snapshot of Prefixes; zero means the latest.
+	QueryTime time.Time
+	// Closest annotates each FIE with the snapshot of Prefixes closest to
+	// its near_sent_timestamp.
+	Closest bool
+
+	// ResumeAfter is the Resume position of an interrupted run: the last
+	// near_reply_address committed, prefixed with the number of its pass
+	// and a slash when annotating with several snapshots, e.g.
+	// "2/::ffff:192.0.2.1". The annotation resumes with the addresses after
+	// it. When empty, it starts from the first address.
+	ResumeAfter string
+}
+
+// DefaultFIEAnnotateConfig returns a FIEAnnotateConfig with sensible defaults.
+func DefaultFIEAnnotateConfig() FIEAnnotateConfig {
+	return FIEAnnotateConfig{
+		ChunkSize:         DefaultFIEAnnotateChunkSize,
+		PreparationPolicy: DefaultFIEAnnotatePreparationPolicy,
+	}
+}
+
+// Validate checks that the config selects a single prefix source.
+func (c FIEAnnotateConfig) Validate() error {
+	if (c.Dict == nil) == (c.Prefixes == nil) {
+		return fmt.Errorf("fie: exactly one of a dictionary or a ripeprefixes table must be set")
+	}
+	if c.Dict != nil && (c.Closest || !c.QueryTime.IsZero()) {
+		return fmt.Errorf("fie: snapshot selection requires a ripeprefixes table, a dictionary holds a single snapshot")
+	}
+	if c.Closest && !c.QueryTime.IsZero() {
+		return fmt.Errorf("fie: a fixed query time and the closest snapshot are mutually exclusive")
+	}
+	if c.ChunkSize <= 0 {
+		return fmt.Errorf("fie: chunk size must be positive")
+	}
+	if _, _, err := c.resumePosition(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resumePosition parses ResumeAfter into a pass index, from 0, and a cursor.
+func (c FIEAnnotateConfig) resumePosition() (int, string, error) {
+	if c.ResumeAfter == "" {
+		return 0, zeroCursor, nil
+	}
+	passStr, cursor, ok := strings.Cut(c.ResumeAfter, "/")
+	if !ok {
+		return 0, c.ResumeAfter, nil
+	}
+	pass, err := strconv.Atoi(passStr)
+	if err != nil || pass < 1 || cursor == "" {
+		return 0, "", fmt.Errorf("fie: invalid resume position %q, want [<pass>/]<address>", c.ResumeAfter)
+	}
+	return pass - 1, cursor, nil
+}
+
+// FIEAnnotateService annotates FIEs with the origin ASNs of their near and
+// far reply addresses and of their destination, by longest prefix match
+// against BGP prefixes.
+type FIEAnnotateService struct {
+	store  *store.Store
+	config FIEAnnotateConfig
+}
+
+// NewFIEAnnotateService creates a new FIEAnnotateService with the given store and config.
+func NewFIEAnnotateService(s *store.Store, config FIEAnnotateConfig) *FIEAnnotateService {
+	return &FIEAnnotateService{
+		store:  s,
+		config: config,
+	}
+}
+
+// annotatePass annotates the FIEs whose near_sent_timestamp falls in
+// [from, to) with a single dictionary. Zero bounds are open.
+type annotatePass struct {
+	queryTime time.Time // snapshot loaded in the dictionary, zero for a user dictionary
+	from, to  time.Time
+	index     int  // from 0
+	multi     bool // whether there are several passes
+}
+
+// resume returns the resume position of the pass after cursor.
+func (p annotatePass) resume(cursor string) string {
+	if !p.multi {
+		return cursor
+	}
+	return fmt.Sprintf("%d/%s", p.index+1, cursor)
+}
+
+// Annotate copies the FIEs of source into dest with the near_asn, far_asn
+// and destination_asn columns added. Addresses not covered by any prefix,
+// and missing far replies, are annotated with ASN 0.
+func (f *FIEAnnotateService) Annotate(ctx context.Context, source, dest store.DatabaseTable) error {
+	log := slog.Default()
+
+	if err := f.config.Validate(); err != nil {
+		return err
+	}
+
+	// Step 1: Validate source schema.
+	sourceSchema, err := f.store.TableSchema(ctx, source)
+	if err != nil {
+		return fmt.Errorf("fie: failed to get source schema: %w", err)
+	}
+	if sourceSchema == nil {
+		return fmt.Errorf("fie: source table %s.%s does not exist", source.Database, source.Table)
+	}
+	var provenance, found bool
+	for _, p := range []bool{false, true} {
+		if ok, _ := schema.AreEquivalent(schema.FIEsSchema{Provenance: p}, sourceSchema, false); ok {
+			provenance, found = p, true
+			break
+		}
+	}
+	if !found {
+		missing, _ := schema.MissingColumns(schema.FIEsSchema{}, sourceSchema)
+		return fmt.Errorf("fie: source table %s.%s is not a fies table, missing columns: %v", source.Database, source.Table, missing)
+	}
+
+	// Step 2: Prepare destination table.
+	destSchema := schema.FIEsSchema{Provenance: provenance, Annotated: true}
+	if err := f.store.PrepareTable(ctx, f.config.PreparationPolicy, dest, destSchema); err != nil {
+		return fmt.Errorf("fie: failed to prepare destination table: %w", err)
+	}
+	existingSchema, err := f.store.TableSchema(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("fie: failed to get destination schema: %w", err)
+	}
+	if ok, err := schema.AreEquivalent(destSchema, existingSchema, false); err != nil {
+		return fmt.Errorf("fie: failed to compare schemas: %w", err)
+	} else if !ok {
+		missing, _ := schema.MissingColumns(destSchema, existingSchema)
+		extra, _ := schema.MissingColumns(existingSchema, destSchema)
+		return fmt.Errorf("fie: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+			dest.Database, dest.Table, destSchema.SchemaName(), missing, extra)
+	}
+
+	// Step 3: Plan one pass per dictionary.
+	passes, err := f.passes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Step 4: Run the keyset-paginated INSERT loop of each pass, from the
+	// resume position if set.
+	resumePass, cursor, _ := f.config.resumePosition()
+	if resumePass >= len(passes) {
+		return fmt.Errorf("fie: cannot resume at pass %d, only %d pass(es)", resumePass+1, len(passes))
+	}
+	start := time.Now()
+	var (
+		totalRows uint64
+		chunks    int64
+	)
+	for i, pass := range passes {
+		if i < resumePass {
+			continue
+		}
+		if i > resumePass {
+			cursor = zeroCursor
+		}
+		pass.index, pass.multi = i, len(passes) > 1
+		dict := f.config.Dict
+		if dict == nil {
+			tmp := store.DatabaseTable{Database: dest.Database, Table: fmt.Sprintf("%s__asn_%s", dest.Table, pass.queryTime.Format("20060102T150405"))}
+			dict = &tmp
+		}
+		log.InfoContext(ctx, "annotating FIEs",
+			"pass", fmt.Sprintf("%d/%d", i+1, len(passes)),
+			"dict", fmt.Sprintf("%s.%s", dict.Database, dict.Table),
+			"source", fmt.Sprintf("%s.%s", source.Database, source.Table),
+			"dest", fmt.Sprintf("%s.%s", dest.Database, dest.Table),
+		)
+		rows, err := f.runPass(ctx, source, dest, *dict, pass, cursor, &chunks)
+		totalRows += rows
+		if err != nil {
+			return err
+		}
+	}
+
+	log.InfoContext(ctx, "annotate complete",
+		"passes", len(passes),
+		"total_rows", totalRows,
+		"elapsed", time.Since(start).Round(time.Second),
+	)
+	return nil
+}
+
+// passes returns the passes needed by the config: a single unbounded pass
+// unless the closest snapshot is requested, in which case each snapshot
+// covers the FIEs closer to it than to its neighbours.
+func (f *FIEAnnotateService) passes(ctx context.Context) ([]annotatePass, error) {
+	if f.config.Dict != nil {
+		return []annotatePass{{}}, nil
+	}
+
+	snapshots, err := ripeSnapshots(ctx, f.store, *f.config.Prefixes)
+	if err != nil {
+		return nil, fmt.Errorf("fie: %w", err)
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("fie: prefixes table %s.%s is empty", f.config.Prefixes.Database, f.config.Prefixes.Table)
+	}
+	if !f.config.Closest {
+		if !f.config.QueryTime.IsZero() {
+			t := f.config.QueryTime.UTC()
+			for _, s := range snapshots {
+				if s.Equal(t) {
+					return []annotatePass{{queryTime: t}}, nil
+				}
+			}
+			return nil, fmt.Errorf("fie: no snapshot at %s in %s.%s", t.Format(time.RFC3339), f.config.Prefixes.Database, f.config.Prefixes.Table)
+		}
+		return []annotatePass{{queryTime: snapshots[len(snapshots)-1]}}, nil
+	}
+
+	passes := make([]annotatePass, len(snapshots))
+	for i, s := range snapshots {
+		passes[i].queryTime = s
+		if i > 0 {
+			passes[i].from = snapshots[i-1].Add(s.Sub(snapshots[i-1]) / 2)
+		}
+		if i < len(snapshots)-1 {
+			passes[i].to = s.Add(snapshots[i+1].Sub(s) / 2)
+		}
+	}
+	return passes, nil
+}
+
+// runPass annotates the FIEs of a pass after cursor with dict, creating it
+// first from the prefixes table if needed, and returns the number of rows
+// inserted. chunks counts the chunks committed. Once started, a chunk runs
+// to completion even if ctx is canceled, so that an interrupted annotation
+// stops on a chunk boundary and can be resumed.
+func (f *FIEAnnotateService) runPass(ctx context.Context, source, dest, dict store.DatabaseTable, pass annotatePass, cursor string, chunks *int64) (uint64, error) {
+	log := slog.Default()
+	chunkCtx := context.WithoutCancel(ctx)
+	interrupted := func() error {
+		return &InterruptedError{Op: "fie", Dest: dest, Chunks: *chunks, Resume: pass.resume(cursor), Err: ctx.Err()}
+	}
+	if ctx.Err() != nil {
+		return 0, interrupted()
+	}
+
+	if f.config.Prefixes != nil {
+		dicts := NewDictService(f.store)
+		if _, err := dicts.Create(ctx, dict, DictConfig{Source: *f.config.Prefixes, QueryTime: pass.queryTime, Replace: true}); err != nil {
+			if ctx.Err() != nil {
+				return 0, interrupted()
+			}
+			return 0, err
+		}
+		defer func() {
+			// Use a fresh context so that the dictionary is dropped even
+			// if ctx was canceled.
+			if err := dicts.Drop(context.WithoutCancel(ctx), dict); err != nil {
+				log.WarnContext(ctx, "failed to drop temporary dictionary", "error", err)
+			}
+		}()
+	}
+
+	data := fieAnnotateTemplateData{
+		SourceDatabase:  source.Database,
+		SourceTable:     source.Table,
+		DestDatabase:    dest.Database,
+		DestTable:       dest.Table,
+		Dict:            fmt.Sprintf("%s.%s", dict.Database, dict.Table),
+		ChunkSize:       f.config.ChunkSize,
+		Cursor:          cursor,
+		WindowCondition: windowCondition("near_sent_timestamp", pass.from, pass.to),
+	}
+
+	chunk := 0
+	var totalRows uint64
+	for {
+		cursor = data.Cursor
+		if ctx.Err() != nil {
+			return totalRows, interrupted()
+		}
+		chunkStart := time.Now()
+
+		last, err := f.lastAddress(ctx, data)
+		if err != nil {
+			if ctx.Err() != nil {
+				return totalRows, interrupted()
+			}
+			return totalRows, fmt.Errorf("fie: failed to get last address for cursor %s: %w", data.Cursor, err)
+		}
+		if last == "" {
+			break
+		}
+		data.Last = last
+
+		countBefore, err := f.store.RowCount(chunkCtx, dest)
+		if err != nil {
+			return totalRows, fmt.Errorf("fie: failed to count rows before chunk %d: %w", chunk, err)
+		}
+		query, err := renderTemplate("fie_annotate_insert", fieAnnotateInsertTemplate, data)
+		if err != nil {
+			return totalRows, fmt.Errorf("fie: failed to render insert template: %w", err)
+		}
+		if err := f.store.Exec(chunkCtx, query); err != nil {
+			return totalRows, fmt.Errorf("fie: failed to insert chunk %d (cursor=%s): %w", chunk, data.Cursor, err)
+		}
+		countAfter, err := f.store.RowCount(chunkCtx, dest)
+		if err != nil {
+			return totalRows, fmt.Errorf("fie: failed to count rows after chunk %d: %w", chunk, err)
+		}
+
+		rowsInserted := countAfter - countBefore
+		chunk++
+		*chunks++
+		totalRows += rowsInserted
+
+		log.InfoContext(ctx, "chunk complete",
+			"chunk", chunk,
+			"cursor", data.Cursor,
+			"last", last,
+			"rows_inserted", rowsInserted,
+			"total_rows", totalRows,
+			"elapsed", time.Since(chunkStart).Round(time.Millisecond),
+		)
+
+		data.Cursor = last
+	}
+	return totalRows, nil
+}
+
+// lastAddress returns the last near reply address of the chunk following
+// the cursor, or "" when there is none left.
+func (f *FIEAnnotateService) lastAddress(ctx context.Context, data fieAnnotateTemplateData) (string, error) {
+	query, err := renderTemplate("fie_annotate_cursor", fieAnnotateCursorTemplate, data)
+	if err != nil {
+		return "", fmt.Errorf("fie: failed to render cursor template: %w", err)
+	}
+	var last string
+	if err := f.store.QueryRow(ctx, query).Scan(&last); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("fie: failed to scan last address: %w", err)
+	}
+	return last, nil
+}
+
+// windowCondition returns the SQL condition restricting column to
+// [from, to), with zero bounds left open, or "" if both are zero.
+func windowCondition(column string, from, to time.Time) string {
+	var cond string
+	if !from.IsZero() {
+		cond = fmt.Sprintf("%s >= toDateTime('%s', 'UTC')", column, from.UTC().Format(time.DateTime))
+	}
+	if !to.IsZero() {
+		if cond != "" {
+			cond += " AND "
+		}
+		cond += fmt.Sprintf("%s < toDateTime('%s', 'UTC')", column, to.UTC().Format(time.DateTime))
+	}
+	return cond
+}
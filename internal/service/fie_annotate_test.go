@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnnotateResumePosition(t *testing.T) {
+	tests := []struct {
+		resume     string
+		pass       int
+		cursor     string
+		wantErr    bool
+		passResume string // resume position of the pass after cursor
+		multi      bool
+	}{
+		{"", 0, zeroCursor, false, zeroCursor, false},
+		{"::ffff:192.0.2.1", 0, "::ffff:192.0.2.1", false, "::ffff:192.0.2.1", false},
+		{"2/::ffff:192.0.2.1", 1, "::ffff:192.0.2.1", false, "2/::ffff:192.0.2.1", true},
+		{"0/::1", 0, "", true, "", false},
+		{"x/::1", 0, "", true, "", false},
+		{"2/", 0, "", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resume, func(t *testing.T) {
+			config := DefaultFIEAnnotateConfig()
+			config.ResumeAfter = tt.resume
+			pass, cursor, err := config.resumePosition()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resumePosition error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if pass != tt.pass || cursor != tt.cursor {
+				t.Errorf("resumePosition = %d, %q; want %d, %q", pass, cursor, tt.pass, tt.cursor)
+			}
+			// The position reported on interruption parses back to itself.
+			p := annotatePass{index: pass, multi: tt.multi, queryTime: time.Now()}
+			if got := p.resume(cursor); got != tt.passResume {
+				t.Errorf("resume = %q, want %q", got, tt.passResume)
+			}
+		})
+	}
+}
@@ -125,6 +125,8 @@ type fieTemplateData struct {
 	Cursor               string
 	NullityCondition     string
 	CardinalityCondition string
+	Provenance           bool
+	PrefixFilter         string
 }
 
 // FIEComputeConfig holds the configuration for the FIE computation service.
@@ -134,6 +136,15 @@ type FIEComputeConfig struct {
 	PreparationPolicy store.PreparationPolicy
 	Cardinality       CardinalityPolicy
 	Nullity           NullityPolicy
+
+	// PrefixFilter is an optional SQL subquery returning the probe_dst_prefix
+	// values to compute. When empty, all prefixes of the source are computed.
+	PrefixFilter string
+
+	// ResumeAfter is the last probe_dst_prefix committed by an interrupted
+	// run; the computation resumes with the prefixes after it. When empty,
+	// it starts from the first prefix.
+	ResumeAfter string
 }
 
 // DefaultFIEComputeConfig returns a FIEComputeConfig with sensible defaults.
@@ -183,15 +194,22 @@ func (f *FIEComputeService) Compute(ctx context.Context, source, dest store.Data
 	}
 
 	var detectedSchema schema.Schema
-	switch {
-	case func() bool { ok, _ := schema.AreEquivalent(schema.ResultsSchema{}, sourceSchema, false); return ok }():
-		detectedSchema = schema.ResultsSchema{}
-	case func() bool { ok, _ := schema.AreEquivalent(schema.ResultsLiteSchema{}, sourceSchema, false); return ok }():
-		detectedSchema = schema.ResultsLiteSchema{}
-	default:
+	for _, candidate := range []schema.Schema{
+		schema.ResultsSchema{},
+		schema.ResultsLiteSchema{},
+		schema.ResultsSchema{Provenance: true},
+		schema.ResultsLiteSchema{Provenance: true},
+	} {
+		if ok, _ := schema.AreEquivalent(candidate, sourceSchema, false); ok {
+			detectedSchema = candidate
+			break
+		}
+	}
+	if detectedSchema == nil {
 		missing, _ := schema.MissingColumns(schema.ResultsLiteSchema{}, sourceSchema)
 		return fmt.Errorf("fie: source table %s.%s does not match any supported schema, missing columns: %v", source.Database, source.Table, missing)
 	}
+	provenance := hasProvenance(detectedSchema)
 
 	log.InfoContext(ctx, "detected source schema",
 		"schema", detectedSchema.SchemaName(),
@@ -202,22 +220,53 @@ func (f *FIEComputeService) Compute(ctx context.Context, source, dest store.Data
 	)
 
 	// Step 2: Prepare destination table.
-	if err := f.store.PrepareTable(ctx, f.config.PreparationPolicy, dest, schema.FIEsSchema{}); err != nil {
+	destSchema := schema.FIEsSchema{Provenance: provenance}
+	if err := f.store.PrepareTable(ctx, f.config.PreparationPolicy, dest, destSchema); err != nil {
 		return fmt.Errorf("fie: failed to prepare destination table: %w", err)
 	}
 
-	// Step 3: Run the keyset-paginated INSERT loop.
+	// Appending provenance FIEs to a table without the provenance columns (or
+	// the reverse) would misalign the positional INSERT ... SELECT.
+	existingSchema, err := f.store.TableSchema(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("fie: failed to get destination schema: %w", err)
+	}
+	if ok, err := schema.AreEquivalent(destSchema, existingSchema, false); err != nil {
+		return fmt.Errorf("fie: failed to compare schemas: %w", err)
+	} else if !ok {
+		missing, _ := schema.MissingColumns(destSchema, existingSchema)
+		extra, _ := schema.MissingColumns(existingSchema, destSchema)
+		return fmt.Errorf("fie: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+			dest.Database, dest.Table, destSchema.SchemaName(), missing, extra)
+	}
+
+	// Step 3: Run the keyset-paginated INSERT loop. Once started, a chunk
+	// runs to completion even if ctx is canceled, so that an interrupted
+	// computation stops on a chunk boundary and can be resumed.
 	cursor := zeroCursor
+	if f.config.ResumeAfter != "" {
+		cursor = f.config.ResumeAfter
+	}
 	chunk := 0
 	totalRows := uint64(0)
 	start := time.Now()
+	chunkCtx := context.WithoutCancel(ctx)
+	interrupted := func() error {
+		return &InterruptedError{Op: "fie", Dest: dest, Chunks: int64(chunk), Resume: cursor, Err: ctx.Err()}
+	}
 
 	for {
+		if ctx.Err() != nil {
+			return interrupted()
+		}
 		chunkStart := time.Now()
 
 		// Get the last prefix of this chunk for the next cursor.
 		lastPrefix, err := f.fiesLastPrefix(ctx, source, cursor, detectedSchema)
 		if err != nil {
+			if ctx.Err() != nil {
+				return interrupted()
+			}
 			return fmt.Errorf("fie: failed to get last prefix for cursor %s: %w", cursor, err)
 		}
 		if lastPrefix == "" {
@@ -225,18 +274,18 @@ func (f *FIEComputeService) Compute(ctx context.Context, source, dest store.Data
 		}
 
 		// Count rows before insert.
-		countBefore, err := f.store.RowCount(ctx, dest)
+		countBefore, err := f.store.RowCount(chunkCtx, dest)
 		if err != nil {
 			return fmt.Errorf("fie: failed to count rows before chunk %d: %w", chunk, err)
 		}
 
 		// Insert the chunk.
-		if err := f.insertChunk(ctx, source, dest, cursor, detectedSchema); err != nil {
+		if err := f.insertChunk(chunkCtx, source, dest, cursor, detectedSchema); err != nil {
 			return fmt.Errorf("fie: failed to insert chunk %d (cursor=%s): %w", chunk, cursor, err)
 		}
 
 		// Count rows after insert.
-		countAfter, err := f.store.RowCount(ctx, dest)
+		countAfter, err := f.store.RowCount(chunkCtx, dest)
 		if err != nil {
 			return fmt.Errorf("fie: failed to count rows after chunk %d: %w", chunk, err)
 		}
@@ -282,6 +331,7 @@ func (f *FIEComputeService) fiesLastPrefix(ctx context.Context, source store.Dat
 		SourceTable:    source.Table,
 		ChunkSize:      f.config.ChunkSize,
 		Cursor:         cursor,
+		PrefixFilter:   f.config.PrefixFilter,
 	})
 	if err != nil {
 		return "", fmt.Errorf("fie: failed to render cursor template: %w", err)
@@ -325,6 +375,8 @@ func (f *FIEComputeService) insertChunk(ctx context.Context, source, dest store.
 		Cursor:               cursor,
 		NullityCondition:     nullityCond,
 		CardinalityCondition: cardinalityCond,
+		Provenance:           hasProvenance(s),
+		PrefixFilter:         f.config.PrefixFilter,
 	})
 	if err != nil {
 		return fmt.Errorf("fie: failed to render insert template: %w", err)
@@ -334,3 +386,16 @@ func (f *FIEComputeService) insertChunk(ctx context.Context, source, dest store.
 	}
 	return nil
 }
+
+// hasProvenance reports whether a results schema carries the measurement_uuid
+// and agent_uuid provenance columns.
+func hasProvenance(s schema.Schema) bool {
+	switch s := s.(type) {
+	case schema.ResultsSchema:
+		return s.Provenance
+	case schema.ResultsLiteSchema:
+		return s.Provenance
+	default:
+		return false
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+// InterruptedError is returned by the chunked services when their context is
+// canceled, e.g. on SIGTERM. The chunk in progress is completed before
+// stopping, so everything up to Resume is committed to Dest and a rerun with
+// the append policy can pick up from there.
+type InterruptedError struct {
+	Op     string // error prefix of the service, e.g. "fie"
+	Dest   store.DatabaseTable
+	Chunks int64 // chunks committed, including those skipped on resume
+	// Resume is the position to resume from: the last cursor of a keyset
+	// loop, the number of chunks to skip, or the first snapshot left by the
+	// services skipping the snapshots already computed.
+	Resume string
+	Err    error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("%s: interrupted after %d chunk(s) committed to %s.%s: %v", e.Op, e.Chunks, e.Dest.Database, e.Dest.Table, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
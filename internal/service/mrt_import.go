@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/mrt"
+	"github.com/dioptra-io/ufuk-research/internal/schema"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultMRTImportPreparationPolicy = store.PreparationPolicyFail
+	DefaultMRTImportMinPeers          = 1
+	DefaultMRTImportBatchSize         = 100_000
+)
+
+// MRTImportConfig holds the configuration for the MRTImportService.
+type MRTImportConfig struct {
+	PreparationPolicy store.PreparationPolicy
+	// MinPeers is the number of collector peers that must see a prefix with
+	// a given origin for it to be imported. Raising it filters out routes
+	// leaked to a single peer, similar to the noise filter of RIPE Stat.
+	MinPeers int
+	// BatchSize is the number of rows per insert.
+	BatchSize int
+	// QueryTime overrides the dump timestamp as the query_time of the rows.
+	QueryTime time.Time
+}
+
+// DefaultMRTImportConfig returns an MRTImportConfig with sensible defaults.
+func DefaultMRTImportConfig() MRTImportConfig {
+	return MRTImportConfig{
+		PreparationPolicy: DefaultMRTImportPreparationPolicy,
+		MinPeers:          DefaultMRTImportMinPeers,
+		BatchSize:         DefaultMRTImportBatchSize,
+	}
+}
+
+// MRTImportStats summarizes an import.
+type MRTImportStats struct {
+	QueryTime  time.Time
+	RIBs       int // prefixes read from the dump
+	Rows       int // (prefix, origin) pairs inserted
+	NoOrigin   int // RIB entries whose origin is ambiguous or missing
+	BelowPeers int // (prefix, origin) pairs seen by fewer than MinPeers peers
+	Skipped    int // MRT records other than unicast RIBs
+}
+
+// MRTImportService imports prefix origins from MRT TABLE_DUMP_V2 RIB dumps
+// into a ripeprefixes table. It is a fully local alternative to
+// RipePrefixesService.
+type MRTImportService struct {
+	store  *store.Store
+	config MRTImportConfig
+}
+
+// NewMRTImportService creates a new MRTImportService with the given store and config.
+func NewMRTImportService(s *store.Store, cfg MRTImportConfig) *MRTImportService {
+	return &MRTImportService{
+		store:  s,
+		config: cfg,
+	}
+}
+
+// Import reads an MRT dump from r and inserts one row per prefix and origin
+// AS into dest. The query_time of the rows is the timestamp of the first RIB
+// record, i.e. the time of the dump, unless the config overrides it.
+// IPv4 prefixes are stored as IPv4-mapped IPv6 with their IPv4 length, as
+// RipePrefixesService does.
+func (s *MRTImportService) Import(ctx context.Context, r io.Reader, dest store.DatabaseTable) (MRTImportStats, error) {
+	log := slog.Default()
+	var stats MRTImportStats
+
+	reader, err := mrt.NewReader(r)
+	if err != nil {
+		return stats, err
+	}
+
+	if err := s.store.PrepareTable(ctx, s.config.PreparationPolicy, dest, schema.RipePrefixesSchema{}); err != nil {
+		return stats, fmt.Errorf("mrt: failed to prepare destination table: %w", err)
+	}
+	targetSchema := schema.RipePrefixesSchema{}
+	existingSchema, err := s.store.TableSchema(ctx, dest)
+	if err != nil {
+		return stats, fmt.Errorf("mrt: failed to get existing table schema: %w", err)
+	}
+	ok, err := schema.AreEquivalent(targetSchema, existingSchema, false)
+	if err != nil {
+		return stats, fmt.Errorf("mrt: failed to compare schemas: %w", err)
+	}
+	if !ok {
+		missing, _ := schema.MissingColumns(targetSchema, existingSchema)
+		extra, _ := schema.MissingColumns(existingSchema, targetSchema)
+		return stats, fmt.Errorf("mrt: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+			dest.Database, dest.Table, targetSchema.SchemaName(), missing, extra)
+	}
+
+	minPeers := max(s.config.MinPeers, 1)
+	batchSize := s.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultMRTImportBatchSize
+	}
+	qualified := fmt.Sprintf("%s.%s", dest.Database, dest.Table)
+	fetchedAt := time.Now().UTC()
+	stats.QueryTime = s.config.QueryTime.UTC()
+
+	type row struct {
+		asn       uint32
+		network   net.IP
+		prefixLen uint8
+	}
+	var pending []row
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		batch, err := s.store.PrepareBatch(ctx, "INSERT INTO "+qualified)
+		if err != nil {
+			return fmt.Errorf("mrt: failed to prepare batch: %w", err)
+		}
+		for _, r := range pending {
+			if err := batch.Append(r.asn, r.network, r.prefixLen, stats.QueryTime, fetchedAt); err != nil {
+				return fmt.Errorf("mrt: failed to append row to batch: %w", err)
+			}
+		}
+		if err := batch.Send(); err != nil {
+			return fmt.Errorf("mrt: failed to send batch: %w", err)
+		}
+		stats.Rows += len(pending)
+		log.InfoContext(ctx, "inserted prefixes", "rows", stats.Rows, "prefixes", stats.RIBs, "dest", qualified)
+		pending = pending[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		rib, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.RIBs++
+		if stats.QueryTime.IsZero() {
+			stats.QueryTime = rib.Timestamp
+		}
+
+		// Count distinct peers per origin; a peer may announce several
+		// paths with ADD-PATH.
+		peers := make(map[uint32]map[uint16]bool)
+		for _, e := range rib.Entries {
+			asn, ok := e.Origin()
+			if !ok {
+				stats.NoOrigin++
+				continue
+			}
+			if peers[asn] == nil {
+				peers[asn] = make(map[uint16]bool)
+			}
+			peers[asn][e.PeerIndex] = true
+		}
+
+		network := net.IP(rib.Prefix.Addr().AsSlice()).To16()
+		for asn, seen := range peers {
+			if len(seen) < minPeers {
+				stats.BelowPeers++
+				continue
+			}
+			pending = append(pending, row{asn: asn, network: network, prefixLen: uint8(rib.Prefix.Bits())})
+		}
+		if len(pending) >= batchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	stats.Skipped = reader.Stats().Skipped
+
+	log.InfoContext(ctx, "imported MRT dump",
+		"query_time", stats.QueryTime,
+		"prefixes", stats.RIBs,
+		"rows", stats.Rows,
+		"no_origin", stats.NoOrigin,
+		"below_min_peers", stats.BelowPeers,
+		"skipped_records", stats.Skipped,
+		"dest", qualified,
+	)
+	return stats, nil
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	_ "embed"
+
+	"github.com/dioptra-io/ufuk-research/internal/schema"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultPrefixAnalysisPreparationPolicy = store.PreparationPolicyAppend
+)
+
+//go:embed templates/prefix_moas_insert.tmpl
+var prefixMOASInsertTemplate string
+
+//go:embed templates/prefix_hierarchy_insert.tmpl
+var prefixHierarchyInsertTemplate string
+
+type prefixAnalysisTemplateData struct {
+	SourceDatabase string
+	SourceTable    string
+	DestDatabase   string
+	DestTable      string
+	Time           string
+}
+
+// PrefixAnalysisConfig holds the configuration for the PrefixAnalysisService.
+type PrefixAnalysisConfig struct {
+	PreparationPolicy store.PreparationPolicy
+	// QueryTimes selects the snapshots of the source table to analyze. If
+	// empty, AllSnapshots selects all of them, and otherwise only the latest.
+	QueryTimes   []time.Time
+	AllSnapshots bool
+	// MOASTable and HierarchyTable are the destination tables. Either may be
+	// left zero to skip the corresponding analysis.
+	MOASTable      store.DatabaseTable
+	HierarchyTable store.DatabaseTable
+}
+
+// DefaultPrefixAnalysisConfig returns a PrefixAnalysisConfig with sensible defaults.
+func DefaultPrefixAnalysisConfig() PrefixAnalysisConfig {
+	return PrefixAnalysisConfig{
+		PreparationPolicy: DefaultPrefixAnalysisPreparationPolicy,
+	}
+}
+
+// PrefixAnalysisService derives MOAS and prefix hierarchy tables from the
+// snapshots of a ripeprefixes table.
+type PrefixAnalysisService struct {
+	store  *store.Store
+	config PrefixAnalysisConfig
+}
+
+// NewPrefixAnalysisService creates a new PrefixAnalysisService with the given store and config.
+func NewPrefixAnalysisService(s *store.Store, config PrefixAnalysisConfig) *PrefixAnalysisService {
+	return &PrefixAnalysisService{
+		store:  s,
+		config: config,
+	}
+}
+
+type prefixAnalysis struct {
+	name     string
+	dest     store.DatabaseTable
+	schema   schema.Schema
+	template string
+}
+
+// Compute analyzes the selected snapshots of source. For each snapshot it
+// writes to the MOAS table the prefixes with more than one origin AS, and to
+// the hierarchy table every prefix with its most specific covering prefix.
+//
+// IPv4 prefixes are stored IPv4-mapped with their IPv4 length; they are only
+// compared with IPv4 prefixes, and their ancestors are computed on the
+// mapped address with the length offset by 96 bits.
+//
+// Snapshots already present in a destination table are skipped, so that
+// appending after fetching new snapshots only computes the new ones. Once
+// started, a snapshot is computed to completion even if ctx is canceled, and
+// the rows of a snapshot that failed are deleted, so that no table holds a
+// partial snapshot.
+func (p *PrefixAnalysisService) Compute(ctx context.Context, source store.DatabaseTable) error {
+	log := slog.Default()
+
+	var analyses []prefixAnalysis
+	if p.config.MOASTable.Table != "" {
+		analyses = append(analyses, prefixAnalysis{"moas", p.config.MOASTable, schema.MOASPrefixesSchema{}, prefixMOASInsertTemplate})
+	}
+	if p.config.HierarchyTable.Table != "" {
+		analyses = append(analyses, prefixAnalysis{"hierarchy", p.config.HierarchyTable, schema.PrefixHierarchySchema{}, prefixHierarchyInsertTemplate})
+	}
+	if len(analyses) == 0 {
+		return fmt.Errorf("prefixes: no destination table")
+	}
+
+	if err := checkRipePrefixesSource(ctx, p.store, source); err != nil {
+		return fmt.Errorf("prefixes: %w", err)
+	}
+	snapshots, err := p.snapshots(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	snapshotCtx := context.WithoutCancel(ctx)
+	for _, a := range analyses {
+		if err := p.prepare(ctx, a); err != nil {
+			return err
+		}
+		done, err := ripeSnapshots(ctx, p.store, a.dest)
+		if err != nil {
+			return fmt.Errorf("prefixes: %w", err)
+		}
+
+		computed := 0
+		var totalRows uint64
+		for _, t := range snapshots {
+			if slices.ContainsFunc(done, t.Equal) {
+				log.InfoContext(ctx, "snapshot already computed, skipping", "analysis", a.name, "query_time", t)
+				continue
+			}
+			if ctx.Err() != nil {
+				return &InterruptedError{Op: "prefixes", Dest: a.dest, Chunks: int64(computed), Resume: t.Format(time.RFC3339), Err: ctx.Err()}
+			}
+			snapshotStart := time.Now()
+
+			countBefore, err := p.store.RowCount(snapshotCtx, a.dest)
+			if err != nil {
+				return fmt.Errorf("prefixes: failed to count rows: %w", err)
+			}
+			query, err := renderTemplate("prefix_"+a.name+"_insert", a.template, prefixAnalysisTemplateData{
+				SourceDatabase: source.Database,
+				SourceTable:    source.Table,
+				DestDatabase:   a.dest.Database,
+				DestTable:      a.dest.Table,
+				Time:           t.Format(time.DateTime),
+			})
+			if err != nil {
+				return fmt.Errorf("prefixes: failed to render %s insert template: %w", a.name, err)
+			}
+			if err := p.store.Exec(snapshotCtx, query); err != nil {
+				err = fmt.Errorf("prefixes: failed to compute %s of %s: %w", a.name, t.Format(time.RFC3339), err)
+				return discardSnapshot(snapshotCtx, p.store, a.dest, "query_time", t, err)
+			}
+			countAfter, err := p.store.RowCount(snapshotCtx, a.dest)
+			if err != nil {
+				return fmt.Errorf("prefixes: failed to count rows: %w", err)
+			}
+
+			computed++
+			totalRows += countAfter - countBefore
+			log.InfoContext(ctx, "snapshot complete",
+				"analysis", a.name,
+				"query_time", t,
+				"rows", countAfter-countBefore,
+				"elapsed", time.Since(snapshotStart).Round(time.Millisecond),
+			)
+		}
+		log.InfoContext(ctx, "analysis complete",
+			"analysis", a.name,
+			"dest", fmt.Sprintf("%s.%s", a.dest.Database, a.dest.Table),
+			"snapshots", computed,
+			"total_rows", totalRows,
+		)
+	}
+
+	log.InfoContext(ctx, "prefix analysis complete",
+		"snapshots", len(snapshots),
+		"elapsed", time.Since(start).Round(time.Second),
+	)
+	return nil
+}
+
+// snapshots returns the snapshots of source selected by the config.
+func (p *PrefixAnalysisService) snapshots(ctx context.Context, source store.DatabaseTable) ([]time.Time, error) {
+	available, err := ripeSnapshots(ctx, p.store, source)
+	if err != nil {
+		return nil, fmt.Errorf("prefixes: %w", err)
+	}
+	if len(available) == 0 {
+		return nil, fmt.Errorf("prefixes: source table %s.%s is empty", source.Database, source.Table)
+	}
+
+	switch {
+	case len(p.config.QueryTimes) > 0:
+		var selected []time.Time
+		for _, t := range p.config.QueryTimes {
+			t = t.UTC()
+			if !slices.ContainsFunc(available, t.Equal) {
+				return nil, fmt.Errorf("prefixes: no snapshot at %s in %s.%s", t.Format(time.RFC3339), source.Database, source.Table)
+			}
+			selected = append(selected, t)
+		}
+		return selected, nil
+	case p.config.AllSnapshots:
+		return available, nil
+	default:
+		return available[len(available)-1:], nil
+	}
+}
+
+// prepare prepares the destination table of a and checks its schema.
+func (p *PrefixAnalysisService) prepare(ctx context.Context, a prefixAnalysis) error {
+	if err := p.store.PrepareTable(ctx, p.config.PreparationPolicy, a.dest, a.schema); err != nil {
+		return fmt.Errorf("prefixes: failed to prepare %s table: %w", a.name, err)
+	}
+	existingSchema, err := p.store.TableSchema(ctx, a.dest)
+	if err != nil {
+		return fmt.Errorf("prefixes: failed to get %s table schema: %w", a.name, err)
+	}
+	if ok, err := schema.AreEquivalent(a.schema, existingSchema, false); err != nil {
+		return fmt.Errorf("prefixes: failed to compare schemas: %w", err)
+	} else if !ok {
+		missing, _ := schema.MissingColumns(a.schema, existingSchema)
+		extra, _ := schema.MissingColumns(existingSchema, a.schema)
+		return fmt.Errorf("prefixes: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+			a.dest.Database, a.dest.Table, a.schema.SchemaName(), missing, extra)
+	}
+	return nil
+}
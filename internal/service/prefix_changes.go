@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "embed"
+
+	"github.com/dioptra-io/ufuk-research/internal/schema"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultPrefixChangesPreparationPolicy = store.PreparationPolicyAppend
+)
+
+//go:embed templates/prefix_changes_insert.tmpl
+var prefixChangesInsertTemplate string
+
+type prefixChangesTemplateData struct {
+	SourceDatabase string
+	SourceTable    string
+	DestDatabase   string
+	DestTable      string
+	PreviousTime   string
+	Time           string
+}
+
+// PrefixChangesConfig holds the configuration for the PrefixChangesService.
+type PrefixChangesConfig struct {
+	PreparationPolicy store.PreparationPolicy
+}
+
+// DefaultPrefixChangesConfig returns a PrefixChangesConfig with sensible defaults.
+func DefaultPrefixChangesConfig() PrefixChangesConfig {
+	return PrefixChangesConfig{
+		PreparationPolicy: DefaultPrefixChangesPreparationPolicy,
+	}
+}
+
+// PrefixChangesService diffs consecutive snapshots of a ripeprefixes table.
+type PrefixChangesService struct {
+	store  *store.Store
+	config PrefixChangesConfig
+}
+
+// NewPrefixChangesService creates a new PrefixChangesService with the given store and config.
+func NewPrefixChangesService(s *store.Store, config PrefixChangesConfig) *PrefixChangesService {
+	return &PrefixChangesService{
+		store:  s,
+		config: config,
+	}
+}
+
+// Compute writes to dest one row per prefix whose origin set differs
+// between two consecutive snapshots of source: "announced" when it had no
+// origin before, "withdrawn" when it has none after, "origin_changed"
+// otherwise.
+//
+// Only the ASNs present in both snapshots are compared, so that an ASN
+// missing from a snapshot, e.g. because its fetch failed, does not appear
+// to withdraw all its prefixes. Snapshot pairs already in dest, i.e. not
+// later than its latest event_time, are skipped, so that appending to dest
+// after fetching new snapshots only computes the new pairs. Once started, a
+// pair is computed to completion even if ctx is canceled, and the rows of a
+// pair that failed are deleted, so that dest never holds a partial pair.
+func (p *PrefixChangesService) Compute(ctx context.Context, source, dest store.DatabaseTable) error {
+	log := slog.Default()
+
+	if err := checkRipePrefixesSource(ctx, p.store, source); err != nil {
+		return fmt.Errorf("prefixes: %w", err)
+	}
+
+	destSchema := schema.PrefixChangesSchema{}
+	if err := p.store.PrepareTable(ctx, p.config.PreparationPolicy, dest, destSchema); err != nil {
+		return fmt.Errorf("prefixes: failed to prepare destination table: %w", err)
+	}
+	existingSchema, err := p.store.TableSchema(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("prefixes: failed to get destination schema: %w", err)
+	}
+	if ok, err := schema.AreEquivalent(destSchema, existingSchema, false); err != nil {
+		return fmt.Errorf("prefixes: failed to compare schemas: %w", err)
+	} else if !ok {
+		missing, _ := schema.MissingColumns(destSchema, existingSchema)
+		extra, _ := schema.MissingColumns(existingSchema, destSchema)
+		return fmt.Errorf("prefixes: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+			dest.Database, dest.Table, destSchema.SchemaName(), missing, extra)
+	}
+
+	snapshots, err := ripeSnapshots(ctx, p.store, source)
+	if err != nil {
+		return fmt.Errorf("prefixes: %w", err)
+	}
+	var done time.Time
+	if err := p.store.QueryRow(ctx, fmt.Sprintf("SELECT max(event_time) FROM %s.%s", dest.Database, dest.Table)).Scan(&done); err != nil {
+		return fmt.Errorf("prefixes: failed to get latest event time: %w", err)
+	}
+
+	start := time.Now()
+	pairs := 0
+	var totalRows uint64
+	pairCtx := context.WithoutCancel(ctx)
+	for i := 1; i < len(snapshots); i++ {
+		t0, t1 := snapshots[i-1], snapshots[i]
+		if !t1.After(done) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return &InterruptedError{Op: "prefixes", Dest: dest, Chunks: int64(pairs), Resume: t1.Format(time.RFC3339), Err: ctx.Err()}
+		}
+		pairStart := time.Now()
+
+		countBefore, err := p.store.RowCount(pairCtx, dest)
+		if err != nil {
+			return fmt.Errorf("prefixes: failed to count rows: %w", err)
+		}
+		query, err := renderTemplate("prefix_changes_insert", prefixChangesInsertTemplate, prefixChangesTemplateData{
+			SourceDatabase: source.Database,
+			SourceTable:    source.Table,
+			DestDatabase:   dest.Database,
+			DestTable:      dest.Table,
+			PreviousTime:   t0.Format(time.DateTime),
+			Time:           t1.Format(time.DateTime),
+		})
+		if err != nil {
+			return fmt.Errorf("prefixes: failed to render insert template: %w", err)
+		}
+		if err := p.store.Exec(pairCtx, query); err != nil {
+			err = fmt.Errorf("prefixes: failed to diff %s and %s: %w", t0.Format(time.RFC3339), t1.Format(time.RFC3339), err)
+			return discardSnapshot(pairCtx, p.store, dest, "event_time", t1, err)
+		}
+		countAfter, err := p.store.RowCount(pairCtx, dest)
+		if err != nil {
+			return fmt.Errorf("prefixes: failed to count rows: %w", err)
+		}
+
+		pairs++
+		totalRows += countAfter - countBefore
+		log.InfoContext(ctx, "snapshot pair complete",
+			"previous_time", t0,
+			"time", t1,
+			"changes", countAfter-countBefore,
+			"elapsed", time.Since(pairStart).Round(time.Millisecond),
+		)
+	}
+
+	log.InfoContext(ctx, "prefix changes complete",
+		"snapshots", len(snapshots),
+		"pairs", pairs,
+		"total_rows", totalRows,
+		"elapsed", time.Since(start).Round(time.Second),
+	)
+	return nil
+}
+
+// discardSnapshot deletes the rows of the snapshot t, those whose column is
+// t, from table after its insert failed with err, so that the next run does
+// not skip a partially inserted snapshot as done. It returns err, with a
+// hint if the rows could not be deleted.
+func discardSnapshot(ctx context.Context, s *store.Store, table store.DatabaseTable, column string, t time.Time, err error) error {
+	query := fmt.Sprintf("ALTER TABLE %s.%s DELETE WHERE %s = ? SETTINGS mutations_sync = 1", table.Database, table.Table, column)
+	if derr := s.Exec(ctx, query, t.UTC()); derr != nil {
+		return fmt.Errorf("%w; the rows of %s in %s.%s may be incomplete and could not be deleted (%v), delete them before re-running",
+			err, t.Format(time.RFC3339), table.Database, table.Table, derr)
+	}
+	return err
+}
+
+// checkRipePrefixesSource checks that table exists and has the
+// ripeprefixes schema.
+func checkRipePrefixesSource(ctx context.Context, s *store.Store, table store.DatabaseTable) error {
+	existing, err := s.TableSchema(ctx, table)
+	if err != nil {
+		return fmt.Errorf("failed to get source schema: %w", err)
+	}
+	if existing == nil {
+		return fmt.Errorf("source table %s.%s does not exist", table.Database, table.Table)
+	}
+	target := schema.RipePrefixesSchema{}
+	if ok, err := schema.AreEquivalent(target, existing, false); err != nil {
+		return fmt.Errorf("failed to compare schemas: %w", err)
+	} else if !ok {
+		missing, _ := schema.MissingColumns(target, existing)
+		return fmt.Errorf("source table %s.%s is not a %s table, missing columns: %v", table.Database, table.Table, target.SchemaName(), missing)
+	}
+	return nil
+}
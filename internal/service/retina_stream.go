@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"net/netip"
 	"time"
 
 	"github.com/dioptra-io/ufuk-research/internal/retina"
@@ -15,17 +17,47 @@ import (
 
 const (
 	DefaultRetinaPreparationPolicy = store.PreparationPolicyFail
+	DefaultRetinaQueueSize         = 16
 )
 
 // RetinaConfig holds the configuration for the RetinaService.
 type RetinaConfig struct {
 	PreparationPolicy store.PreparationPolicy
+	// Resume resumes the stream after the highest sequence_number already
+	// stored in the destination table, if any. Only meaningful with the
+	// append policy.
+	Resume bool
+	// Deduplicate makes re-running a stream into the same table safe: the
+	// table uses the ReplacingMergeTree variant of FIEsSchema, and the
+	// stream always resumes after the highest stored sequence_number, so
+	// FIEs already stored are dropped before insertion.
+	Deduplicate bool
+	// QueueSize is the number of batches buffered between the stream and
+	// the ClickHouse inserts. At most QueueSize+2 batches are held in
+	// memory; once the queue is full, reading the stream waits for inserts.
+	// Defaults to DefaultRetinaQueueSize.
+	QueueSize int
+	// Filter, if set, drops the FIEs it does not keep before batching.
+	Filter *retina.Filter
+	// DestinationPrefixes, if its Table is set, is a ripeprefixes table
+	// whose distinct prefixes are added to the destination prefixes of
+	// Filter.
+	DestinationPrefixes store.DatabaseTable
+	// Record, if set, receives the raw NDJSON lines of the stream, as
+	// described by retina.StreamOptions.Record.
+	Record io.Writer
+	// Replay, if set, is a recorded stream read instead of the Retina API,
+	// at ReplaySpeed times the pace of the FIE production timestamps, or as
+	// fast as possible if ReplaySpeed is not positive.
+	Replay      io.Reader
+	ReplaySpeed float64
 }
 
 // DefaultRetinaConfig returns a RetinaConfig with sensible defaults.
 func DefaultRetinaConfig() RetinaConfig {
 	return RetinaConfig{
 		PreparationPolicy: DefaultRetinaPreparationPolicy,
+		QueueSize:         DefaultRetinaQueueSize,
 	}
 }
 
@@ -51,12 +83,12 @@ func (s *RetinaService) Stream(ctx context.Context, dest store.DatabaseTable) er
 	log := slog.Default()
 
 	// Step 1: Prepare destination table.
-	if err := s.store.PrepareTable(ctx, s.config.PreparationPolicy, dest, schema.FIEsSchema{}); err != nil {
+	targetSchema := schema.FIEsSchema{Deduplicated: s.config.Deduplicate}
+	if err := s.store.PrepareTable(ctx, s.config.PreparationPolicy, dest, targetSchema); err != nil {
 		return fmt.Errorf("retina: failed to prepare destination table: %w", err)
 	}
 
 	// Step 2: Validate schema.
-	targetSchema := schema.FIEsSchema{}
 	existingSchema, err := s.store.TableSchema(ctx, dest)
 	if err != nil {
 		return fmt.Errorf("retina: failed to get existing table schema: %w", err)
@@ -71,37 +103,251 @@ func (s *RetinaService) Stream(ctx context.Context, dest store.DatabaseTable) er
 		return fmt.Errorf("retina: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
 			dest.Database, dest.Table, targetSchema.SchemaName(), missing, extra)
 	}
+	if s.config.Deduplicate {
+		engine, err := s.store.TableEngine(ctx, dest)
+		if err != nil {
+			return fmt.Errorf("retina: %w", err)
+		}
+		if engine != "ReplacingMergeTree" {
+			return fmt.Errorf("retina: destination table %s.%s uses %s, deduplication requires ReplacingMergeTree",
+				dest.Database, dest.Table, engine)
+		}
+	}
+
+	// Step 3: Find the resume point.
+	var after uint64
+	if s.config.Resume || s.config.Deduplicate {
+		if after, err = s.maxSequenceNumber(ctx, dest); err != nil {
+			return err
+		}
+	}
 
-	// Step 3: Stream and insert.
+	// Step 4: Load the destination prefixes of the filter.
+	if s.config.DestinationPrefixes.Table != "" {
+		prefixes, err := s.destinationPrefixes(ctx, s.config.DestinationPrefixes)
+		if err != nil {
+			return err
+		}
+		if s.config.Filter == nil {
+			s.config.Filter = &retina.Filter{}
+		}
+		s.config.Filter.Destinations = append(s.config.Filter.Destinations, prefixes...)
+		log.InfoContext(ctx, "loaded destination prefixes",
+			"source", fmt.Sprintf("%s.%s", s.config.DestinationPrefixes.Database, s.config.DestinationPrefixes.Table),
+			"prefixes", len(prefixes),
+		)
+	}
+
+	// Step 5: Stream and insert. The client decodes and batches the stream
+	// in its own goroutine; batches are queued to a separate insert
+	// goroutine so that a slow insert only stalls the stream once the
+	// queue is full.
 	log.InfoContext(ctx, "streaming FIEs from Retina",
 		"dest", fmt.Sprintf("%s.%s", dest.Database, dest.Table),
+		"after_sequence_number", after,
+		"replay", s.config.Replay != nil,
+		"record", s.config.Record != nil,
 	)
-	var total int
-	for r := range s.retinaClient.Stream(ctx) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	opts := retina.StreamOptions{After: after, Filter: s.config.Filter, Record: s.config.Record}
+	var stream <-chan retina.StreamResponse
+	if s.config.Replay != nil {
+		stream = s.retinaClient.Replay(streamCtx, s.config.Replay, s.config.ReplaySpeed, opts)
+	} else {
+		stream = s.retinaClient.StreamWith(streamCtx, opts)
+	}
+
+	queueSize := s.config.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultRetinaQueueSize
+	}
+	queue := make(chan []retina.SequencedFIE, queueSize)
+	inserted := make(chan retinaInsertStats, 1)
+	go func() {
+		inserted <- s.insertLoop(ctx, dest, queue, cancel)
+	}()
+
+	var (
+		reconnects    int
+		resets        int
+		missed        uint64
+		maxQueueDepth int
+		queueWait     time.Duration
+		streamErr     error
+	)
+	for r := range stream {
+		if r.Reconnect != nil {
+			reconnects++
+			log.WarnContext(ctx, "retina connection lost, reconnecting",
+				"attempt", r.Reconnect.Attempt,
+				"delay", r.Reconnect.Delay,
+				"after_sequence_number", r.Reconnect.After,
+				"error", r.Reconnect.Cause,
+			)
+			continue
+		}
+		if r.Gap != nil && r.Gap.Reset() {
+			resets++
+			log.WarnContext(ctx, "retina sequence numbers went backwards, the server numbering was reset",
+				"after_sequence_number", r.Gap.After,
+				"next_sequence_number", r.Gap.Next,
+			)
+			continue
+		}
+		if r.Gap != nil {
+			missed += r.Gap.Missing()
+			log.WarnContext(ctx, "retina sequence gap after reconnection",
+				"after_sequence_number", r.Gap.After,
+				"next_sequence_number", r.Gap.Next,
+				"missing", r.Gap.Missing(),
+			)
+			continue
+		}
 		if r.Err != nil {
-			if errors.Is(r.Err, context.DeadlineExceeded) || errors.Is(r.Err, context.Canceled) {
-				break
+			if !errors.Is(r.Err, context.DeadlineExceeded) && !errors.Is(r.Err, context.Canceled) {
+				streamErr = fmt.Errorf("retina: stream error: %w", r.Err)
 			}
-			return fmt.Errorf("retina: stream error: %w", r.Err)
+			break
 		}
-		if err := s.insertBatch(context.Background(), dest, r.Batch); err != nil {
-			return err
+
+		start := time.Now()
+		select {
+		case queue <- r.Batch:
+		case stats := <-inserted:
+			// The insert goroutine stopped on an error and canceled the
+			// stream; drain the client channel so that it can exit.
+			for range stream {
+			}
+			return stats.err
 		}
-		total += len(r.Batch)
-		log.InfoContext(ctx, "inserted batch",
-			"count", len(r.Batch),
-			"total", total,
-			"last_sequence_number", r.Batch[len(r.Batch)-1].SequenceNumber,
-		)
+		queueWait += time.Since(start)
+		maxQueueDepth = max(maxQueueDepth, len(queue))
+	}
+	close(queue)
+	// The batches already queued are inserted even after a stream error.
+	stats := <-inserted
+	if stats.err != nil {
+		return stats.err
+	}
+	if streamErr != nil {
+		return streamErr
+	}
+
+	// Step 6: Log completion.
+	dropped := s.config.Filter.Dropped()
+	var meanLatency time.Duration
+	if stats.batches > 0 {
+		meanLatency = stats.latency / time.Duration(stats.batches)
 	}
-	// Step 4: Log completion.
 	log.InfoContext(ctx, "stream complete",
-		"total", total,
+		"total", stats.total,
+		"batches", stats.batches,
+		"reconnects", reconnects,
+		"resets", resets,
+		"missed", missed,
+		"max_queue_depth", maxQueueDepth,
+		"queue_wait", queueWait,
+		"mean_insert_latency", meanLatency,
+		"max_insert_latency", stats.maxLatency,
+		"dropped", dropped.Total(),
+		"dropped_agent_id", dropped.AgentID,
+		"dropped_ip_version", dropped.IPVersion,
+		"dropped_protocol", dropped.Protocol,
+		"dropped_destination", dropped.Destination,
+		"dropped_ttl", dropped.TTL,
 		"dest", fmt.Sprintf("%s.%s", dest.Database, dest.Table),
 	)
 	return nil
 }
 
+// retinaInsertStats summarises the inserts of a stream.
+type retinaInsertStats struct {
+	total      int
+	batches    int
+	latency    time.Duration // sum over batches
+	maxLatency time.Duration
+	err        error
+}
+
+// insertLoop inserts the batches of queue into dest until queue is closed
+// or an insert fails. Inserts use a background context so that the batches
+// queued when ctx is canceled are still flushed. On the first failed insert
+// it calls stop, so that the stream ends without decoding batches that
+// would be dropped, and inserts nothing more: dest then ends with the last
+// committed batch, which is where a resumed stream picks up.
+func (s *RetinaService) insertLoop(ctx context.Context, dest store.DatabaseTable, queue <-chan []retina.SequencedFIE, stop context.CancelFunc) retinaInsertStats {
+	log := slog.Default()
+	var stats retinaInsertStats
+	for batch := range queue {
+		start := time.Now()
+		if err := s.insertBatch(context.Background(), dest, batch); err != nil {
+			stop()
+			stats.err = err
+			return stats
+		}
+		latency := time.Since(start)
+		stats.total += len(batch)
+		stats.batches++
+		stats.latency += latency
+		stats.maxLatency = max(stats.maxLatency, latency)
+		log.InfoContext(ctx, "inserted batch",
+			"count", len(batch),
+			"total", stats.total,
+			"last_sequence_number", batch[len(batch)-1].SequenceNumber,
+			"queue_depth", len(queue),
+			"insert_latency", latency,
+			"dropped", s.config.Filter.Dropped().Total(),
+		)
+	}
+	return stats
+}
+
+// destinationPrefixes returns the distinct prefixes of a ripeprefixes table,
+// with IPv4-mapped networks converted back to IPv4 prefixes.
+func (s *RetinaService) destinationPrefixes(ctx context.Context, source store.DatabaseTable) ([]netip.Prefix, error) {
+	q := fmt.Sprintf("SELECT DISTINCT toString(network), prefix_len FROM %s.%s", source.Database, source.Table)
+	rows, err := s.store.Query(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("retina: failed to read prefixes of %s.%s: %w", source.Database, source.Table, err)
+	}
+	defer rows.Close()
+
+	var prefixes []netip.Prefix
+	for rows.Next() {
+		var (
+			network   string
+			prefixLen uint8
+		)
+		if err := rows.Scan(&network, &prefixLen); err != nil {
+			return nil, fmt.Errorf("retina: failed to scan prefix: %w", err)
+		}
+		p, err := storedPrefix(network, prefixLen)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("retina: failed to read prefixes: %w", err)
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("retina: no prefixes in %s.%s", source.Database, source.Table)
+	}
+	return prefixes, nil
+}
+
+// maxSequenceNumber returns the highest sequence_number stored in dest, 0
+// if it is empty.
+func (s *RetinaService) maxSequenceNumber(ctx context.Context, dest store.DatabaseTable) (uint64, error) {
+	var last uint64
+	query := fmt.Sprintf("SELECT max(sequence_number) FROM %s.%s", dest.Database, dest.Table)
+	if err := s.store.QueryRow(ctx, query).Scan(&last); err != nil {
+		return 0, fmt.Errorf("retina: failed to query last sequence number: %w", err)
+	}
+	return last, nil
+}
+
 // insertBatch inserts a batch of SequencedFIEs into dest.
 func (s *RetinaService) insertBatch(ctx context.Context, dest store.DatabaseTable, batch []retina.SequencedFIE) error {
 	b, err := s.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.%s", dest.Database, dest.Table))
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"time"
 
 	"github.com/dioptra-io/ufuk-research/internal/ripe"
@@ -13,12 +14,72 @@ import (
 
 const (
 	DefaultRipePrefixesPreparationPolicy = store.PreparationPolicyFail
+	// ASNSetTableSuffix is appended to the name of a ripeprefixes table to
+	// name the ripeasnset table recording its ASN set.
+	ASNSetTableSuffix = "__asns"
 )
 
+// SelectedASN is an ASN of the set fetched by the RipePrefixesService, with
+// how it was selected.
+type SelectedASN struct {
+	ASN    uint32
+	Source string // "asns", "file:<path>", "group:<name>" or "neighbour"
+	Hops   int    // neighbour hops from the other sources, 0 for them
+	Via    uint32 // previous hop of a neighbour, 0 otherwise
+}
+
 // RipePrefixesConfig holds the configuration for the RipePrefixesService.
 type RipePrefixesConfig struct {
 	ASNs              []uint32
 	PreparationPolicy store.PreparationPolicy
+	// ContinueOnError inserts the prefixes of the ASNs that were fetched
+	// successfully even if others failed. The failed ASNs are returned in a
+	// *RipeFetchError.
+	ContinueOnError bool
+	// StatusTable, if set, receives one RipeStatusSchema row per ASN. It is
+	// created if missing and always appended to.
+	StatusTable *store.DatabaseTable
+	// ASNSet, if set, is how ASNs were selected. It is recorded for every
+	// fetched snapshot in the ripeasnset table named after the destination
+	// with ASNSetTableSuffix, prepared with the same policy. An ASN may
+	// appear several times, once per source.
+	ASNSet []SelectedASN
+}
+
+// RipeFailure is a resource (prefix, address or ASN) that could not be fetched.
+type RipeFailure struct {
+	Resource string
+	Err      error
+}
+
+// RipeFetchError is returned when some resources could not be fetched in
+// ContinueOnError mode. The rows of the other resources have been inserted.
+type RipeFetchError struct {
+	Call      string
+	QueryTime time.Time // zero for the latest data
+	Failed    []RipeFailure
+	Succeeded int
+}
+
+func (e *RipeFetchError) Error() string {
+	msg := fmt.Sprintf("ripe: %s failed for %d of %d resource(s)", e.Call, len(e.Failed), len(e.Failed)+e.Succeeded)
+	if !e.QueryTime.IsZero() {
+		msg += " at " + e.QueryTime.Format(time.RFC3339)
+	}
+	return msg
+}
+
+// fetchError returns a *RipeFetchError if any resource failed, nil otherwise.
+func fetchError(call string, t time.Time, failed []RipeFailure, succeeded int) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return &RipeFetchError{Call: call, QueryTime: t, Failed: failed, Succeeded: succeeded}
+}
+
+// asnResource returns the RIPE Stat resource of asn, as in a RipeFailure.
+func asnResource(asn uint32) string {
+	return "AS" + strconv.FormatUint(uint64(asn), 10)
 }
 
 // DefaultRipePrefixesConfig returns a RipePrefixesConfig with sensible defaults.
@@ -58,10 +119,158 @@ func (s *RipePrefixesService) Fetch(ctx context.Context, dest store.DatabaseTabl
 // FetchAt fetches prefixes for the configured ASNs at the given raw timestamp
 // and inserts them into dest.
 func (s *RipePrefixesService) FetchAt(ctx context.Context, dest store.DatabaseTable, t time.Time) error {
+	// Step 1: Prepare destination table.
+	if err := s.prepare(ctx, s.config.PreparationPolicy, dest); err != nil {
+		return err
+	}
+	return s.fetchInto(ctx, dest, t, s.config.ASNs)
+}
+
+// Prepare prepares dest, and its ASN set table if enabled, with the
+// configured policy. FetchAt prepares dest itself; Prepare is meant for
+// callers that fetch several snapshots into dest with an append policy, so
+// that the configured policy applies once rather than once per snapshot.
+func (s *RipePrefixesService) Prepare(ctx context.Context, dest store.DatabaseTable) error {
+	return s.prepare(ctx, s.config.PreparationPolicy, dest)
+}
+
+// RipeSnapshot is a RIS snapshot to fetch into a destination table.
+type RipeSnapshot struct {
+	Dest      store.DatabaseTable
+	QueryTime time.Time
+}
+
+// RipeSnapshotsReport summarizes a FetchSnapshots run.
+type RipeSnapshotsReport struct {
+	Fetched []RipeSnapshot
+	Skipped []RipeSnapshot // already present in their destination
+	Failed  []RipeSnapshotFailure
+}
+
+// RipeSnapshotFailure is a snapshot that could not be fetched.
+type RipeSnapshotFailure struct {
+	RipeSnapshot
+	Err error
+}
+
+// FetchSnapshots fetches several snapshots, possibly into the same table.
+// Each distinct destination is prepared once with the configured policy.
+// Presence is checked per ASN: a snapshot holding prefixes of every
+// configured ASN is skipped, and a partial one, left by ContinueOnError or
+// fetched with a smaller ASN set, is completed with the missing ASNs only,
+// so that an interrupted run can be resumed with the append policy.
+// A failed snapshot is reported and does not stop the others, except when
+// ctx is canceled.
+func (s *RipePrefixesService) FetchSnapshots(ctx context.Context, snapshots []RipeSnapshot) (RipeSnapshotsReport, error) {
 	log := slog.Default()
+	var report RipeSnapshotsReport
 
-	// Step 1: Prepare destination table.
-	if err := s.store.PrepareTable(ctx, s.config.PreparationPolicy, dest, schema.RipePrefixesSchema{}); err != nil {
+	prepared := make(map[store.DatabaseTable]error)
+	for i, snap := range snapshots {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		qualified := fmt.Sprintf("%s.%s", snap.Dest.Database, snap.Dest.Table)
+
+		err, ok := prepared[snap.Dest]
+		if !ok {
+			err = s.prepare(ctx, s.config.PreparationPolicy, snap.Dest)
+			prepared[snap.Dest] = err
+		}
+		var present map[uint32]bool
+		if err == nil {
+			present, err = s.snapshotASNs(ctx, snap.Dest, snap.QueryTime)
+		}
+		var missing []uint32
+		for _, asn := range s.config.ASNs {
+			if !present[asn] {
+				missing = append(missing, asn)
+			}
+		}
+		if err == nil && len(missing) == 0 {
+			log.InfoContext(ctx, "snapshot already present, skipping",
+				"progress", fmt.Sprintf("%d/%d", i+1, len(snapshots)),
+				"query_time", snap.QueryTime,
+				"dest", qualified,
+			)
+			report.Skipped = append(report.Skipped, snap)
+			continue
+		}
+		if err == nil {
+			msg := "fetching snapshot"
+			if len(present) > 0 {
+				msg = "completing partial snapshot"
+			}
+			log.InfoContext(ctx, msg,
+				"progress", fmt.Sprintf("%d/%d", i+1, len(snapshots)),
+				"query_time", snap.QueryTime,
+				"dest", qualified,
+				"asns", len(missing),
+			)
+			err = s.fetchInto(ctx, snap.Dest, snap.QueryTime, missing)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			log.ErrorContext(ctx, "snapshot failed", "query_time", snap.QueryTime, "dest", qualified, "error", err)
+			report.Failed = append(report.Failed, RipeSnapshotFailure{RipeSnapshot: snap, Err: err})
+			continue
+		}
+		report.Fetched = append(report.Fetched, snap)
+	}
+	return report, nil
+}
+
+// snapshotASNs returns the ASNs having rows with query_time t in table, a
+// ripeprefixes or ripeasnset table. An ASN originating no prefix never has
+// rows in a ripeprefixes table, so it is fetched again by every resumed run.
+func (s *RipePrefixesService) snapshotASNs(ctx context.Context, table store.DatabaseTable, t time.Time) (map[uint32]bool, error) {
+	query := fmt.Sprintf("SELECT DISTINCT asn FROM %s.%s WHERE query_time = ?", table.Database, table.Table)
+	rows, err := s.store.Query(ctx, query, t.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("ripe: failed to look up snapshot %s in %s.%s: %w", t.Format(time.RFC3339), table.Database, table.Table, err)
+	}
+	defer rows.Close()
+	asns := make(map[uint32]bool)
+	for rows.Next() {
+		var asn uint32
+		if err := rows.Scan(&asn); err != nil {
+			return nil, fmt.Errorf("ripe: failed to scan ASN: %w", err)
+		}
+		asns[asn] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ripe: failed to look up snapshot %s in %s.%s: %w", t.Format(time.RFC3339), table.Database, table.Table, err)
+	}
+	return asns, nil
+}
+
+// ripeSnapshots returns the distinct query times of a ripeprefixes table,
+// oldest first.
+func ripeSnapshots(ctx context.Context, s *store.Store, table store.DatabaseTable) ([]time.Time, error) {
+	rows, err := s.Query(ctx, fmt.Sprintf("SELECT DISTINCT query_time FROM %s.%s ORDER BY query_time", table.Database, table.Table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %s.%s: %w", table.Database, table.Table, err)
+	}
+	defer rows.Close()
+	var snapshots []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("failed to scan snapshot: %w", err)
+		}
+		snapshots = append(snapshots, t.UTC())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %s.%s: %w", table.Database, table.Table, err)
+	}
+	return snapshots, nil
+}
+
+// prepare prepares dest with the given policy and checks its schema.
+func (s *RipePrefixesService) prepare(ctx context.Context, policy store.PreparationPolicy, dest store.DatabaseTable) error {
+	if err := s.store.PrepareTable(ctx, policy, dest, schema.RipePrefixesSchema{}); err != nil {
 		return fmt.Errorf("ripe: failed to prepare destination table: %w", err)
 	}
 
@@ -82,22 +291,66 @@ func (s *RipePrefixesService) FetchAt(ctx context.Context, dest store.DatabaseTa
 			dest.Database, dest.Table, targetSchema.SchemaName(), missing, extra)
 	}
 
+	if s.config.ASNSet != nil {
+		if err := s.store.PrepareTable(ctx, policy, asnSetTable(dest), schema.RipeASNSetSchema{}); err != nil {
+			return fmt.Errorf("ripe: failed to prepare ASN set table: %w", err)
+		}
+	}
+	return nil
+}
+
+// asnSetTable returns the ripeasnset table of dest.
+func asnSetTable(dest store.DatabaseTable) store.DatabaseTable {
+	return store.DatabaseTable{Database: dest.Database, Table: dest.Table + ASNSetTableSuffix}
+}
+
+// fetchInto fetches the prefixes of asns at t and inserts them into the
+// prepared table dest.
+func (s *RipePrefixesService) fetchInto(ctx context.Context, dest store.DatabaseTable, t time.Time, asns []uint32) error {
+	log := slog.Default()
+
 	// Step 2: Fetch prefixes from RIPE Stat API.
 	log.InfoContext(ctx, "fetching prefixes from RIPE Stat",
-		"asns", s.config.ASNs,
+		"asns", asns,
 		"query_time", t,
 		"dest", fmt.Sprintf("%s.%s", dest.Database, dest.Table),
 	)
 
-	prefixes, err := s.ripeClient.PrefixesByASNs(s.config.ASNs).AtTime(t).Fetch()
+	results, err := s.ripeClient.PrefixesByASNs(asns).AtTime(t).FetchEach(ctx)
 	if err != nil {
 		return fmt.Errorf("ripe: failed to fetch prefixes: %w", err)
 	}
 
-	log.InfoContext(ctx, "fetched prefixes", "count", len(prefixes))
+	var (
+		prefixes []ripe.Prefix
+		failed   []RipeFailure
+	)
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, RipeFailure{Resource: asnResource(r.ASN), Err: r.Err})
+			continue
+		}
+		prefixes = append(prefixes, r.Prefixes...)
+	}
 
-	// Step 3: Insert prefixes into ClickHouse using native batch insert.
 	fetchedAt := time.Now().UTC()
+	if s.config.StatusTable != nil {
+		if err := s.insertStatus(ctx, *s.config.StatusTable, results, t, fetchedAt); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 && !s.config.ContinueOnError {
+		return fmt.Errorf("ripe: failed to fetch prefixes: %w", failed[0].Err)
+	}
+
+	log.InfoContext(ctx, "fetched prefixes",
+		"count", len(prefixes),
+		"asns_ok", len(results)-len(failed),
+		"asns_failed", len(failed),
+	)
+
+	// Step 3: Insert prefixes into ClickHouse using native batch insert.
 	qualified := fmt.Sprintf("INSERT INTO %s.%s", dest.Database, dest.Table)
 
 	batch, err := s.store.PrepareBatch(ctx, qualified)
@@ -126,5 +379,62 @@ func (s *RipePrefixesService) FetchAt(ctx context.Context, dest store.DatabaseTa
 		"dest", fmt.Sprintf("%s.%s", dest.Database, dest.Table),
 	)
 
+	if s.config.ASNSet != nil {
+		if err := s.insertASNSet(ctx, asnSetTable(dest), t, fetchedAt); err != nil {
+			return err
+		}
+	}
+
+	return fetchError("announced-prefixes", t, failed, len(results)-len(failed))
+}
+
+// insertASNSet records the ASN set of the snapshot t in table. ASNs already
+// recorded for t, by the run that fetched a partial snapshot, are not
+// recorded again.
+func (s *RipePrefixesService) insertASNSet(ctx context.Context, table store.DatabaseTable, t, resolvedAt time.Time) error {
+	recorded, err := s.snapshotASNs(ctx, table, t)
+	if err != nil {
+		return err
+	}
+	batch, err := s.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.%s", table.Database, table.Table))
+	if err != nil {
+		return fmt.Errorf("ripe: failed to prepare ASN set batch: %w", err)
+	}
+	for _, a := range s.config.ASNSet {
+		if recorded[a.ASN] {
+			continue
+		}
+		if err := batch.Append(t, a.ASN, a.Source, uint8(min(a.Hops, 255)), a.Via, resolvedAt); err != nil {
+			return fmt.Errorf("ripe: failed to append ASN set row: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("ripe: failed to send ASN set batch: %w", err)
+	}
+	return nil
+}
+
+// insertStatus appends the outcome of each ASN to the status table.
+func (s *RipePrefixesService) insertStatus(ctx context.Context, table store.DatabaseTable, results []ripe.ASNResult, t, fetchedAt time.Time) error {
+	if err := s.store.PrepareTable(ctx, store.PreparationPolicyAppend, table, schema.RipeStatusSchema{}); err != nil {
+		return fmt.Errorf("ripe: failed to prepare status table: %w", err)
+	}
+
+	batch, err := s.store.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s.%s", table.Database, table.Table))
+	if err != nil {
+		return fmt.Errorf("ripe: failed to prepare status batch: %w", err)
+	}
+	for _, r := range results {
+		status, errStr := "ok", ""
+		if r.Err != nil {
+			status, errStr = "failed", r.Err.Error()
+		}
+		if err := batch.Append(r.ASN, t, status, uint32(len(r.Prefixes)), errStr, fetchedAt); err != nil {
+			return fmt.Errorf("ripe: failed to append status row: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("ripe: failed to send status batch: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/ripe"
+	"github.com/dioptra-io/ufuk-research/internal/ripe/ripetest"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+	"github.com/dioptra-io/ufuk-research/internal/store/storetest"
+)
+
+// TestFetchSnapshotsCompletesPartial resumes a snapshot where an ASN failed,
+// then one fetched with a smaller ASN set: each run fetches only the ASNs
+// missing from the table.
+func TestFetchSnapshotsCompletesPartial(t *testing.T) {
+	s := storetest.Open(t)
+	dest := storetest.Table(t, s)
+	t.Cleanup(func() {
+		_ = s.Exec(context.Background(), fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", dest.Database, asnSetTable(dest).Table))
+	})
+
+	at := time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC)
+	srv := ripetest.NewServer()
+	t.Cleanup(srv.Close)
+	for asn := uint32(1); asn <= 4; asn++ {
+		srv.AddSnapshot(asn, at, fmt.Sprintf("192.0.2.%d/32", asn))
+	}
+	client := ripe.NewRipeClient(ripe.RipeConfig{Endpoint: srv.URL, MaxRetries: 1, RetryDelay: time.Millisecond, RateLimit: -1})
+
+	run := func(asns ...uint32) RipeSnapshotsReport {
+		t.Helper()
+		config := RipePrefixesConfig{ASNs: asns, PreparationPolicy: store.PreparationPolicyAppend, ContinueOnError: true}
+		for _, asn := range asns {
+			config.ASNSet = append(config.ASNSet, SelectedASN{ASN: asn, Source: "asns"})
+		}
+		report, err := NewRipePrefixesService(s, client, config).FetchSnapshots(context.Background(), []RipeSnapshot{{Dest: dest, QueryTime: at}})
+		if err != nil {
+			t.Fatalf("FetchSnapshots: %v", err)
+		}
+		return report
+	}
+	fetched := func(since int) []string {
+		var resources []string
+		for _, r := range srv.Requests()[since:] {
+			resources = append(resources, r.Resource)
+		}
+		slices.Sort(resources)
+		return resources
+	}
+
+	srv.Fail("AS2", ripetest.Failure{Status: 503, Times: 1})
+	report := run(1, 2, 3)
+	var fetchErr *RipeFetchError
+	if len(report.Failed) != 1 || !errors.As(report.Failed[0].Err, &fetchErr) || len(fetchErr.Failed) != 1 || fetchErr.Failed[0].Resource != "AS2" {
+		t.Fatalf("first run = %+v, want the snapshot failed for AS2", report)
+	}
+
+	n := len(srv.Requests())
+	if report := run(1, 2, 3); len(report.Fetched) != 1 {
+		t.Errorf("second run = %+v, want the snapshot completed", report)
+	}
+	if got := fetched(n); !slices.Equal(got, []string{"AS2"}) {
+		t.Errorf("second run fetched %v, want only AS2", got)
+	}
+
+	n = len(srv.Requests())
+	if report := run(1, 2, 3, 4); len(report.Fetched) != 1 {
+		t.Errorf("run with AS4 added = %+v, want the snapshot completed", report)
+	}
+	if got := fetched(n); !slices.Equal(got, []string{"AS4"}) {
+		t.Errorf("run with AS4 added fetched %v, want only AS4", got)
+	}
+
+	n = len(srv.Requests())
+	if report := run(1, 2, 3, 4); len(report.Skipped) != 1 || len(fetched(n)) != 0 {
+		t.Errorf("complete snapshot: report %+v, fetched %v; want it skipped", report, fetched(n))
+	}
+	if rows := storetest.RowCount(t, s, dest, ""); rows != 4 {
+		t.Errorf("%d prefix rows, want one per ASN", rows)
+	}
+	if rows := storetest.RowCount(t, s, asnSetTable(dest), ""); rows != 4 {
+		t.Errorf("%d ASN set rows, want one per ASN", rows)
+	}
+}
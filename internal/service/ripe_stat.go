@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/ripe"
+	"github.com/dioptra-io/ufuk-research/internal/schema"
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultRipeStatPreparationPolicy = store.PreparationPolicyFail
+)
+
+// RipeStatConfig holds the configuration for the RipeStatService.
+type RipeStatConfig struct {
+	PreparationPolicy store.PreparationPolicy
+	// ContinueOnError inserts the rows of the resources that were fetched
+	// successfully even if others failed. The failed resources are returned
+	// in a *RipeFetchError.
+	ContinueOnError bool
+}
+
+// DefaultRipeStatConfig returns a RipeStatConfig with sensible defaults.
+func DefaultRipeStatConfig() RipeStatConfig {
+	return RipeStatConfig{
+		PreparationPolicy: DefaultRipeStatPreparationPolicy,
+	}
+}
+
+// RipeStatService fetches the prefix-overview, network-info and
+// asn-neighbours RIPE Stat datasets into local ClickHouse tables.
+type RipeStatService struct {
+	store      *store.Store
+	ripeClient *ripe.RipeClient
+	config     RipeStatConfig
+}
+
+// NewRipeStatService creates a new RipeStatService with the given store, ripe client and config.
+func NewRipeStatService(s *store.Store, rc *ripe.RipeClient, cfg RipeStatConfig) *RipeStatService {
+	return &RipeStatService{
+		store:      s,
+		ripeClient: rc,
+		config:     cfg,
+	}
+}
+
+// FetchOverviews fetches the prefix overview of each resource, a prefix or
+// an address, at t and inserts one row per resource into dest. A zero t
+// queries the latest data.
+func (s *RipeStatService) FetchOverviews(ctx context.Context, dest store.DatabaseTable, resources []string, t time.Time) error {
+	if err := s.prepare(ctx, dest, schema.RipeOverviewSchema{}); err != nil {
+		return err
+	}
+
+	query := s.ripeClient.PrefixOverviews(resources)
+	if !t.IsZero() {
+		query = query.AtTime(t)
+	}
+	slog.Default().InfoContext(ctx, "fetching prefix overviews from RIPE Stat", "resources", len(resources), "query_time", t)
+	results, err := query.FetchEach(ctx)
+	if err != nil {
+		return fmt.Errorf("ripe: failed to fetch prefix overviews: %w", err)
+	}
+
+	var (
+		overviews []ripe.PrefixOverview
+		failed    []RipeFailure
+	)
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, RipeFailure{Resource: r.Resource, Err: r.Err})
+			continue
+		}
+		overviews = append(overviews, r.Overview)
+	}
+	if err := s.checkFailures(failed); err != nil {
+		return err
+	}
+
+	fetchedAt := time.Now().UTC()
+	err = s.insert(ctx, dest, len(overviews), func(i int) []any {
+		ov := overviews[i]
+		return []any{
+			ov.Resource,
+			prefixNetwork(ov.Prefix),
+			uint8(ov.Prefix.Bits()),
+			ov.Announced,
+			ov.LessSpecific,
+			ov.ASNs,
+			ov.Holders,
+			ov.Block,
+			ov.BlockDesc,
+			uint32(ov.RelatedPrefixes),
+			ov.QueryTime,
+			fetchedAt,
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return fetchError("prefix-overview", t, failed, len(overviews))
+}
+
+// FetchNetworkInfo looks up the longest announced prefix of each address and
+// inserts one row per address into dest. IPv4 addresses are stored
+// IPv4-mapped, and their prefixes with their IPv4 length.
+func (s *RipeStatService) FetchNetworkInfo(ctx context.Context, dest store.DatabaseTable, addrs []netip.Addr) error {
+	if err := s.prepare(ctx, dest, schema.RipeNetworkInfoSchema{}); err != nil {
+		return err
+	}
+
+	slog.Default().InfoContext(ctx, "fetching network info from RIPE Stat", "addresses", len(addrs))
+	results, err := s.ripeClient.NetworkInfo(addrs).FetchEach(ctx)
+	if err != nil {
+		return fmt.Errorf("ripe: failed to fetch network info: %w", err)
+	}
+
+	var (
+		infos  []ripe.NetworkInfo
+		failed []RipeFailure
+	)
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, RipeFailure{Resource: r.Address.String(), Err: r.Err})
+			continue
+		}
+		infos = append(infos, r.Info)
+	}
+	if err := s.checkFailures(failed); err != nil {
+		return err
+	}
+
+	fetchedAt := time.Now().UTC()
+	err = s.insert(ctx, dest, len(infos), func(i int) []any {
+		info := infos[i]
+		var prefixLen uint8
+		if info.Prefix.IsValid() {
+			prefixLen = uint8(info.Prefix.Bits())
+		}
+		return []any{
+			net.IP(info.Address.AsSlice()).To16(),
+			prefixNetwork(info.Prefix),
+			prefixLen,
+			info.ASNs,
+			fetchedAt,
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return fetchError("network-info", time.Time{}, failed, len(infos))
+}
+
+// FetchNeighbours fetches the neighbours of each ASN at t and inserts one row
+// per adjacency into dest. A zero t queries the latest data.
+func (s *RipeStatService) FetchNeighbours(ctx context.Context, dest store.DatabaseTable, asns []uint32, t time.Time) error {
+	if err := s.prepare(ctx, dest, schema.RipeNeighboursSchema{}); err != nil {
+		return err
+	}
+
+	query := s.ripeClient.NeighboursByASNs(asns)
+	if !t.IsZero() {
+		query = query.AtTime(t)
+	}
+	slog.Default().InfoContext(ctx, "fetching AS neighbours from RIPE Stat", "asns", len(asns), "query_time", t)
+	results, err := query.FetchEach(ctx)
+	if err != nil {
+		return fmt.Errorf("ripe: failed to fetch neighbours: %w", err)
+	}
+
+	var (
+		neighbours []ripe.Neighbour
+		failed     []RipeFailure
+	)
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, RipeFailure{Resource: asnResource(r.ASN), Err: r.Err})
+			continue
+		}
+		neighbours = append(neighbours, r.Neighbours...)
+	}
+	if err := s.checkFailures(failed); err != nil {
+		return err
+	}
+
+	fetchedAt := time.Now().UTC()
+	err = s.insert(ctx, dest, len(neighbours), func(i int) []any {
+		n := neighbours[i]
+		return []any{n.ASN, n.Neighbour, n.Type, n.Power, n.V4Peers, n.V6Peers, n.QueryTime, fetchedAt}
+	})
+	if err != nil {
+		return err
+	}
+	return fetchError("asn-neighbours", t, failed, len(results)-len(failed))
+}
+
+// prepare prepares dest with the configured policy and checks its schema.
+func (s *RipeStatService) prepare(ctx context.Context, dest store.DatabaseTable, target schema.Schema) error {
+	if err := s.store.PrepareTable(ctx, s.config.PreparationPolicy, dest, target); err != nil {
+		return fmt.Errorf("ripe: failed to prepare destination table: %w", err)
+	}
+	existingSchema, err := s.store.TableSchema(ctx, dest)
+	if err != nil {
+		return fmt.Errorf("ripe: failed to get existing table schema: %w", err)
+	}
+	ok, err := schema.AreEquivalent(target, existingSchema, false)
+	if err != nil {
+		return fmt.Errorf("ripe: failed to compare schemas: %w", err)
+	}
+	if !ok {
+		missing, _ := schema.MissingColumns(target, existingSchema)
+		extra, _ := schema.MissingColumns(existingSchema, target)
+		return fmt.Errorf("ripe: destination table %s.%s schema does not match %s, missing columns: %v, extra columns: %v",
+			dest.Database, dest.Table, target.SchemaName(), missing, extra)
+	}
+	return nil
+}
+
+// checkFailures returns the first failure unless ContinueOnError is set.
+func (s *RipeStatService) checkFailures(failed []RipeFailure) error {
+	if len(failed) > 0 && !s.config.ContinueOnError {
+		return failed[0].Err
+	}
+	return nil
+}
+
+// insert sends the n rows returned by row to dest in a single batch.
+func (s *RipeStatService) insert(ctx context.Context, dest store.DatabaseTable, n int, row func(i int) []any) error {
+	qualified := fmt.Sprintf("%s.%s", dest.Database, dest.Table)
+	batch, err := s.store.PrepareBatch(ctx, "INSERT INTO "+qualified)
+	if err != nil {
+		return fmt.Errorf("ripe: failed to prepare batch: %w", err)
+	}
+	for i := range n {
+		if err := batch.Append(row(i)...); err != nil {
+			return fmt.Errorf("ripe: failed to append row to batch: %w", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("ripe: failed to send batch: %w", err)
+	}
+	slog.Default().InfoContext(ctx, "inserted rows", "count", n, "dest", qualified)
+	return nil
+}
+
+// prefixNetwork returns the address of p as stored in the ripe tables: IPv4
+// mapped to IPv6, and the zero IPv6 address if p is invalid.
+func prefixNetwork(p netip.Prefix) net.IP {
+	if !p.IsValid() {
+		return net.IPv6zero
+	}
+	return net.IP(p.Masked().Addr().AsSlice()).To16()
+}
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+const (
+	DefaultTargetPrefixLenV4  = 24
+	DefaultTargetPrefixLenV6  = 48
+	DefaultTargetMinTTL       = 2
+	DefaultTargetMaxTTL       = 32
+	DefaultTargetInitialFlows = 6
+	DefaultTargetMaxPerPrefix = 1 << 16
+	DefaultTargetProtocol     = "icmp"
+	targetProtocolICMPv6      = "icmp6"
+	targetProtocolUDP         = "udp"
+	// targetProbedPrefixExpression cuts probe_dst_addr to the target
+	// granularity, given as the IPv4 and IPv6 prefix lengths.
+	targetProbedPrefixExpression = "toString(IPv6CIDRToRange(probe_dst_addr, toUInt8(if(isIPAddressInRange(toString(probe_dst_addr), '::ffff:0.0.0.0/96'), 96 + %d, %d))).1)"
+)
+
+// Bogons are the special-purpose and reserved address blocks that are never
+// probed, from the IANA IPv4 and IPv6 special-purpose address registries.
+var Bogons = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/8"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("3fff::/20"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// TargetsConfig holds the configuration for the TargetsService.
+type TargetsConfig struct {
+	// QueryTime selects the snapshot of the ripeprefixes table. If zero, the
+	// latest snapshot is used.
+	QueryTime time.Time
+	// IPVersion restricts the targets to one IP version: 0 = both, 4 or 6.
+	IPVersion uint8
+
+	PrefixLenV4 int // granularity of IPv4 targets
+	PrefixLenV6 int // granularity of IPv6 targets
+	// MaxPerPrefix bounds the number of targets a single announced prefix is
+	// split into. Larger prefixes are skipped and counted.
+	MaxPerPrefix int
+
+	// Protocol is icmp or udp. icmp is written as icmp6 for IPv6 targets.
+	Protocol     string
+	MinTTL       int
+	MaxTTL       int
+	InitialFlows int
+
+	// ExcludeBogons drops the targets overlapping Bogons.
+	ExcludeBogons bool
+	// Exclude drops the targets overlapping any of these prefixes.
+	Exclude []netip.Prefix
+	// ExcludeProbed lists results tables whose destination prefixes, at the
+	// target granularity, are dropped.
+	ExcludeProbed []store.DatabaseTable
+}
+
+// DefaultTargetsConfig returns a TargetsConfig with sensible defaults.
+func DefaultTargetsConfig() TargetsConfig {
+	return TargetsConfig{
+		PrefixLenV4:   DefaultTargetPrefixLenV4,
+		PrefixLenV6:   DefaultTargetPrefixLenV6,
+		MaxPerPrefix:  DefaultTargetMaxPerPrefix,
+		Protocol:      DefaultTargetProtocol,
+		MinTTL:        DefaultTargetMinTTL,
+		MaxTTL:        DefaultTargetMaxTTL,
+		InitialFlows:  DefaultTargetInitialFlows,
+		ExcludeBogons: true,
+	}
+}
+
+// TargetsStats summarises a target list generation.
+type TargetsStats struct {
+	QueryTime      time.Time
+	Announced      int // distinct announced prefixes read
+	OtherVersion   int // announced prefixes skipped because of IPVersion
+	Oversized      int // announced prefixes skipped because of MaxPerPrefix
+	ProbedPrefixes int // distinct prefixes read from the ExcludeProbed tables
+	Bogons         int // targets dropped as bogons
+	Excluded       int // targets dropped by Exclude
+	Probed         int // targets dropped because already probed
+	TargetsV4      int
+	TargetsV6      int
+}
+
+// TargetsService generates Iris target lists from a ripeprefixes table.
+type TargetsService struct {
+	store  *store.Store
+	config TargetsConfig
+}
+
+// NewTargetsService creates a new TargetsService with the given store and config.
+func NewTargetsService(s *store.Store, cfg TargetsConfig) *TargetsService {
+	return &TargetsService{
+		store:  s,
+		config: cfg,
+	}
+}
+
+// Validate checks the configuration.
+func (c TargetsConfig) Validate() error {
+	switch c.IPVersion {
+	case 0, 4, 6:
+	default:
+		return fmt.Errorf("targets: invalid IP version %d, expected 4 or 6", c.IPVersion)
+	}
+	if c.PrefixLenV4 < 1 || c.PrefixLenV4 > 32 {
+		return fmt.Errorf("targets: invalid IPv4 prefix length %d", c.PrefixLenV4)
+	}
+	if c.PrefixLenV6 < 1 || c.PrefixLenV6 > 128 {
+		return fmt.Errorf("targets: invalid IPv6 prefix length %d", c.PrefixLenV6)
+	}
+	if c.Protocol != DefaultTargetProtocol && c.Protocol != targetProtocolUDP {
+		return fmt.Errorf("targets: invalid protocol %q, expected icmp or udp", c.Protocol)
+	}
+	if c.MinTTL < 1 || c.MaxTTL > 255 || c.MinTTL > c.MaxTTL {
+		return fmt.Errorf("targets: invalid TTL range %d-%d", c.MinTTL, c.MaxTTL)
+	}
+	if c.InitialFlows < 1 {
+		return fmt.Errorf("targets: invalid number of initial flows %d", c.InitialFlows)
+	}
+	return nil
+}
+
+// Generate reads the announced prefixes of a snapshot of source, splits them
+// into targets, applies the exclusions and writes the target list to w, one
+// prefix,protocol,min_ttl,max_ttl,n_initial_flows line per target, IPv4
+// targets first, each in address order.
+func (t *TargetsService) Generate(ctx context.Context, source store.DatabaseTable, w io.Writer) (TargetsStats, error) {
+	log := slog.Default()
+	if err := t.config.Validate(); err != nil {
+		return TargetsStats{}, err
+	}
+
+	var stats TargetsStats
+	queryTime := t.config.QueryTime
+	if queryTime.IsZero() {
+		q := fmt.Sprintf("SELECT max(query_time) FROM %s.%s", source.Database, source.Table)
+		if err := t.store.QueryRow(ctx, q).Scan(&queryTime); err != nil {
+			return stats, fmt.Errorf("targets: failed to read latest snapshot of %s.%s: %w", source.Database, source.Table, err)
+		}
+	}
+	stats.QueryTime = queryTime.UTC()
+
+	announced, err := t.announcedPrefixes(ctx, source, queryTime)
+	if err != nil {
+		return stats, err
+	}
+	if len(announced) == 0 {
+		return stats, fmt.Errorf("targets: no prefixes in %s.%s at %s", source.Database, source.Table, stats.QueryTime.Format(time.RFC3339))
+	}
+	stats.Announced = len(announced)
+
+	probed, err := t.probedPrefixes(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.ProbedPrefixes = len(probed)
+
+	targets := make(map[netip.Prefix]struct{})
+	for _, p := range announced {
+		if t.config.IPVersion == 4 && !p.Addr().Is4() || t.config.IPVersion == 6 && p.Addr().Is4() {
+			stats.OtherVersion++
+			continue
+		}
+		bits := t.prefixLen(p.Addr())
+		if bits-p.Bits() > 62 || p.Bits() < bits && 1<<(bits-p.Bits()) > t.config.MaxPerPrefix {
+			stats.Oversized++
+			log.WarnContext(ctx, "skipping oversized prefix", "prefix", p, "max_per_prefix", t.config.MaxPerPrefix)
+			continue
+		}
+		for target := range splitPrefix(p, bits) {
+			targets[target] = struct{}{}
+		}
+	}
+
+	sorted := make([]netip.Prefix, 0, len(targets))
+	for target := range targets {
+		switch {
+		case t.config.ExcludeBogons && overlapsAny(target, Bogons):
+			stats.Bogons++
+		case overlapsAny(target, t.config.Exclude):
+			stats.Excluded++
+		case t.isProbed(target, probed):
+			stats.Probed++
+		default:
+			sorted = append(sorted, target)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b netip.Prefix) int {
+		return a.Addr().Compare(b.Addr())
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, target := range sorted {
+		protocol := t.config.Protocol
+		if target.Addr().Is6() && protocol == DefaultTargetProtocol {
+			protocol = targetProtocolICMPv6
+		}
+		if target.Addr().Is4() {
+			stats.TargetsV4++
+		} else {
+			stats.TargetsV6++
+		}
+		if _, err := fmt.Fprintf(bw, "%s,%s,%d,%d,%d\n", target, protocol, t.config.MinTTL, t.config.MaxTTL, t.config.InitialFlows); err != nil {
+			return stats, fmt.Errorf("targets: failed to write targets: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return stats, fmt.Errorf("targets: failed to write targets: %w", err)
+	}
+
+	log.InfoContext(ctx, "generated targets",
+		"source", fmt.Sprintf("%s.%s", source.Database, source.Table),
+		"query_time", stats.QueryTime,
+		"announced", stats.Announced,
+		"targets_v4", stats.TargetsV4,
+		"targets_v6", stats.TargetsV6,
+		"bogons", stats.Bogons,
+		"excluded", stats.Excluded,
+		"probed", stats.Probed,
+		"oversized", stats.Oversized,
+	)
+	return stats, nil
+}
+
+// announcedPrefixes returns the distinct prefixes of the snapshot, with
+// IPv4-mapped networks converted back to IPv4 prefixes.
+func (t *TargetsService) announcedPrefixes(ctx context.Context, source store.DatabaseTable, queryTime time.Time) ([]netip.Prefix, error) {
+	q := fmt.Sprintf("SELECT DISTINCT toString(network), prefix_len FROM %s.%s WHERE query_time = ?", source.Database, source.Table)
+	rows, err := t.store.Query(ctx, q, queryTime)
+	if err != nil {
+		return nil, fmt.Errorf("targets: failed to read prefixes of %s.%s: %w", source.Database, source.Table, err)
+	}
+	defer rows.Close()
+
+	var prefixes []netip.Prefix
+	for rows.Next() {
+		var (
+			network   string
+			prefixLen uint8
+		)
+		if err := rows.Scan(&network, &prefixLen); err != nil {
+			return nil, fmt.Errorf("targets: failed to scan prefix: %w", err)
+		}
+		p, err := storedPrefix(network, prefixLen)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("targets: failed to read prefixes: %w", err)
+	}
+	return prefixes, nil
+}
+
+// probedPrefixes returns the destination prefixes of the ExcludeProbed
+// tables, cut to the target granularity.
+func (t *TargetsService) probedPrefixes(ctx context.Context) (map[netip.Prefix]struct{}, error) {
+	probed := make(map[netip.Prefix]struct{})
+	for _, table := range t.config.ExcludeProbed {
+		expr := fmt.Sprintf(targetProbedPrefixExpression, t.config.PrefixLenV4, t.config.PrefixLenV6)
+		q := fmt.Sprintf("SELECT DISTINCT %s FROM %s.%s", expr, table.Database, table.Table)
+		rows, err := t.store.Query(ctx, q)
+		if err != nil {
+			return nil, fmt.Errorf("targets: failed to read probed prefixes of %s.%s: %w", table.Database, table.Table, err)
+		}
+		for rows.Next() {
+			var addr string
+			if err := rows.Scan(&addr); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("targets: failed to scan probed prefix: %w", err)
+			}
+			a, err := netip.ParseAddr(addr)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("targets: invalid probed prefix %q: %w", addr, err)
+			}
+			a = a.Unmap()
+			probed[netip.PrefixFrom(a, t.prefixLen(a))] = struct{}{}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("targets: failed to read probed prefixes: %w", err)
+		}
+	}
+	return probed, nil
+}
+
+// storedPrefix converts a network and prefix length as stored in the
+// ripeprefixes table into a masked prefix. IPv4 networks are stored
+// IPv4-mapped with their IPv4 prefix length.
+func storedPrefix(network string, prefixLen uint8) (netip.Prefix, error) {
+	a, err := netip.ParseAddr(network)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("targets: invalid network %q: %w", network, err)
+	}
+	p, err := a.Unmap().Prefix(int(prefixLen))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("targets: invalid prefix %s/%d: %w", network, prefixLen, err)
+	}
+	return p, nil
+}
+
+// splitPrefix yields the subprefixes of p of the given length. A prefix
+// longer than bits yields its covering prefix of that length.
+func splitPrefix(p netip.Prefix, bits int) func(yield func(netip.Prefix) bool) {
+	return func(yield func(netip.Prefix) bool) {
+		if p.Bits() >= bits {
+			covering, _ := p.Addr().Prefix(bits)
+			yield(covering)
+			return
+		}
+		addr := p.Masked().Addr()
+		for range 1 << (bits - p.Bits()) {
+			if !yield(netip.PrefixFrom(addr, bits)) {
+				return
+			}
+			addr = nextPrefixAddr(addr, bits)
+		}
+	}
+}
+
+// nextPrefixAddr returns the first address of the prefix of the given
+// length following the one containing addr.
+func nextPrefixAddr(addr netip.Addr, bits int) netip.Addr {
+	b := addr.AsSlice()
+	// Add 1 at bit position bits-1, propagating the carry.
+	i := (bits - 1) / 8
+	inc := 1 << (7 - (bits-1)%8)
+	for ; i >= 0; i-- {
+		sum := int(b[i]) + inc
+		b[i] = byte(sum)
+		if sum < 256 {
+			break
+		}
+		inc = 1
+	}
+	next, _ := netip.AddrFromSlice(b)
+	return next
+}
+
+func overlapsAny(p netip.Prefix, set []netip.Prefix) bool {
+	for _, q := range set {
+		if p.Overlaps(q) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixLen returns the target granularity of the IP version of a.
+func (t *TargetsService) prefixLen(a netip.Addr) int {
+	if a.Is4() {
+		return t.config.PrefixLenV4
+	}
+	return t.config.PrefixLenV6
+}
+
+// isProbed reports whether a target overlaps a probed prefix, cut to the
+// target granularity.
+func (t *TargetsService) isProbed(target netip.Prefix, probed map[netip.Prefix]struct{}) bool {
+	if len(probed) == 0 {
+		return false
+	}
+	bits := t.prefixLen(target.Addr())
+	if target.Bits() >= bits {
+		covering, _ := target.Addr().Prefix(bits)
+		_, ok := probed[covering]
+		return ok
+	}
+	for p := range probed {
+		if target.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsProbed(t *testing.T) {
+	config := DefaultTargetsConfig()
+	config.PrefixLenV4, config.PrefixLenV6 = 22, 40
+	svc := NewTargetsService(nil, config)
+
+	// Probed prefixes as read by probedPrefixes, cut to /22 and /40.
+	probed := make(map[netip.Prefix]struct{})
+	for _, a := range []string{"192.0.4.7", "2001:db8:ab00::1"} {
+		addr := netip.MustParseAddr(a)
+		p, _ := addr.Prefix(svc.prefixLen(addr))
+		probed[p] = struct{}{}
+	}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"192.0.4.0/22", true},
+		{"192.0.8.0/22", false},
+		{"192.0.6.0/24", true}, // a longer announced prefix, covered by the probed /22
+		{"192.0.0.0/16", true},
+		{"2001:db8:ab00::/40", true},
+		{"2001:db8:ac00::/40", false},
+		{"2001:db8:abcd::/48", true},
+	}
+	for _, tt := range tests {
+		if got := svc.isProbed(netip.MustParsePrefix(tt.target), probed); got != tt.want {
+			t.Errorf("isProbed(%s) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
@@ -129,6 +129,12 @@ func NewStore(config *StoreConfig) (*Store, error) {
 	}, nil
 }
 
+// Credentials returns the username and password of the store's connection,
+// for DDL that makes ClickHouse connect to itself, such as dictionary sources.
+func (s *Store) Credentials() (username, password string) {
+	return s.config.Username, s.config.Password
+}
+
 // PrepareTable prepares the destination table according to the given write policy
 // before any data is inserted. It must be called before writing rows to dest.
 // The schema DDL is rendered from the provided Schema using the destination
@@ -229,6 +235,27 @@ func (s *Store) TableSchema(ctx context.Context, dest DatabaseTable) (*schema.Dy
 	return schema, nil
 }
 
+// TableEngine returns the engine of dest, such as MergeTree, or an empty
+// string if the table does not exist.
+func (s *Store) TableEngine(ctx context.Context, dest DatabaseTable) (string, error) {
+	rows, err := s.Query(ctx,
+		"SELECT engine FROM system.tables WHERE database = ? AND name = ?",
+		dest.Database, dest.Table,
+	)
+	if err != nil {
+		return "", fmt.Errorf("store: failed to get table engine: %w", err)
+	}
+	defer rows.Close()
+
+	var engine string
+	if rows.Next() {
+		if err := rows.Scan(&engine); err != nil {
+			return "", fmt.Errorf("store: failed to scan table engine: %w", err)
+		}
+	}
+	return engine, rows.Err()
+}
+
 // RowCount returns the number of rows in dest, or 0 if the table does not exist.
 func (s *Store) RowCount(ctx context.Context, dest DatabaseTable) (uint64, error) {
 	return s.RowCountWhere(ctx, dest, "")
@@ -0,0 +1,72 @@
+// Package storetest connects tests to a scratch ClickHouse database.
+//
+// ClickHouse cannot be replaced by an in-memory stand-in like iristest or
+// ripetest, so the tests writing to a Store run against the server given by
+// the MPAT_TEST_CLICKHOUSE environment variable, a DSN such as
+// clickhouse://localhost:9000/mpat_test, and are skipped when it is not set.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dioptra-io/ufuk-research/internal/store"
+)
+
+// DSNEnv is the environment variable holding the DSN of the test server.
+const DSNEnv = "MPAT_TEST_CLICKHOUSE"
+
+// DSN returns the DSN of the test server, skipping the test if it is not set.
+func DSN(t testing.TB) string {
+	t.Helper()
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skip(DSNEnv + " is not set")
+	}
+	return dsn
+}
+
+// Open connects to the test server, skipping the test if it is not set.
+func Open(t testing.TB) *store.Store {
+	t.Helper()
+	config, err := store.ConfigFromDSN(DSN(t))
+	if err != nil {
+		t.Fatalf("ConfigFromDSN: %v", err)
+	}
+	s, err := store.NewStore(config)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return s
+}
+
+// Table returns a table of the current database named after the test, which
+// does not exist yet and is dropped when the test ends.
+func Table(t testing.TB, s *store.Store) store.DatabaseTable {
+	t.Helper()
+	var database string
+	if err := s.QueryRow(context.Background(), "SELECT currentDatabase()").Scan(&database); err != nil {
+		t.Fatalf("currentDatabase: %v", err)
+	}
+	name := strings.ToLower(strings.NewReplacer("/", "_", " ", "_", "-", "_").Replace(t.Name()))
+	dest := store.DatabaseTable{Database: database, Table: fmt.Sprintf("test_%s_%d", name, time.Now().UnixNano())}
+	t.Cleanup(func() {
+		_ = s.Exec(context.Background(), fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", dest.Database, dest.Table))
+	})
+	return dest
+}
+
+// RowCount returns the number of rows of dest matching where, all rows if
+// where is empty.
+func RowCount(t testing.TB, s *store.Store, dest store.DatabaseTable, where string) uint64 {
+	t.Helper()
+	n, err := s.RowCountWhere(context.Background(), dest, where)
+	if err != nil {
+		t.Fatalf("RowCountWhere: %v", err)
+	}
+	return n
+}